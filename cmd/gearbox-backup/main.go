@@ -0,0 +1,123 @@
+// gearbox-backup exports the resources served by a gearbox server to a NDJSON file,
+// and imports such a file back.
+//
+//	gearbox-backup export -server http://127.0.0.1:8080 -resources foos,bars -o backup.ndjson
+//	gearbox-backup import -server http://127.0.0.1:8080 -resources foos,bars -f backup.ndjson -dry-run
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sunyakun/gearbox/pkg/backup"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/signal"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s export|import [flags]\n", os.Args[0])
+	os.Exit(2)
+}
+
+func newResources(server, names string) []backup.Resource {
+	var resources []backup.Resource
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		client := rest.NewHTTPRestClient[backup.RawObject](name, server, nil, rest.ClientOptions{MaxRetries: 3})
+		resources = append(resources, backup.NewResource[backup.RawObject, *backup.RawObject](name, client))
+	}
+	return resources
+}
+
+func runExport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:8080", "the base url of the gearbox server")
+	names := fs.String("resources", "", "comma separated resource names to export")
+	output := fs.String("o", "-", "the output file, '-' means stdout")
+	pageSize := fs.Int("page-size", 100, "the number of objects fetched per request")
+	_ = fs.Parse(args)
+
+	resources := newResources(*server, *names)
+	if len(resources) == 0 {
+		return fmt.Errorf("at least one resource is required")
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return backup.Export(ctx, w, resources, backup.ExportOptions{PageSize: *pageSize})
+}
+
+func runImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	server := fs.String("server", "http://127.0.0.1:8080", "the base url of the gearbox server")
+	names := fs.String("resources", "", "comma separated resource names to import")
+	input := fs.String("f", "-", "the input file, '-' means stdin")
+	dryRun := fs.Bool("dry-run", false, "send the writes with dryRun=All, the server checks them but persists nothing")
+	overwrite := fs.Bool("overwrite", false, "drop the resourceVersion of the backup, the objects changed since the backup are overwritten instead of being conflicts")
+	_ = fs.Parse(args)
+
+	resources := newResources(*server, *names)
+	if len(resources) == 0 {
+		return fmt.Errorf("at least one resource is required")
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	report, err := backup.Import(ctx, r, resources, backup.ImportOptions{DryRun: *dryRun, Overwrite: *overwrite})
+	if report != nil {
+		fmt.Fprintf(os.Stderr, "created: %d, updated: %d, conflicts: %d\n", report.Created, report.Updated, len(report.Conflicts))
+		for _, c := range report.Conflicts {
+			fmt.Fprintf(os.Stderr, "  line %d: %s %q: %s\n", c.Line, c.Resource, c.Key, c.Message)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(report.Conflicts) != 0 {
+		return fmt.Errorf("%d objects not imported because of conflicts", len(report.Conflicts))
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx := signal.SetupSignalContext()
+
+	var err error
+	switch os.Args[1] {
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	case "import":
+		err = runImport(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/types"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const gearboxPkgPath = "github.com/sunyakun/gearbox/pkg/"
+
+var numericTypes = map[string]bool{
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"uint": true, "uint8": true, "uint16": true, "uint32": true, "uint64": true,
+	"float32": true, "float64": true, "byte": true, "rune": true,
+}
+
+// valueTypes are copied by assignment. apis.ObjectMeta is one of them as it has the scalar and
+// time.Time fields only, the deep-copy functions must be changed if a reference field is added to it.
+var valueTypes = map[string]bool{
+	"bool": true, "string": true, "uintptr": true, "complex64": true, "complex128": true,
+	"time.Time": true, "time.Duration": true, "apis.ObjectMeta": true,
+}
+
+type resource struct {
+	decl        *typeDecl
+	name        string
+	storage     *typeDecl
+	storagePkg  *sourcePackage
+	storageType string
+}
+
+type generator struct {
+	api      *sourcePackage
+	storages map[string]*sourcePackage
+	// candidates are the packages may be referenced by the generated code, alias -> import path
+	candidates map[string]string
+	warnings   []string
+
+	deepcopyQueue []string
+	deepcopySeen  map[string]bool
+}
+
+func newGenerator(api *sourcePackage) *generator {
+	return &generator{
+		api:      api,
+		storages: map[string]*sourcePackage{},
+		candidates: map[string]string{
+			"fmt":       "fmt",
+			"json":      "encoding/json",
+			"logr":      "github.com/go-logr/logr",
+			"admission": gearboxPkgPath + "admission",
+			"apis":      gearboxPkgPath + "apis",
+			"rest":      gearboxPkgPath + "rest",
+			"storage":   gearboxPkgPath + "storage",
+		},
+		deepcopySeen: map[string]bool{},
+	}
+}
+
+func (g *generator) warnf(format string, args ...any) {
+	g.warnings = append(g.warnings, fmt.Sprintf(format, args...))
+}
+
+// typeString prints the type expression as it should be written in the generated package
+func (g *generator) typeString(expr ast.Expr, pkg *sourcePackage, imports map[string]string) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if pkg != g.api && types.Universe.Lookup(e.Name) == nil {
+			g.candidates[pkg.name] = pkg.path
+			return pkg.name + "." + e.Name
+		}
+		return e.Name
+	case *ast.SelectorExpr:
+		if x, ok := e.X.(*ast.Ident); ok {
+			if path, ok := imports[x.Name]; ok {
+				g.candidates[x.Name] = path
+			}
+			return x.Name + "." + e.Sel.Name
+		}
+	case *ast.StarExpr:
+		return "*" + g.typeString(e.X, pkg, imports)
+	case *ast.ArrayType:
+		if e.Len == nil {
+			return "[]" + g.typeString(e.Elt, pkg, imports)
+		}
+		return "[" + types.ExprString(e.Len) + "]" + g.typeString(e.Elt, pkg, imports)
+	case *ast.MapType:
+		return "map[" + g.typeString(e.Key, pkg, imports) + "]" + g.typeString(e.Value, pkg, imports)
+	}
+	return types.ExprString(expr)
+}
+
+// localStruct returns true if t is a struct type declared in the API package
+func (g *generator) localStruct(t string) bool {
+	decl, ok := g.api.types[t]
+	if !ok {
+		return false
+	}
+	_, ok = decl.structType()
+	return ok
+}
+
+// isValueType returns true if the value of t can be copied by assignment
+func (g *generator) isValueType(t string) bool {
+	if valueTypes[t] || numericTypes[t] {
+		return true
+	}
+	if decl, ok := g.api.types[t]; ok {
+		if ident, ok := decl.spec.Type.(*ast.Ident); ok {
+			return g.isValueType(ident.Name)
+		}
+	}
+	return false
+}
+
+func (g *generator) isComposite(t string) bool {
+	switch {
+	case t == "[]byte":
+		return false
+	case strings.HasPrefix(t, "[]"), strings.HasPrefix(t, "map["):
+		return true
+	case strings.HasPrefix(t, "*"):
+		return g.isComposite(t[1:])
+	case g.localStruct(t):
+		return true
+	}
+	return strings.Contains(t, ".") && !valueTypes[t]
+}
+
+func isJSONColumn(t string) bool {
+	return t == "string" || t == "[]byte" || strings.HasSuffix(t, ".JSON")
+}
+
+// assign generates the statements that assign src to dst, the JSON columns are on the storage side
+func (g *generator) assign(dst, dstType, src, srcType string, toStorage bool) (string, error) {
+	switch {
+	case dstType == srcType:
+		return fmt.Sprintf("%s = %s\n", dst, src), nil
+	case numericTypes[dstType] && numericTypes[srcType]:
+		return fmt.Sprintf("%s = %s(%s)\n", dst, dstType, src), nil
+	case dstType == "*"+srcType:
+		return fmt.Sprintf("{\nv := %s\n%s = &v\n}\n", src, dst), nil
+	case srcType == "*"+dstType:
+		return fmt.Sprintf("if %s != nil {\n%s = *%s\n}\n", src, dst, src), nil
+	case toStorage && isJSONColumn(dstType) && g.isComposite(srcType):
+		return fmt.Sprintf("if bs, err := json.Marshal(%s); err != nil {\nreturn fmt.Errorf(\"marshal %s failed: %%w\", err)\n} else {\n%s = %s(bs)\n}\n",
+			src, src, dst, dstType), nil
+	case !toStorage && isJSONColumn(srcType) && g.isComposite(dstType):
+		return fmt.Sprintf("if len(%s) != 0 {\nif err := json.Unmarshal([]byte(%s), &%s); err != nil {\nreturn fmt.Errorf(\"unmarshal %s failed: %%w\", err)\n}\n}\n",
+			src, src, dst, src), nil
+	}
+	return "", fmt.Errorf("can't convert %s (%s) to %s (%s)", src, srcType, dst, dstType)
+}
+
+func (g *generator) resolveStorage(marker string) (*sourcePackage, *typeDecl, error) {
+	path, name := "", marker
+	if idx := strings.LastIndex(marker, "."); idx >= 0 {
+		path, name = marker[:idx], marker[idx+1:]
+	}
+
+	pkg := g.api
+	if path != "" && path != g.api.path {
+		pkg = g.storages[path]
+		if pkg == nil {
+			dir, err := goList(g.api.dir, "{{.Dir}}", path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if pkg, err = loadPackage(dir, ""); err != nil {
+				return nil, nil, err
+			}
+			g.storages[path] = pkg
+		}
+	}
+
+	decl, ok := pkg.types[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("type %s not found in %s", name, pkg.path)
+	}
+	if _, ok := decl.structType(); !ok {
+		return nil, nil, fmt.Errorf("%s.%s is not a struct", pkg.path, name)
+	}
+	return pkg, decl, nil
+}
+
+type storageFields struct {
+	byName   map[string]*ast.Field
+	byColumn map[string]*ast.Field
+}
+
+func indexFields(st *ast.StructType) storageFields {
+	fields := storageFields{byName: map[string]*ast.Field{}, byColumn: map[string]*ast.Field{}}
+	for _, f := range st.Fields.List {
+		for _, name := range f.Names {
+			fields.byName[name.Name] = f
+		}
+		if column := gormColumn(f); column != "" && len(f.Names) == 1 {
+			fields.byColumn[column] = f
+		}
+	}
+	return fields
+}
+
+func (g *generator) genConverter(w *bytes.Buffer, res *resource) error {
+	apiStruct, _ := res.decl.structType()
+	stStruct, _ := res.storage.structType()
+	stFields := indexFields(stStruct)
+
+	var from, to bytes.Buffer
+	mapField := func(apiName, apiType, stName string, stExpr ast.Expr) error {
+		stType := g.typeString(stExpr, res.storagePkg, res.storage.imports)
+		code, err := g.assign("to."+stName, stType, "from."+apiName, apiType, true)
+		if err != nil {
+			return fmt.Errorf("%s: %w", res.decl.name, err)
+		}
+		to.WriteString(code)
+		code, err = g.assign("to."+apiName, apiType, "from."+stName, stType, false)
+		if err != nil {
+			return fmt.Errorf("%s: %w", res.decl.name, err)
+		}
+		from.WriteString(code)
+		return nil
+	}
+
+	// ObjectMeta
+	for _, meta := range []struct {
+		marker, field, typ, def string
+	}{
+		{"key", "Key", "string", "Key"},
+		{"resourceVersion", "ResourceVersion", "string", ""},
+		{"createTime", "CreateTime", "time.Time", ""},
+		{"updateTime", "UpdateTime", "time.Time", ""},
+	} {
+		name := res.decl.markers[meta.marker]
+		if name == "" {
+			name = meta.def
+		}
+		if name == "" {
+			continue
+		}
+		f, ok := stFields.byName[name]
+		if !ok {
+			return fmt.Errorf("%s: the storage type %s has no field %s", res.decl.name, res.storageType, name)
+		}
+		if err := mapField(meta.field, meta.typ, name, f.Type); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range apiStruct.Fields.List {
+		if len(f.Names) == 0 {
+			if t := g.typeString(f.Type, g.api, res.decl.imports); t != "apis.ObjectMeta" {
+				g.warnf("%s: the embedded field %s is not converted", res.decl.name, t)
+			}
+			continue
+		}
+		column, skip := gearboxTag(f)
+		if skip {
+			continue
+		}
+		apiType := g.typeString(f.Type, g.api, res.decl.imports)
+		for _, name := range f.Names {
+			if !name.IsExported() {
+				continue
+			}
+			var stField *ast.Field
+			stName := name.Name
+			if column != "" {
+				stField = stFields.byColumn[column]
+				if stField == nil {
+					return fmt.Errorf("%s.%s: the storage type %s has no column %q", res.decl.name, name.Name, res.storageType, column)
+				}
+				stName = stField.Names[0].Name
+			} else if stField = stFields.byName[name.Name]; stField == nil {
+				g.warnf("%s.%s has no counterpart in %s, it is not converted", res.decl.name, name.Name, res.storageType)
+				continue
+			}
+			if err := mapField(name.Name, apiType, stName, stField.Type); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "// %sConverter converts %s from and to the storage type %s.\n", res.decl.name, res.decl.name, res.storageType)
+	fmt.Fprintf(w, "type %sConverter struct{}\n\n", res.decl.name)
+	fmt.Fprintf(w, "var _ rest.Converter[*%s, %s] = %sConverter{}\n\n", res.decl.name, res.storageType, res.decl.name)
+	fmt.Fprintf(w, "func (%sConverter) FromStorage(from *%s, to *%s) error {\n%sreturn nil\n}\n\n", res.decl.name, res.storageType, res.decl.name, from.String())
+	fmt.Fprintf(w, "func (%sConverter) ToStorage(from *%s, to *%s) error {\n%sreturn nil\n}\n\n", res.decl.name, res.decl.name, res.storageType, to.String())
+	return nil
+}
+
+func (g *generator) enqueueDeepCopy(name string) {
+	if !g.deepcopySeen[name] {
+		g.deepcopySeen[name] = true
+		g.deepcopyQueue = append(g.deepcopyQueue, name)
+	}
+}
+
+func (g *generator) genDeepCopyField(w *bytes.Buffer, typeName, name, t string) {
+	switch {
+	case g.isValueType(t):
+	case g.localStruct(t):
+		fmt.Fprintf(w, "in.%s.DeepCopyInto(&out.%s)\n", name, name)
+		g.enqueueDeepCopy(t)
+	case strings.HasPrefix(t, "*") && g.isValueType(t[1:]):
+		fmt.Fprintf(w, "if in.%s != nil {\nin, out := &in.%s, &out.%s\n*out = new(%s)\n**out = **in\n}\n", name, name, name, t[1:])
+	case strings.HasPrefix(t, "*") && g.localStruct(t[1:]):
+		fmt.Fprintf(w, "if in.%s != nil {\nin, out := &in.%s, &out.%s\n*out = new(%s)\n(*in).DeepCopyInto(*out)\n}\n", name, name, name, t[1:])
+		g.enqueueDeepCopy(t[1:])
+	case strings.HasPrefix(t, "[]") && g.isValueType(t[2:]), t == "[]byte":
+		fmt.Fprintf(w, "if in.%s != nil {\nin, out := &in.%s, &out.%s\n*out = make(%s, len(*in))\ncopy(*out, *in)\n}\n", name, name, name, t)
+	case strings.HasPrefix(t, "[]") && g.localStruct(t[2:]):
+		fmt.Fprintf(w, "if in.%s != nil {\nin, out := &in.%s, &out.%s\n*out = make(%s, len(*in))\nfor i := range *in {\n(*in)[i].DeepCopyInto(&(*out)[i])\n}\n}\n", name, name, name, t)
+		g.enqueueDeepCopy(t[2:])
+	case strings.HasPrefix(t, "map["):
+		key, value, _ := strings.Cut(t[len("map["):], "]")
+		if !g.isValueType(key) || (!g.isValueType(value) && !g.localStruct(value)) {
+			g.warnf("%s.%s (%s) is copied shallowly", typeName, name, t)
+			return
+		}
+		fmt.Fprintf(w, "if in.%s != nil {\nin, out := &in.%s, &out.%s\n*out = make(%s, len(*in))\nfor key, val := range *in {\n", name, name, name, t)
+		if g.localStruct(value) {
+			fmt.Fprintf(w, "(*out)[key] = *val.DeepCopy()\n}\n}\n")
+			g.enqueueDeepCopy(value)
+		} else {
+			fmt.Fprintf(w, "(*out)[key] = val\n}\n}\n")
+		}
+	default:
+		g.warnf("%s.%s (%s) is copied shallowly", typeName, name, t)
+	}
+}
+
+func (g *generator) genDeepCopy(w *bytes.Buffer, name string) {
+	decl := g.api.types[name]
+	st, _ := decl.structType()
+
+	fmt.Fprintf(w, "// DeepCopyInto copies the receiver into out, in must be non-nil.\n")
+	fmt.Fprintf(w, "func (in *%s) DeepCopyInto(out *%s) {\n*out = *in\n", name, name)
+	for _, f := range st.Fields.List {
+		t := g.typeString(f.Type, g.api, decl.imports)
+		if len(f.Names) == 0 {
+			embedded := t[strings.LastIndex(t, ".")+1:]
+			g.genDeepCopyField(w, name, strings.TrimPrefix(embedded, "*"), t)
+			continue
+		}
+		for _, fieldName := range f.Names {
+			g.genDeepCopyField(w, name, fieldName.Name, t)
+		}
+	}
+	fmt.Fprintf(w, "}\n\n")
+
+	fmt.Fprintf(w, "// DeepCopy creates a new %s copied from the receiver.\n", name)
+	fmt.Fprintf(w, "func (in *%s) DeepCopy() *%s {\nif in == nil {\nreturn nil\n}\nout := new(%s)\nin.DeepCopyInto(out)\nreturn out\n}\n\n", name, name, name)
+}
+
+func (g *generator) genWiring(w *bytes.Buffer, resources []*resource) {
+	var objs []string
+	for _, res := range resources {
+		objs = append(objs, "&"+res.decl.name+"{}")
+	}
+	fmt.Fprintf(w, "// AddToScheme registers the resource types in the scheme.\n")
+	fmt.Fprintf(w, "func AddToScheme(scheme *apis.Scheme) error {\nreturn scheme.AddKnownTypes(%s)\n}\n\n", strings.Join(objs, ", "))
+
+	for _, res := range resources {
+		n := res.decl.name
+		fmt.Fprintf(w, "const %sResourceName = %q\n\n", n, res.name)
+		fmt.Fprintf(w, "// New%sRestAPI creates the RestAPI serves %s.\n", n, n)
+		fmt.Fprintf(w, "func New%sRestAPI(store storage.WatchableStore[%s], scheme *apis.Scheme, logger logr.Logger, admits []admission.Interface) *rest.RestAPI[%s, *%s, %s] {\n",
+			n, res.storageType, n, n, res.storageType)
+		fmt.Fprintf(w, "return rest.NewRestAPI[%s, *%s, %s](%sResourceName, store, scheme, %sConverter{}, logger, admits)\n}\n\n",
+			n, n, res.storageType, n, n)
+		fmt.Fprintf(w, "// New%sClient creates a HTTP client of %s.\n", n, n)
+		fmt.Fprintf(w, "func New%sClient(baseurl string, opts rest.ClientOptions) *rest.HTTPRestClient[%s, *%s] {\nreturn rest.NewHTTPRestClient[%s, *%s](%sResourceName, baseurl, nil, opts)\n}\n\n",
+			n, n, n, n, n, n)
+	}
+}
+
+func (g *generator) generate() ([]byte, error) {
+	var resources []*resource
+	for _, name := range g.api.order {
+		decl := g.api.types[name]
+		resName := decl.markers["resource"]
+		if resName == "" {
+			continue
+		}
+		if _, ok := decl.structType(); !ok {
+			return nil, fmt.Errorf("%s is not a struct", name)
+		}
+		if decl.markers["storage"] == "" {
+			return nil, fmt.Errorf("%s: the marker %sstorage is required", name, markerPrefix)
+		}
+		pkg, storage, err := g.resolveStorage(decl.markers["storage"])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		res := &resource{decl: decl, name: resName, storage: storage, storagePkg: pkg, storageType: storage.name}
+		if pkg != g.api {
+			g.candidates[pkg.name] = pkg.path
+			res.storageType = pkg.name + "." + storage.name
+		}
+		resources = append(resources, res)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("no type annotated with %sresource in %s", markerPrefix, g.api.dir)
+	}
+
+	var body bytes.Buffer
+	for _, res := range resources {
+		if err := g.genConverter(&body, res); err != nil {
+			return nil, err
+		}
+		g.enqueueDeepCopy(res.decl.name)
+	}
+	for i := 0; i < len(g.deepcopyQueue); i++ {
+		g.genDeepCopy(&body, g.deepcopyQueue[i])
+	}
+	g.genWiring(&body, resources)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by gearbox-gen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", g.api.name)
+	var aliases []string
+	for alias := range g.candidates {
+		aliases = append(aliases, alias)
+	}
+	sort.Slice(aliases, func(i, j int) bool { return g.candidates[aliases[i]] < g.candidates[aliases[j]] })
+	var std, others bytes.Buffer
+	for _, alias := range aliases {
+		if !regexp.MustCompile(`\b` + regexp.QuoteMeta(alias) + `\.`).Match(body.Bytes()) {
+			continue
+		}
+		path := g.candidates[alias]
+		w := &others
+		if !strings.Contains(strings.Split(path, "/")[0], ".") {
+			w = &std
+		}
+		if alias == path[strings.LastIndex(path, "/")+1:] {
+			fmt.Fprintf(w, "%q\n", path)
+		} else {
+			fmt.Fprintf(w, "%s %q\n", alias, path)
+		}
+	}
+	fmt.Fprintf(&out, "%s\n%s)\n\n", std.String(), others.String())
+	out.Write(body.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code failed: %w\n%s", err, out.String())
+	}
+	return src, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestLoadPackage(t *testing.T) {
+	pkg, err := loadPackage("testdata/api", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "api", pkg.name)
+	assert.Equal(t, "github.com/sunyakun/gearbox/cmd/gearbox-gen/testdata/api", pkg.path)
+	assert.Equal(t, []string{"Foo", "Port", "Bar", "BarModel"}, pkg.order)
+	assert.Equal(t, map[string]string{
+		"resource":        "foos",
+		"storage":         "github.com/sunyakun/gearbox/cmd/gearbox-gen/testdata/model.Foo",
+		"key":             "Name",
+		"resourceVersion": "Revision",
+		"createTime":      "CreatedAt",
+	}, pkg.types["Foo"].markers)
+	assert.Empty(t, pkg.types["Port"].markers)
+	assert.Equal(t, "github.com/sunyakun/gearbox/pkg/apis", pkg.types["Foo"].imports["apis"])
+
+	st, ok := pkg.types["Foo"].structType()
+	assert.True(t, ok)
+	var tags [][2]any
+	for _, f := range st.Fields.List[1:] {
+		column, skip := gearboxTag(f)
+		tags = append(tags, [2]any{column, skip})
+	}
+	assert.Equal(t, [][2]any{{"", false}, {"image_name", false}, {"", false}, {"", false}, {"", true}}, tags)
+}
+
+func TestGenerate(t *testing.T) {
+	pkg, err := loadPackage("testdata/api", "")
+	assert.Nil(t, err)
+	g := newGenerator(pkg)
+	src, err := g.generate()
+	assert.Nil(t, err)
+	assert.Empty(t, g.warnings)
+
+	golden := filepath.Join("testdata", "api", "zz_generated.gearbox.go.golden")
+	if *update {
+		assert.Nil(t, os.WriteFile(golden, src, 0644))
+	}
+	expected, err := os.ReadFile(golden)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(src))
+}
+
+// TestObjectMetaValueType guards that apis.ObjectMeta can be copied by assignment
+func TestObjectMetaValueType(t *testing.T) {
+	rt := reflect.TypeOf(apis.ObjectMeta{})
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Chan, reflect.Func:
+			t.Errorf("apis.ObjectMeta.%s (%s) isn't copied by assignment", f.Name, f.Type)
+		case reflect.Struct:
+			assert.Equal(t, reflect.TypeOf(time.Time{}), f.Type, f.Name)
+		}
+	}
+}
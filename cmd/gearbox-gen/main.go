@@ -0,0 +1,60 @@
+// gearbox-gen generates the boilerplate needed to serve a resource with gearbox.
+//
+// The API types are annotated by markers in their doc comments:
+//
+//	// +gearbox:resource=foos
+//	// +gearbox:storage=github.com/example/app/model.Foo
+//	// +gearbox:key=Name
+//	// +gearbox:resourceVersion=Revision
+//	type Foo struct {
+//		apis.ObjectMeta
+//		Replicas int    `json:"replicas"`
+//		Image    string `json:"image" gearbox:"column=image_name"`
+//		Internal string `json:"-" gearbox:"-"`
+//	}
+//
+// <resource> is the resource name, <storage> is the gorm model, it can be a bare
+// type name if the model is in the same package. <key>, <resourceVersion>,
+// <createTime> and <updateTime> name the model fields mapped to the ObjectMeta,
+// <key> defaults to "Key". The other fields are mapped by name, or by the gorm column
+// given in the `gearbox:"column=..."` tag.
+//
+// For every annotated type it generates the converter, the deep-copy functions,
+// the scheme registration, the RestAPI constructor and a typed HTTP client.
+//
+//	//go:generate go run github.com/sunyakun/gearbox/cmd/gearbox-gen -dir .
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "the directory of the package contains the API types")
+	output := flag.String("output", "zz_generated.gearbox.go", "the generated file name, relative to -dir")
+	flag.Parse()
+
+	if err := run(*dir, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "gearbox-gen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, output string) error {
+	apiPkg, err := loadPackage(dir, output)
+	if err != nil {
+		return err
+	}
+	g := newGenerator(apiPkg)
+	src, err := g.generate()
+	if err != nil {
+		return err
+	}
+	for _, warning := range g.warnings {
+		fmt.Fprintf(os.Stderr, "gearbox-gen: warning: %s\n", warning)
+	}
+	return os.WriteFile(filepath.Join(dir, output), src, 0644)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm/schema"
+
+	"github.com/sunyakun/gearbox/pkg/util"
+)
+
+const markerPrefix = "+gearbox:"
+
+// typeDecl is a struct type declared in a parsed package
+type typeDecl struct {
+	name    string
+	spec    *ast.TypeSpec
+	markers map[string]string
+	// imports of the file declares the type, alias -> import path
+	imports map[string]string
+}
+
+func (t *typeDecl) structType() (*ast.StructType, bool) {
+	st, ok := t.spec.Type.(*ast.StructType)
+	return st, ok
+}
+
+type sourcePackage struct {
+	name  string
+	path  string
+	dir   string
+	types map[string]*typeDecl
+	// order keeps the declaration order of the types
+	order []string
+}
+
+func goList(dir, format string, args ...string) (string, error) {
+	cmd := exec.Command("go", append([]string{"list", "-f", format}, args...)...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go list %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func parseMarkers(groups ...*ast.CommentGroup) map[string]string {
+	markers := map[string]string{}
+	for _, group := range groups {
+		if group == nil {
+			continue
+		}
+		for _, c := range group.List {
+			text := strings.TrimSpace(strings.TrimPrefix(c.Text, "//"))
+			if !strings.HasPrefix(text, markerPrefix) {
+				continue
+			}
+			name, value, _ := strings.Cut(strings.TrimPrefix(text, markerPrefix), "=")
+			markers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return markers
+}
+
+func fileImports(f *ast.File) map[string]string {
+	imports := map[string]string{}
+	for _, spec := range f.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		imports[name] = path
+	}
+	return imports
+}
+
+// loadPackage parse the go files in dir, the file named skipFile is ignored
+func loadPackage(dir, skipFile string) (*sourcePackage, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, absDir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != skipFile
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect exactly one package in %s, got %d", dir, len(pkgs))
+	}
+
+	pkg := &sourcePackage{dir: absDir, types: map[string]*typeDecl{}}
+	for _, p := range pkgs {
+		pkg.name = p.Name
+		var filenames []string
+		for filename := range p.Files {
+			filenames = append(filenames, filename)
+		}
+		// keep the output stable
+		sort.Strings(filenames)
+		for _, filename := range filenames {
+			f := p.Files[filename]
+			imports := fileImports(f)
+			for _, decl := range f.Decls {
+				gd, ok := decl.(*ast.GenDecl)
+				if !ok || gd.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gd.Specs {
+					ts := spec.(*ast.TypeSpec)
+					var groups = []*ast.CommentGroup{ts.Doc}
+					if len(gd.Specs) == 1 {
+						groups = append(groups, gd.Doc)
+					}
+					pkg.types[ts.Name.Name] = &typeDecl{
+						name:    ts.Name.Name,
+						spec:    ts,
+						markers: parseMarkers(groups...),
+						imports: imports,
+					}
+					pkg.order = append(pkg.order, ts.Name.Name)
+				}
+			}
+		}
+	}
+
+	pkg.path, err = goList(absDir, "{{.ImportPath}}", ".")
+	if err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// fieldTag returns the value of the key in the struct tag of the field
+func fieldTag(f *ast.Field, key string) string {
+	if f.Tag == nil {
+		return ""
+	}
+	tag, err := strconv.Unquote(f.Tag.Value)
+	if err != nil {
+		return ""
+	}
+	return reflect.StructTag(tag).Get(key)
+}
+
+// gearboxTag parse the `gearbox:"column=xxx"` and `gearbox:"-"` tag
+func gearboxTag(f *ast.Field) (column string, skip bool) {
+	return util.ParseGearboxTag(fieldTag(f, "gearbox"))
+}
+
+// gormColumn returns the column name in the gorm tag of the field
+func gormColumn(f *ast.Field) string {
+	return schema.ParseTagSetting(fieldTag(f, "gorm"), ";")["COLUMN"]
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"github.com/sunyakun/gearbox/pkg/apis"
+)
+
+// Foo is the resource with the model in another package
+// +gearbox:resource=foos
+// +gearbox:storage=github.com/sunyakun/gearbox/cmd/gearbox-gen/testdata/model.Foo
+// +gearbox:key=Name
+// +gearbox:resourceVersion=Revision
+// +gearbox:createTime=CreatedAt
+type Foo struct {
+	apis.ObjectMeta
+	Replicas int               `json:"replicas"`
+	Image    string            `json:"image" gearbox:"column=image_name"`
+	Labels   map[string]string `json:"labels"`
+	Ports    []Port            `json:"ports"`
+	Internal string            `json:"-" gearbox:"-"`
+}
+
+type Port struct {
+	Name string `json:"name"`
+	Port *int32 `json:"port"`
+}
+
+// Bar is the resource with the model in the same package
+// +gearbox:resource=bars
+// +gearbox:storage=BarModel
+type Bar struct {
+	apis.ObjectMeta
+	Value string `json:"value"`
+}
+
+type BarModel struct {
+	Key   string `gorm:"column:key"`
+	Value string `gorm:"column:value"`
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+type Foo struct {
+	ID        uint      `gorm:"column:id;primaryKey"`
+	Name      string    `gorm:"column:name;uniqueIndex"`
+	Revision  string    `gorm:"column:revision"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+	Replicas  int64     `gorm:"column:replicas"`
+	ImageName string    `gorm:"column:image_name"`
+	Labels    string    `gorm:"column:labels"`
+	Ports     []byte    `gorm:"column:ports"`
+}
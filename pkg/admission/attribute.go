@@ -1,17 +1,32 @@
 package admission
 
-import "github.com/sunyakun/gearbox/pkg/apis"
+import (
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+)
 
+// Attribute implements the Attributes.
+// <OldObject> is the stored object of update and delete, for delete <Object> is it too.
+// <UserInfo> is nil if the request isn't authenticated, e.g. from the in-process controllers.
 type Attribute struct {
 	Object       apis.Object
+	OldObject    apis.Object
 	Operation    Operation
 	ResourceName string
+	Subresource  string
+	Kind         apis.GroupVersionKind
+	UserInfo     *authentication.UserInfo
+	DryRun       bool
 }
 
 func (a *Attribute) GetObject() apis.Object {
 	return a.Object
 }
 
+func (a *Attribute) GetOldObject() apis.Object {
+	return a.OldObject
+}
+
 func (a *Attribute) GetOperation() Operation {
 	return a.Operation
 }
@@ -19,3 +34,19 @@ func (a *Attribute) GetOperation() Operation {
 func (a *Attribute) GetResource() string {
 	return a.ResourceName
 }
+
+func (a *Attribute) GetSubresource() string {
+	return a.Subresource
+}
+
+func (a *Attribute) GetKind() apis.GroupVersionKind {
+	return a.Kind
+}
+
+func (a *Attribute) GetUserInfo() *authentication.UserInfo {
+	return a.UserInfo
+}
+
+func (a *Attribute) IsDryRun() bool {
+	return a.DryRun
+}
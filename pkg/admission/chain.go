@@ -1,6 +1,14 @@
 package admission
 
-import "context"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
 
 // chainAdmissionHandler is an instance of admission.NamedHandler that performs admission control using
 // a chain of admission handlers
@@ -11,36 +19,87 @@ func NewChainHandler(handlers ...Interface) chainAdmissionHandler {
 	return chainAdmissionHandler(handlers)
 }
 
-// Admit performs an admission control check using a chain of handlers, and returns immediately on first error
+// Admit runs the mutating handlers in order and returns immediately on first error. If a handler
+// changed the object, the handlers run before it are invoked once more so that they can see the change.
 func (admissionHandler chainAdmissionHandler) Admit(ctx context.Context, a Attributes) error {
-	for _, handler := range admissionHandler {
+	changed, err := admissionHandler.admit(ctx, a, len(admissionHandler))
+	if err != nil {
+		return err
+	}
+	if changed > 0 {
+		_, err = admissionHandler.admit(ctx, a, changed)
+	}
+	return err
+}
+
+// admit runs the first <n> mutating handlers, <changed> is the index of the last handler that changed the object
+func (admissionHandler chainAdmissionHandler) admit(ctx context.Context, a Attributes, n int) (changed int, err error) {
+	before, err := json.Marshal(a.GetObject())
+	if err != nil {
+		return 0, err
+	}
+	for i, handler := range admissionHandler[:n] {
 		if !handler.Handles(a.GetOperation()) {
 			continue
 		}
-		if mutator, ok := handler.(MutationInterface); ok {
-			err := mutator.Admit(ctx, a)
-			if err != nil {
-				return err
-			}
+		mutator, ok := handler.(MutationInterface)
+		if !ok {
+			continue
+		}
+		if err := mutator.Admit(ctx, a); err != nil {
+			return 0, pluginError(a, handler, err)
+		}
+		after, err := json.Marshal(a.GetObject())
+		if err != nil {
+			return 0, err
+		}
+		if string(after) != string(before) {
+			changed, before = i, after
 		}
 	}
-	return nil
+	return changed, nil
 }
 
-// Validate performs an admission control check using a chain of handlers, and returns immediately on first error
+// Validate performs an admission control check using a chain of handlers, all the handlers are run
+// and the errors of them are aggregated
 func (admissionHandler chainAdmissionHandler) Validate(ctx context.Context, a Attributes) error {
+	var errs []errors.StatusError
 	for _, handler := range admissionHandler {
 		if !handler.Handles(a.GetOperation()) {
 			continue
 		}
 		if validator, ok := handler.(ValidationInterface); ok {
-			err := validator.Validate(ctx, a)
-			if err != nil {
-				return err
+			if err := validator.Validate(ctx, a); err != nil {
+				errs = append(errs, pluginError(a, handler, err))
 			}
 		}
 	}
-	return nil
+	if len(errs) <= 1 {
+		if len(errs) == 1 {
+			return errs[0]
+		}
+		return nil
+	}
+	// the aggregated error has the status of the first one and the causes of all of them
+	messages := make([]string, 0, len(errs))
+	var causes []apis.StatusCause
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+		if err.ErrStatus.Details != nil {
+			causes = append(causes, err.ErrStatus.Details.Causes...)
+		}
+	}
+	aggregated := errs[0]
+	aggregated.ErrStatus.Message = strings.Join(messages, "; ")
+	if causes != nil {
+		details := apis.StatusDetails{}
+		if aggregated.ErrStatus.Details != nil {
+			details = *aggregated.ErrStatus.Details
+		}
+		details.Causes = causes
+		aggregated.ErrStatus.Details = &details
+	}
+	return aggregated
 }
 
 // Handles will return true if any of the handlers handles the given operation
@@ -52,3 +111,26 @@ func (admissionHandler chainAdmissionHandler) Handles(operation Operation) bool
 	}
 	return false
 }
+
+// pluginName is the name of the NamedInterface or the type of the handler
+func pluginName(handler Interface) string {
+	if named, ok := handler.(NamedInterface); ok {
+		return named.Name()
+	}
+	return fmt.Sprintf("%T", handler)
+}
+
+// pluginError attaches the plugin name to the error, the errors that aren't StatusError are forbidden
+func pluginError(a Attributes, handler Interface, err error) errors.StatusError {
+	message := fmt.Sprintf("admission plugin %q denied the request: %s", pluginName(handler), err.Error())
+	if s, ok := errors.FromError(err); ok {
+		status := s.Status()
+		status.Message = message
+		return errors.StatusError{ErrStatus: status}
+	}
+	var key string
+	if obj := a.GetObject(); obj != nil {
+		key = obj.GetKey()
+	}
+	return errors.NewForbidden(strings.ToLower(string(a.GetOperation())), a.GetResource(), key, message)
+}
@@ -0,0 +1,78 @@
+package admission
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
+
+type fakeObject struct {
+	apis.ObjectMeta
+	Labels map[string]string `json:"labels"`
+}
+
+// labeler sets the label if it's absent and records the labels it saw
+type labeler struct {
+	name, value string
+	seen        []map[string]string
+}
+
+func (l *labeler) Handles(operation Operation) bool { return true }
+
+func (l *labeler) Admit(ctx context.Context, a Attributes) error {
+	obj := a.GetObject().(*fakeObject)
+	seen := map[string]string{}
+	for k, v := range obj.Labels {
+		seen[k] = v
+	}
+	l.seen = append(l.seen, seen)
+	if _, ok := obj.Labels[l.name]; !ok {
+		obj.Labels[l.name] = l.value
+	}
+	return nil
+}
+
+type denier struct {
+	name string
+	err  error
+}
+
+func (d *denier) Handles(operation Operation) bool { return true }
+
+func (d *denier) Name() string { return d.name }
+
+func (d *denier) Validate(ctx context.Context, a Attributes) error { return d.err }
+
+func TestChainAdmitReinvocation(t *testing.T) {
+	first := &labeler{name: "a", value: "1"}
+	second := &labeler{name: "b", value: "2"}
+	chain := NewChainHandler(first, second)
+	obj := &fakeObject{Labels: map[string]string{}}
+
+	assert.Nil(t, chain.Admit(context.Background(), &Attribute{Object: obj, Operation: Create}))
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, obj.Labels)
+	// the first one is reinvoked because the second one changed the object
+	assert.Len(t, first.seen, 2)
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, first.seen[1])
+	assert.Len(t, second.seen, 1)
+}
+
+func TestChainValidateAggregate(t *testing.T) {
+	chain := NewChainHandler(
+		&denier{name: "quota", err: fmt.Errorf("quota exceeded")},
+		&denier{name: "allowed"},
+		&denier{name: "image", err: errors.NewBadRequest("image is required")},
+	)
+	obj := &fakeObject{ObjectMeta: apis.ObjectMeta{Key: "foo"}}
+
+	err := chain.Validate(context.Background(), &Attribute{Object: obj, Operation: Create, ResourceName: "foos"})
+	assert.True(t, errors.IsForbiddenError(err))
+	assert.Contains(t, err.Error(), `admission plugin "quota" denied the request: quota exceeded`)
+	assert.Contains(t, err.Error(), `admission plugin "image" denied the request: image is required`)
+	assert.NotContains(t, err.Error(), "allowed")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
 )
 
 // Operation is the type of resource operation being checked for admission control
@@ -22,10 +23,21 @@ const (
 type Attributes interface {
 	// GetOperation is the operation being performed
 	GetOperation() Operation
-	// GetObject is the object from the incoming request
+	// GetObject is the object from the incoming request, for delete it's the stored object
 	GetObject() apis.Object
+	// GetOldObject is the stored object of update and delete, it's nil for create
+	GetOldObject() apis.Object
 	// GetResource is the resource name
 	GetResource() string
+	// GetSubresource is the subresource name, it's empty for the resource itself
+	GetSubresource() string
+	// GetKind is the GroupVersionKind of the object
+	GetKind() apis.GroupVersionKind
+	// GetUserInfo is the user of the request, it's nil if the request isn't authenticated
+	GetUserInfo() *authentication.UserInfo
+	// IsDryRun indicates that modifications will definitely not be persisted for this request.
+	// Admission controllers with side effects must not apply them when it returns true.
+	IsDryRun() bool
 }
 
 // Interface is an abstract, pluggable interface for Admission Control decisions.
@@ -51,3 +63,11 @@ type ValidationInterface interface {
 	// Context is used only for timeout/deadline/cancellation and tracing information.
 	Validate(ctx context.Context, a Attributes) (err error)
 }
+
+// NamedInterface is an admission plugin that has a name, the name is attached to the errors of
+// the plugin. The plugins without a name are named by their type.
+type NamedInterface interface {
+	Interface
+
+	Name() string
+}
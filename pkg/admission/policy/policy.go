@@ -0,0 +1,313 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+
+	"github.com/sunyakun/gearbox/pkg/admission"
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
+
+// GroupVersion of the ValidationPolicy resource
+var GroupVersion = apis.GroupVersion{Group: "admission", Version: "v1"}
+
+// Validation is an expr-lang expression that must be true for the request to be admitted.
+// The expression can use the variables:
+//   - object: the object of the request, it's nil for delete
+//   - oldObject: the stored object of update and delete, it's nil for create
+//   - request: the operation, resource, subResource, kind, userInfo and dryRun of the request
+//
+// The objects are the JSON encoded objects decoded as maps, e.g. `object.replicas <= 10`.
+// <Message> is the error message if the expression is false, the expression is used if it's empty.
+type Validation struct {
+	Expression string `json:"expression"`
+	Message    string `json:"message,omitempty"`
+}
+
+// ValidationPolicy validates the requests by the expressions.
+// <Operations> and <Resources> are the operations and resources the policy applies to,
+// empty matches all of them.
+type ValidationPolicy struct {
+	apis.ObjectMeta
+	Operations  []admission.Operation `json:"operations,omitempty"`
+	Resources   []string              `json:"resources,omitempty"`
+	Validations []Validation          `json:"validations"`
+}
+
+// AddToScheme registers the ValidationPolicy resource in the scheme
+func AddToScheme(scheme *apis.Scheme) error {
+	return scheme.AddVersionedTypes(GroupVersion, &ValidationPolicy{})
+}
+
+// env declares the variables of the expressions
+var env = map[string]any{
+	"object":    map[string]any{},
+	"oldObject": map[string]any{},
+	"request":   map[string]any{},
+}
+
+type compiledValidation struct {
+	Validation
+	program *vm.Program
+}
+
+type compiledPolicy struct {
+	policy      *ValidationPolicy
+	validations []compiledValidation
+	// err is the compile error, the requests that match the policy are rejected
+	err error
+}
+
+// Compile checks the expressions of the policy, e.g. to reject the invalid policy before it's stored
+func Compile(policy *ValidationPolicy) error {
+	return compile(policy).err
+}
+
+func compile(policy *ValidationPolicy) *compiledPolicy {
+	compiled := &compiledPolicy{policy: policy}
+	for i, v := range policy.Validations {
+		program, err := expr.Compile(v.Expression, expr.Env(env), expr.AsBool())
+		if err != nil {
+			compiled.err = fmt.Errorf("compile the expression %d of the policy %q failed: %w", i, policy.Key, err)
+			return compiled
+		}
+		compiled.validations = append(compiled.validations, compiledValidation{Validation: v, program: program})
+	}
+	return compiled
+}
+
+func (p *compiledPolicy) handles(operation admission.Operation) bool {
+	if len(p.policy.Operations) == 0 {
+		return true
+	}
+	for _, op := range p.policy.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *compiledPolicy) matches(a admission.Attributes) bool {
+	if !p.handles(a.GetOperation()) {
+		return false
+	}
+	if len(p.policy.Resources) == 0 {
+		return true
+	}
+	for _, resource := range p.policy.Resources {
+		if resource == "*" || resource == a.GetResource() {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns the messages of the failed validations
+func (p *compiledPolicy) validate(vars map[string]any) []string {
+	if p.err != nil {
+		return []string{p.err.Error()}
+	}
+	var messages []string
+	for _, v := range p.validations {
+		out, err := expr.Run(v.program, vars)
+		if err != nil {
+			messages = append(messages, fmt.Sprintf("evaluate %q failed: %s", v.Expression, err))
+			continue
+		}
+		if ok, isBool := out.(bool); !isBool || !ok {
+			message := v.Message
+			if message == "" {
+				message = fmt.Sprintf("failed expression: %s", v.Expression)
+			}
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// Plugin is the validating admission plugin of the ValidationPolicy
+type Plugin struct {
+	mu       sync.RWMutex
+	policies map[string]*compiledPolicy
+	// syncing is true if the policies are synced by Sync, synced is true once Sync has listed them
+	syncing bool
+	synced  bool
+}
+
+var _ admission.ValidationInterface = &Plugin{}
+
+// New creates the plugin of the policies, e.g. the policies of the config file
+func New(policies ...*ValidationPolicy) (*Plugin, error) {
+	p := &Plugin{policies: map[string]*compiledPolicy{}}
+	if err := p.Replace(policies); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewSynced creates the plugin of the policies synced by Sync, it rejects the requests with the
+// ServiceUnavailable error until Sync has listed the policies.
+func NewSynced() *Plugin {
+	return &Plugin{policies: map[string]*compiledPolicy{}, syncing: true}
+}
+
+func (p *Plugin) Name() string {
+	return "ValidationPolicy"
+}
+
+// HasSynced returns true once Sync has listed all the policies. It's always false for the plugin
+// isn't synced, e.g. of the policies of the config file.
+func (p *Plugin) HasSynced() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.synced
+}
+
+// Set adds or replaces the policy of the same key. The policy is kept even if its expressions are
+// invalid so that the requests it matches are rejected instead of being admitted unchecked.
+func (p *Plugin) Set(policy *ValidationPolicy) error {
+	compiled := compile(policy)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policies[policy.Key] = compiled
+	return compiled.err
+}
+
+// Remove removes the policy of the key
+func (p *Plugin) Remove(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.policies, key)
+}
+
+// Replace replaces all the policies, the invalid ones are kept like Set
+func (p *Plugin) Replace(policies []*ValidationPolicy) error {
+	var errs []string
+	compiled := map[string]*compiledPolicy{}
+	for _, policy := range policies {
+		c := compile(policy)
+		if c.err != nil {
+			errs = append(errs, c.err.Error())
+		}
+		compiled[policy.Key] = c
+	}
+	p.mu.Lock()
+	p.policies = compiled
+	p.mu.Unlock()
+	if len(errs) != 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (p *Plugin) Handles(operation admission.Operation) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.syncing && !p.synced {
+		return true
+	}
+	for _, policy := range p.policies {
+		if policy.handles(operation) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate rejects the requests the policies reject. The plugin synced by Sync rejects all the
+// requests with the ServiceUnavailable error until the policies are listed, so that they aren't
+// admitted unchecked, the plugin of the config file doesn't wait for anything.
+func (p *Plugin) Validate(ctx context.Context, a admission.Attributes) error {
+	p.mu.RLock()
+	if p.syncing && !p.synced {
+		p.mu.RUnlock()
+		return errors.NewServiceUnavailable("the validation policies aren't synced yet")
+	}
+	var matched []*compiledPolicy
+	for _, policy := range p.policies {
+		if policy.matches(a) {
+			matched = append(matched, policy)
+		}
+	}
+	p.mu.RUnlock()
+	if len(matched) == 0 {
+		return nil
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].policy.Key < matched[j].policy.Key })
+
+	vars, err := variables(a)
+	if err != nil {
+		return errors.NewInternalError(err)
+	}
+	var messages []string
+	for _, policy := range matched {
+		for _, message := range policy.validate(vars) {
+			messages = append(messages, fmt.Sprintf("policy %q: %s", policy.policy.Key, message))
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	var key string
+	if obj := a.GetObject(); obj != nil {
+		key = obj.GetKey()
+	}
+	return errors.NewForbidden(strings.ToLower(string(a.GetOperation())), a.GetResource(), key, strings.Join(messages, "; "))
+}
+
+// variables are the variables of the expressions
+func variables(a admission.Attributes) (map[string]any, error) {
+	var object, oldObject any
+	var err error
+	if a.GetOperation() != admission.Delete {
+		if object, err = toMap(a.GetObject()); err != nil {
+			return nil, err
+		}
+	}
+	if oldObject, err = toMap(a.GetOldObject()); err != nil {
+		return nil, err
+	}
+	gvk := a.GetKind()
+	request := map[string]any{
+		"operation":   string(a.GetOperation()),
+		"resource":    a.GetResource(),
+		"subResource": a.GetSubresource(),
+		"kind":        map[string]any{"group": gvk.Group, "version": gvk.Version, "kind": gvk.Kind},
+		"userInfo":    nil,
+		"dryRun":      a.IsDryRun(),
+	}
+	if user := a.GetUserInfo(); user != nil {
+		groups := make([]any, 0, len(user.Groups))
+		for _, group := range user.Groups {
+			groups = append(groups, group)
+		}
+		request["userInfo"] = map[string]any{"name": user.Name, "groups": groups}
+	}
+	return map[string]any{"object": object, "oldObject": oldObject, "request": request}, nil
+}
+
+// toMap converts the object to the map of its JSON encoding, the nil object is nil
+func toMap(obj apis.Object) (any, error) {
+	if obj == nil || reflect.ValueOf(obj).IsNil() {
+		return nil, nil
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,152 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/admission"
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+type deployment struct {
+	apis.ObjectMeta
+	Image    string `json:"image"`
+	Replicas int    `json:"replicas"`
+}
+
+func TestPlugin(t *testing.T) {
+	plugin, err := New(&ValidationPolicy{
+		ObjectMeta: apis.ObjectMeta{Key: "limits"},
+		Operations: []admission.Operation{admission.Create, admission.Update},
+		Resources:  []string{"deployments"},
+		Validations: []Validation{
+			{Expression: "object.replicas <= 10", Message: "replicas must be 10 at most"},
+			{Expression: `object.key startsWith "prod-"`},
+			{Expression: `oldObject == nil || oldObject.image == object.image || "admin" in request.userInfo?.groups`},
+		},
+	})
+	assert.Nil(t, err)
+	assert.True(t, plugin.Handles(admission.Create))
+	assert.False(t, plugin.Handles(admission.Delete))
+	ctx := context.Background()
+
+	obj := &deployment{ObjectMeta: apis.ObjectMeta{Key: "prod-web"}, Image: "nginx", Replicas: 3}
+	assert.Nil(t, plugin.Validate(ctx, &admission.Attribute{Object: obj, Operation: admission.Create, ResourceName: "deployments"}))
+	// the other resources aren't validated
+	invalid := &deployment{ObjectMeta: apis.ObjectMeta{Key: "web"}, Replicas: 20}
+	assert.Nil(t, plugin.Validate(ctx, &admission.Attribute{Object: invalid, Operation: admission.Create, ResourceName: "jobs"}))
+
+	err = plugin.Validate(ctx, &admission.Attribute{Object: invalid, Operation: admission.Create, ResourceName: "deployments"})
+	assert.True(t, errors.IsForbiddenError(err))
+	assert.Contains(t, err.Error(), `policy "limits": replicas must be 10 at most`)
+	assert.Contains(t, err.Error(), `policy "limits": failed expression: object.key startsWith "prod-"`)
+
+	updated := &deployment{ObjectMeta: apis.ObjectMeta{Key: "prod-web"}, Image: "redis", Replicas: 3}
+	attrs := &admission.Attribute{Object: updated, OldObject: obj, Operation: admission.Update, ResourceName: "deployments"}
+	assert.NotNil(t, plugin.Validate(ctx, attrs))
+	attrs.UserInfo = &authentication.UserInfo{Name: "alice", Groups: []string{"admin"}}
+	assert.Nil(t, plugin.Validate(ctx, attrs))
+
+	// the invalid policy rejects the requests it matches
+	assert.NotNil(t, plugin.Set(&ValidationPolicy{ObjectMeta: apis.ObjectMeta{Key: "broken"}, Validations: []Validation{{Expression: "object.replicas <="}}}))
+	assert.NotNil(t, plugin.Validate(ctx, &admission.Attribute{Object: obj, Operation: admission.Create, ResourceName: "deployments"}))
+	plugin.Remove("broken")
+	assert.Nil(t, plugin.Validate(ctx, &admission.Attribute{Object: obj, Operation: admission.Create, ResourceName: "deployments"}))
+}
+
+type fakeChannel struct {
+	ch chan rest.Event
+}
+
+func (c *fakeChannel) Stop() {}
+
+func (c *fakeChannel) ResultChan() (<-chan rest.Event, error) {
+	return c.ch, nil
+}
+
+// fakeClient lists the policies and watches the events of the channel
+type fakeClient struct {
+	rest.Client[*ValidationPolicy]
+	policies []*ValidationPolicy
+	ch       chan rest.Event
+	// listed blocks the list until it's closed
+	listed chan struct{}
+}
+
+// GetList pages the policies like the storage, the zero limit lists nothing
+func (c *fakeClient) GetList(ctx context.Context, opts apis.ListOptions) ([]*ValidationPolicy, int64, error) {
+	<-c.listed
+	if !authentication.IsSystemUser(ctx) {
+		return nil, 0, errors.NewForbidden("list", "ValidationPolicy", "", "the plugin lists without the system user")
+	}
+	start, end := opts.Offset, opts.Offset+opts.Limit
+	if start > len(c.policies) {
+		start = len(c.policies)
+	}
+	if end > len(c.policies) {
+		end = len(c.policies)
+	}
+	return c.policies[start:end], int64(len(c.policies)), nil
+}
+
+func (c *fakeClient) Watch(ctx context.Context, opts apis.WatchOptions) (rest.Channel, error) {
+	return &fakeChannel{ch: c.ch}, nil
+}
+
+func TestPluginSync(t *testing.T) {
+	replicas := &ValidationPolicy{
+		ObjectMeta:  apis.ObjectMeta{Key: "replicas"},
+		Validations: []Validation{{Expression: "object.replicas <= 10"}},
+	}
+	// the policies take several pages
+	defer func(pageSize int) { rest.ListPageSize = pageSize }(rest.ListPageSize)
+	rest.ListPageSize = 2
+	var policies []*ValidationPolicy
+	for i := 0; i < 4; i++ {
+		policies = append(policies, &ValidationPolicy{ObjectMeta: apis.ObjectMeta{Key: fmt.Sprintf("noop-%d", i)}, Validations: []Validation{{Expression: "true"}}})
+	}
+	client := &fakeClient{policies: append(policies, replicas), ch: make(chan rest.Event), listed: make(chan struct{})}
+	plugin := NewSynced()
+	assert.False(t, plugin.HasSynced())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go plugin.Sync(ctx, client, logr.Discard())
+
+	// the requests are rejected until the policies are listed
+	attrs := &admission.Attribute{Object: &deployment{Replicas: 5}, Operation: admission.Create, ResourceName: "deployments"}
+	assert.True(t, plugin.Handles(admission.Create))
+	err := plugin.Validate(ctx, attrs)
+	assert.True(t, errors.IsServiceUnavailableError(err), err)
+	close(client.listed)
+	assert.Eventually(t, plugin.HasSynced, time.Second, 10*time.Millisecond)
+	assert.Nil(t, plugin.Validate(ctx, attrs))
+	assert.NotNil(t, plugin.Validate(ctx, &admission.Attribute{Object: &deployment{Replicas: 11}, Operation: admission.Create, ResourceName: "deployments"}))
+
+	client.ch <- rest.Event{Type: watch.EventTypeUpdated, Obj: &ValidationPolicy{
+		ObjectMeta:  apis.ObjectMeta{Key: "replicas"},
+		Validations: []Validation{{Expression: "object.replicas <= 3"}},
+	}}
+	client.ch <- rest.Event{Type: watch.EventTypeCreated, Obj: &ValidationPolicy{
+		ObjectMeta:  apis.ObjectMeta{Key: "images"},
+		Validations: []Validation{{Expression: `object.image != ""`}},
+	}}
+	// the events are handled in order, the validation sees both of them after the next event
+	client.ch <- rest.Event{Type: watch.EventTypeBookmark}
+	err = plugin.Validate(ctx, attrs)
+	assert.Contains(t, err.Error(), `policy "images"`)
+	assert.Contains(t, err.Error(), `policy "replicas"`)
+
+	client.ch <- rest.Event{Type: watch.EventTypeDeleted, Obj: replicas}
+	client.ch <- rest.Event{Type: watch.EventTypeBookmark}
+	err = plugin.Validate(ctx, attrs)
+	assert.NotContains(t, err.Error(), `policy "replicas"`)
+}
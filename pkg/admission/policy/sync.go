@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+// ResyncInterval is the interval to list and watch the policies again after the watch ends
+var ResyncInterval = time.Second
+
+// Sync keeps the policies of the plugin in sync with the ValidationPolicy resource until ctx is done.
+// It watches the policies, replaces the policies of the plugin with the listed ones and then applies
+// the watch events, the list and watch are restarted if the watch ends. The policies are listed page
+// by page by the system user. The plugin rejects the requests with the ServiceUnavailable error
+// until the first list succeeds, create it by NewSynced to reject them before Sync starts too.
+func (p *Plugin) Sync(ctx context.Context, client rest.WatchableClient[*ValidationPolicy], logger logr.Logger) {
+	p.mu.Lock()
+	p.syncing = true
+	p.mu.Unlock()
+	ctx = authentication.WithSystemUser(ctx)
+	for {
+		err := p.sync(ctx, client, logger)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error(err, "sync the validation policies failed, retry later")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ResyncInterval):
+		}
+	}
+}
+
+func (p *Plugin) sync(ctx context.Context, client rest.WatchableClient[*ValidationPolicy], logger logr.Logger) error {
+	// watch before the list so that the changes between them aren't lost
+	ch, err := client.Watch(ctx, apis.WatchOptions{})
+	if err != nil {
+		return err
+	}
+	defer ch.Stop()
+	events, err := ch.ResultChan()
+	if err != nil {
+		return err
+	}
+	policies, err := rest.ListAll[*ValidationPolicy](ctx, client, apis.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if err := p.Replace(policies); err != nil {
+		logger.Error(err, "some validation policies are invalid")
+	}
+	p.mu.Lock()
+	p.synced = true
+	p.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case evt, ok := <-events:
+			if !ok {
+				return fmt.Errorf("the watch of the validation policies is closed")
+			}
+			switch evt.Type {
+			case watch.EventTypeCreated, watch.EventTypeUpdated:
+				policy, ok := evt.Obj.(*ValidationPolicy)
+				if !ok {
+					continue
+				}
+				if err := p.Set(policy); err != nil {
+					logger.Error(err, "the validation policy is invalid", "key", policy.Key)
+				}
+			case watch.EventTypeDeleted:
+				if evt.Obj != nil {
+					p.Remove(evt.Obj.GetKey())
+				}
+			case watch.EventTypeError:
+				if evt.Err != nil {
+					return errors.StatusError{ErrStatus: *evt.Err}
+				}
+				return fmt.Errorf("the watch of the validation policies failed")
+			}
+		}
+	}
+}
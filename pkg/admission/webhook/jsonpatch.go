@@ -0,0 +1,300 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// patchOperation is an operation of the RFC 6902 JSON patch
+type patchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	From  string `json:"from,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+// decodeJSON decodes the numbers as json.Number, so that the integers beyond the precision
+// of float64 aren't changed by the patch
+func decodeJSON(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// applyPatch applies the JSON patch to the JSON document
+func applyPatch(doc, patch []byte) ([]byte, error) {
+	var ops []patchOperation
+	if err := decodeJSON(patch, &ops); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %w", err)
+	}
+	var root any
+	if err := decodeJSON(doc, &root); err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		var err error
+		if root, err = applyOperation(root, op); err != nil {
+			return nil, fmt.Errorf("apply the operation %d %q of %q failed: %w", i, op.Op, op.Path, err)
+		}
+	}
+	return json.Marshal(root)
+}
+
+func applyOperation(root any, op patchOperation) (any, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add":
+		return add(root, tokens, op.Value)
+	case "remove":
+		root, _, err = remove(root, tokens)
+		return root, err
+	case "replace":
+		return replace(root, tokens, op.Value)
+	case "move":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		root, value, err := remove(root, from)
+		if err != nil {
+			return nil, err
+		}
+		return add(root, tokens, value)
+	case "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		value, err := get(root, from)
+		if err != nil {
+			return nil, err
+		}
+		// copy the value so that the later operations don't change both of them
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		var copied any
+		if err := decodeJSON(data, &copied); err != nil {
+			return nil, err
+		}
+		return add(root, tokens, copied)
+	case "test":
+		value, err := get(root, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !equal(value, op.Value) {
+			return nil, fmt.Errorf("the value is %v, not %v", value, op.Value)
+		}
+		return root, nil
+	default:
+		return nil, fmt.Errorf("unsupported operation")
+	}
+}
+
+// equal compares the decoded JSON values, the numbers are compared by the values, e.g. 1
+// equals to 1.0
+func equal(a, b any) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		xf, _, errX := big.ParseFloat(string(x), 10, 256, big.ToNearestEven)
+		yf, _, errY := big.ParseFloat(string(y), 10, 256, big.ToNearestEven)
+		if errX != nil || errY != nil {
+			return x == y
+		}
+		return xf.Cmp(yf) == 0
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			if w, ok := y[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+// parsePointer parses the RFC 6901 JSON pointer into the reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses the index of the array, <max> is the max valid index
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
+
+func get(node any, tokens []string) (any, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]any:
+			child, ok := n[token]
+			if !ok {
+				return nil, fmt.Errorf("the member %q doesn't exist", token)
+			}
+			node = child
+		case []any:
+			i, err := arrayIndex(token, len(n)-1)
+			if err != nil {
+				return nil, err
+			}
+			node = n[i]
+		default:
+			return nil, fmt.Errorf("can't reference %q of a scalar value", token)
+		}
+	}
+	return node, nil
+}
+
+// update calls <leaf> on the parent of the value referenced by the tokens and replaces the
+// parent with the result, the tokens mustn't be empty
+func update(node any, tokens []string, leaf func(parent any, token string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return leaf(node, tokens[0])
+	}
+	switch n := node.(type) {
+	case map[string]any:
+		child, ok := n[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("the member %q doesn't exist", tokens[0])
+		}
+		child, err := update(child, tokens[1:], leaf)
+		if err != nil {
+			return nil, err
+		}
+		n[tokens[0]] = child
+		return n, nil
+	case []any:
+		i, err := arrayIndex(tokens[0], len(n)-1)
+		if err != nil {
+			return nil, err
+		}
+		if n[i], err = update(n[i], tokens[1:], leaf); err != nil {
+			return nil, err
+		}
+		return n, nil
+	default:
+		return nil, fmt.Errorf("can't reference %q of a scalar value", tokens[0])
+	}
+}
+
+func add(root any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return update(root, tokens, func(parent any, token string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[token] = value
+			return p, nil
+		case []any:
+			if token == "-" {
+				return append(p, value), nil
+			}
+			i, err := arrayIndex(token, len(p))
+			if err != nil {
+				return nil, err
+			}
+			p = append(p[:i], append([]any{value}, p[i:]...)...)
+			return p, nil
+		default:
+			return nil, fmt.Errorf("can't add %q to a scalar value", token)
+		}
+	})
+}
+
+func remove(root any, tokens []string) (any, any, error) {
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("can't remove the whole document")
+	}
+	var removed any
+	root, err := update(root, tokens, func(parent any, token string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			value, ok := p[token]
+			if !ok {
+				return nil, fmt.Errorf("the member %q doesn't exist", token)
+			}
+			removed = value
+			delete(p, token)
+			return p, nil
+		case []any:
+			i, err := arrayIndex(token, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+			removed = p[i]
+			return append(p[:i], p[i+1:]...), nil
+		default:
+			return nil, fmt.Errorf("can't remove %q of a scalar value", token)
+		}
+	})
+	return root, removed, err
+}
+
+func replace(root any, tokens []string, value any) (any, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return update(root, tokens, func(parent any, token string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			if _, ok := p[token]; !ok {
+				return nil, fmt.Errorf("the member %q doesn't exist", token)
+			}
+			p[token] = value
+			return p, nil
+		case []any:
+			i, err := arrayIndex(token, len(p)-1)
+			if err != nil {
+				return nil, err
+			}
+			p[i] = value
+			return p, nil
+		default:
+			return nil, fmt.Errorf("can't replace %q of a scalar value", token)
+		}
+	})
+}
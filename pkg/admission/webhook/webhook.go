@@ -0,0 +1,328 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imroc/req/v3"
+
+	"github.com/sunyakun/gearbox/pkg/admission"
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
+
+// FailurePolicy decides what to do if the webhook can't be called or returns an invalid response
+type FailurePolicy string
+
+const (
+	// Fail rejects the request
+	Fail FailurePolicy = "Fail"
+	// Ignore admits the request as if the webhook isn't configured
+	Ignore FailurePolicy = "Ignore"
+)
+
+const (
+	DefaultTimeout = 10 * time.Second
+
+	PatchTypeJSONPatch = "JSONPatch"
+)
+
+// Config of a webhook.
+// <URL> is the URL the AdmissionReview is posted to.
+// <CABundle> is the PEM encoded CA certificates to verify the TLS certificate of the webhook,
+// the system roots are used if it's empty.
+// <Timeout> is the timeout of a call, DefaultTimeout if it's zero.
+// <FailurePolicy> is Fail if it's empty.
+// <Operations> and <Resources> are the operations and resources the webhook is called for,
+// empty matches all of them.
+type Config struct {
+	Name          string
+	URL           string
+	CABundle      []byte
+	Timeout       time.Duration
+	FailurePolicy FailurePolicy
+	Operations    []admission.Operation
+	Resources     []string
+}
+
+// AdmissionReview is the body of the webhook request and response, the request carries
+// <Request> and the response carries <Response>.
+type AdmissionReview struct {
+	Request  *AdmissionRequest  `json:"request,omitempty"`
+	Response *AdmissionResponse `json:"response,omitempty"`
+}
+
+// AdmissionRequest describes the operation to admit.
+// <Object> is nil for delete and <OldObject> is nil for create.
+type AdmissionRequest struct {
+	UID         string                   `json:"uid"`
+	Kind        apis.GroupVersionKind    `json:"kind"`
+	Resource    string                   `json:"resource"`
+	SubResource string                   `json:"subResource,omitempty"`
+	Operation   admission.Operation      `json:"operation"`
+	UserInfo    *authentication.UserInfo `json:"userInfo,omitempty"`
+	Object      json.RawMessage          `json:"object,omitempty"`
+	OldObject   json.RawMessage          `json:"oldObject,omitempty"`
+	DryRun      bool                     `json:"dryRun"`
+}
+
+// AdmissionResponse is the decision of the webhook, <UID> must be the UID of the request.
+// <Status> explains why the request isn't allowed.
+// <Patch> is the JSON patch of the object applied by the mutating webhooks.
+type AdmissionResponse struct {
+	UID       string       `json:"uid"`
+	Allowed   bool         `json:"allowed"`
+	Status    *apis.Status `json:"status,omitempty"`
+	Patch     []byte       `json:"patch,omitempty"`
+	PatchType string       `json:"patchType,omitempty"`
+}
+
+type webhook struct {
+	config Config
+	client *req.Client
+}
+
+func newWebhook(config Config) (*webhook, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL of the webhook %q: %w", config.Name, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL of the webhook %q: the scheme must be http or https", config.Name)
+	}
+	switch config.FailurePolicy {
+	case "":
+		config.FailurePolicy = Fail
+	case Fail, Ignore:
+	default:
+		return nil, fmt.Errorf("invalid failure policy %q of the webhook %q", config.FailurePolicy, config.Name)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	client := req.C().SetTimeout(config.Timeout)
+	if len(config.CABundle) != 0 {
+		client.SetRootCertFromString(string(config.CABundle))
+	}
+	return &webhook{config: config, client: client}, nil
+}
+
+func (w *webhook) Name() string {
+	return w.config.Name
+}
+
+func (w *webhook) Handles(operation admission.Operation) bool {
+	if len(w.config.Operations) == 0 {
+		return true
+	}
+	for _, op := range w.config.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *webhook) matches(a admission.Attributes) bool {
+	if len(w.config.Resources) == 0 {
+		return true
+	}
+	for _, resource := range w.config.Resources {
+		if resource == "*" || resource == a.GetResource() {
+			return true
+		}
+	}
+	return false
+}
+
+// admit calls the webhook and returns its response, the error is the rejection of the
+// request. The response is nil if the call failed and the failure is ignored.
+func (w *webhook) admit(ctx context.Context, a admission.Attributes) (*AdmissionResponse, error) {
+	resp, err := w.call(ctx, a)
+	if err != nil {
+		if w.config.FailurePolicy == Ignore {
+			return nil, nil
+		}
+		return nil, errors.NewInternalError(fmt.Errorf("failed calling the webhook: %w", err))
+	}
+	if !resp.Allowed {
+		return nil, denied(a, resp.Status)
+	}
+	return resp, nil
+}
+
+func (w *webhook) call(ctx context.Context, a admission.Attributes) (*AdmissionResponse, error) {
+	request := &AdmissionRequest{
+		UID:         uuid.NewString(),
+		Kind:        a.GetKind(),
+		Resource:    a.GetResource(),
+		SubResource: a.GetSubresource(),
+		Operation:   a.GetOperation(),
+		UserInfo:    a.GetUserInfo(),
+		DryRun:      a.IsDryRun(),
+	}
+	var err error
+	if a.GetOperation() != admission.Delete {
+		if request.Object, err = marshalObject(a.GetObject()); err != nil {
+			return nil, err
+		}
+	}
+	if request.OldObject, err = marshalObject(a.GetOldObject()); err != nil {
+		return nil, err
+	}
+
+	var review AdmissionReview
+	resp, err := w.client.R().
+		SetContext(ctx).
+		SetBodyJsonMarshal(&AdmissionReview{Request: request}).
+		Post(w.config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("the webhook responded %s", resp.Status)
+	}
+	if err := json.Unmarshal(resp.Bytes(), &review); err != nil {
+		return nil, fmt.Errorf("decode the response failed: %w", err)
+	}
+	if review.Response == nil {
+		return nil, fmt.Errorf("the response is empty")
+	}
+	if review.Response.UID != request.UID {
+		return nil, fmt.Errorf("the uid of the response %q isn't the uid of the request %q", review.Response.UID, request.UID)
+	}
+	return review.Response, nil
+}
+
+// marshalObject returns nil for the nil object
+func marshalObject(obj apis.Object) (json.RawMessage, error) {
+	if obj == nil || reflect.ValueOf(obj).IsNil() {
+		return nil, nil
+	}
+	return json.Marshal(obj)
+}
+
+// denied is the error of the request the webhook didn't allow, it's forbidden if the webhook
+// didn't set the code of the status
+func denied(a admission.Attributes, status *apis.Status) error {
+	var key string
+	if obj := a.GetObject(); obj != nil {
+		key = obj.GetKey()
+	}
+	message := "denied by the webhook"
+	if status != nil && status.Message != "" {
+		message = status.Message
+	}
+	if status == nil || status.Code < http.StatusBadRequest {
+		return errors.NewForbidden(strings.ToLower(string(a.GetOperation())), a.GetResource(), key, message)
+	}
+	s := *status
+	s.Kind = "Status"
+	s.Status = apis.StatusFailure
+	s.Message = message
+	return errors.StatusError{ErrStatus: s}
+}
+
+// validatingWebhook validates the objects by the webhook, the patches of the responses are ignored
+type validatingWebhook struct {
+	*webhook
+}
+
+// NewValidating creates the validating admission plugin of the webhook
+func NewValidating(config Config) (admission.ValidationInterface, error) {
+	w, err := newWebhook(config)
+	if err != nil {
+		return nil, err
+	}
+	return &validatingWebhook{webhook: w}, nil
+}
+
+func (w *validatingWebhook) Validate(ctx context.Context, a admission.Attributes) error {
+	if !w.matches(a) {
+		return nil
+	}
+	_, err := w.admit(ctx, a)
+	return err
+}
+
+// mutatingWebhook mutates the objects by the JSON patches of the responses
+type mutatingWebhook struct {
+	*webhook
+}
+
+// NewMutating creates the mutating admission plugin of the webhook
+func NewMutating(config Config) (admission.MutationInterface, error) {
+	w, err := newWebhook(config)
+	if err != nil {
+		return nil, err
+	}
+	return &mutatingWebhook{webhook: w}, nil
+}
+
+func (w *mutatingWebhook) Admit(ctx context.Context, a admission.Attributes) error {
+	if !w.matches(a) {
+		return nil
+	}
+	resp, err := w.admit(ctx, a)
+	if err != nil || resp == nil || len(resp.Patch) == 0 {
+		return err
+	}
+	if resp.PatchType != "" && resp.PatchType != PatchTypeJSONPatch {
+		return errors.NewInternalError(fmt.Errorf("unsupported patch type %q of the webhook", resp.PatchType))
+	}
+	if err := patchObject(a.GetObject(), resp.Patch); err != nil {
+		return errors.NewInternalError(fmt.Errorf("apply the patch of the webhook failed: %w", err))
+	}
+	return nil
+}
+
+// patchObject applies the JSON patch to the object in place, the key of the object can't be
+// changed by the patch
+func patchObject(obj apis.Object, patch []byte) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if data, err = applyPatch(data, patch); err != nil {
+		return err
+	}
+	patched := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(apis.Object)
+	if err := json.Unmarshal(data, patched); err != nil {
+		return err
+	}
+	if patched.GetKey() != obj.GetKey() {
+		return fmt.Errorf("the key %q can't be changed to %q", obj.GetKey(), patched.GetKey())
+	}
+	// reset the JSON fields so that the removed fields are removed from the object too, the
+	// others, e.g. the fields of `json:"-"`, are kept
+	resetJSONFields(reflect.ValueOf(obj).Elem())
+	return json.Unmarshal(data, obj)
+}
+
+// resetJSONFields sets the fields of the struct encoded by encoding/json to zero, the fields
+// of the embedded structs are reset one by one.
+func resetJSONFields(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			resetJSONFields(v.Field(i))
+			continue
+		}
+		if fv := v.Field(i); f.IsExported() && fv.CanSet() {
+			fv.Set(reflect.Zero(f.Type))
+		}
+	}
+}
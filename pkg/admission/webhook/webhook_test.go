@@ -0,0 +1,171 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/admission"
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
+
+type fakeObject struct {
+	apis.ObjectMeta
+	Image    string            `json:"image"`
+	Replicas int               `json:"replicas,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// newServer serves the webhook by the review function
+func newServer(t *testing.T, review func(req *AdmissionRequest) *AdmissionResponse) (*httptest.Server, []byte) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in AdmissionReview
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&in))
+		resp := review(in.Request)
+		resp.UID = in.Request.UID
+		_ = json.NewEncoder(w).Encode(&AdmissionReview{Response: resp})
+	}))
+	t.Cleanup(server.Close)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	return server, ca
+}
+
+func TestMutatingWebhook(t *testing.T) {
+	var got *AdmissionRequest
+	server, ca := newServer(t, func(req *AdmissionRequest) *AdmissionResponse {
+		got = req
+		return &AdmissionResponse{
+			Allowed:   true,
+			PatchType: PatchTypeJSONPatch,
+			Patch: []byte(`[
+				{"op": "replace", "path": "/image", "value": "nginx:latest"},
+				{"op": "add", "path": "/labels", "value": {"app": "nginx"}},
+				{"op": "remove", "path": "/replicas"}
+			]`),
+		}
+	})
+	plugin, err := NewMutating(Config{Name: "defaulter", URL: server.URL, CABundle: ca, Operations: []admission.Operation{admission.Update}})
+	assert.Nil(t, err)
+	assert.False(t, plugin.Handles(admission.Create))
+	assert.True(t, plugin.Handles(admission.Update))
+
+	obj := &fakeObject{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx", Replicas: 3}
+	attrs := &admission.Attribute{
+		Object:       obj,
+		OldObject:    &fakeObject{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "redis"},
+		Operation:    admission.Update,
+		ResourceName: "foos",
+		UserInfo:     &authentication.UserInfo{Name: "alice"},
+	}
+	assert.Nil(t, plugin.Admit(context.Background(), attrs))
+	assert.Equal(t, &fakeObject{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx:latest", Labels: map[string]string{"app": "nginx"}}, obj)
+
+	assert.Equal(t, admission.Update, got.Operation)
+	assert.Equal(t, "foos", got.Resource)
+	assert.Equal(t, "alice", got.UserInfo.Name)
+	var sent, sentOld fakeObject
+	assert.Nil(t, json.Unmarshal(got.Object, &sent))
+	assert.Nil(t, json.Unmarshal(got.OldObject, &sentOld))
+	assert.Equal(t, 3, sent.Replicas)
+	assert.Equal(t, "redis", sentOld.Image)
+}
+
+func TestValidatingWebhook(t *testing.T) {
+	server, ca := newServer(t, func(req *AdmissionRequest) *AdmissionResponse {
+		return &AdmissionResponse{Allowed: false, Status: &apis.Status{Message: "the image isn't trusted"}}
+	})
+	plugin, err := NewValidating(Config{Name: "images", URL: server.URL, CABundle: ca, Resources: []string{"foos"}})
+	assert.Nil(t, err)
+
+	obj := &fakeObject{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "evil"}
+	err = plugin.Validate(context.Background(), &admission.Attribute{Object: obj, Operation: admission.Create, ResourceName: "foos"})
+	assert.True(t, errors.IsForbiddenError(err))
+	assert.Contains(t, err.Error(), "the image isn't trusted")
+
+	// the other resources aren't sent to the webhook
+	err = plugin.Validate(context.Background(), &admission.Attribute{Object: obj, Operation: admission.Create, ResourceName: "bars"})
+	assert.Nil(t, err)
+}
+
+func TestWebhookFailurePolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer server.Close()
+	attrs := &admission.Attribute{Object: &fakeObject{}, Operation: admission.Create, ResourceName: "foos"}
+
+	plugin, err := NewValidating(Config{Name: "slow", URL: server.URL, Timeout: 50 * time.Millisecond})
+	assert.Nil(t, err)
+	assert.True(t, errors.IsInternalError(plugin.Validate(context.Background(), attrs)))
+
+	plugin, err = NewValidating(Config{Name: "slow", URL: server.URL, Timeout: 50 * time.Millisecond, FailurePolicy: Ignore})
+	assert.Nil(t, err)
+	assert.Nil(t, plugin.Validate(context.Background(), attrs))
+
+	_, err = NewValidating(Config{Name: "invalid", URL: "ftp://example.com"})
+	assert.NotNil(t, err)
+}
+
+func TestApplyPatch(t *testing.T) {
+	doc := []byte(`{"a": {"b": [1, 2, 3]}, "c": "d"}`)
+	patched, err := applyPatch(doc, []byte(`[
+		{"op": "test", "path": "/c", "value": "d"},
+		{"op": "add", "path": "/a/b/1", "value": 9},
+		{"op": "add", "path": "/a/b/-", "value": 4},
+		{"op": "move", "from": "/c", "path": "/e~1f"},
+		{"op": "copy", "from": "/a/b", "path": "/g"},
+		{"op": "remove", "path": "/g/0"}
+	]`))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"a": {"b": [1, 9, 2, 3, 4]}, "e/f": "d", "g": [9, 2, 3, 4]}`, string(patched))
+
+	_, err = applyPatch(doc, []byte(`[{"op": "test", "path": "/c", "value": "x"}]`))
+	assert.NotNil(t, err)
+	_, err = applyPatch(doc, []byte(`[{"op": "replace", "path": "/x", "value": 1}]`))
+	assert.NotNil(t, err)
+
+	// the numbers are compared by the values and kept as they are
+	patched, err = applyPatch([]byte(`{"n": 1, "big": 9007199254740993}`), []byte(`[
+		{"op": "test", "path": "/n", "value": 1.0},
+		{"op": "copy", "from": "/big", "path": "/copied"},
+		{"op": "add", "path": "/added", "value": 9007199254740995}
+	]`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"added":9007199254740995,"big":9007199254740993,"copied":9007199254740993,"n":1}`, string(patched))
+	_, err = applyPatch([]byte(`{"big": 9007199254740993}`), []byte(`[{"op": "test", "path": "/big", "value": 9007199254740992}]`))
+	assert.NotNil(t, err)
+}
+
+// taggedObject has the fields aren't encoded in JSON
+type taggedObject struct {
+	apis.ObjectMeta
+	ID       int64  `json:"id"`
+	Image    string `json:"image,omitempty"`
+	Internal string `json:"-"`
+	cache    string
+}
+
+func TestPatchObject(t *testing.T) {
+	obj := &taggedObject{ObjectMeta: apis.ObjectMeta{Key: "foo", ResourceVersion: "1"}, ID: 1<<62 + 1, Image: "nginx", Internal: "internal", cache: "cache"}
+	assert.Nil(t, patchObject(obj, []byte(`[{"op": "remove", "path": "/image"}, {"op": "replace", "path": "/resourceVersion", "value": "2"}]`)))
+	assert.Equal(t, &taggedObject{ObjectMeta: apis.ObjectMeta{Key: "foo", ResourceVersion: "2"}, ID: 1<<62 + 1, Internal: "internal", cache: "cache"}, obj)
+
+	// the key can't be changed
+	for _, patch := range []string{
+		`[{"op": "replace", "path": "/key", "value": "bar"}]`,
+		`[{"op": "remove", "path": "/key"}]`,
+		`[{"op": "move", "from": "/image", "path": "/key"}]`,
+	} {
+		obj := &taggedObject{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"}
+		assert.NotNil(t, patchObject(obj, []byte(patch)), patch)
+		assert.Equal(t, &taggedObject{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"}, obj)
+	}
+}
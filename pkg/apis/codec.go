@@ -0,0 +1,73 @@
+package apis
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	MIMEJSON = "application/json"
+	MIMECBOR = "application/cbor"
+)
+
+// Codec encodes and decodes the api objects and the watch events in a content type
+type Codec interface {
+	// ContentType is the MIME type of the encoded data
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSONCodec is the default codec
+	JSONCodec Codec = jsonCodec{}
+	// CBORCodec is the compact binary codec, the fields are named by the json tags
+	CBORCodec Codec = newCBORCodec()
+)
+
+var mapStringAnyType = reflect.TypeOf(map[string]any(nil))
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return MIMEJSON
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	dec, err := cbor.DecOptions{DefaultMapType: mapStringAnyType}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return cborCodec{enc: enc, dec: dec}
+}
+
+func (cborCodec) ContentType() string {
+	return MIMECBOR
+}
+
+func (c cborCodec) Marshal(v any) ([]byte, error) {
+	return c.enc.Marshal(v)
+}
+
+func (c cborCodec) Unmarshal(data []byte, v any) error {
+	return c.dec.Unmarshal(data, v)
+}
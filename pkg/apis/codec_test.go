@@ -0,0 +1,45 @@
+package apis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codecFoo struct {
+	ObjectMeta
+	Replicas  int          `json:"replicas"`
+	Extension RawExtension `json:"extension"`
+}
+
+func TestCodecs(t *testing.T) {
+	now := time.Date(2023, 5, 1, 0, 0, 0, 1, time.UTC)
+	for _, codec := range []Codec{JSONCodec, CBORCodec} {
+		in := codecFoo{
+			ObjectMeta: ObjectMeta{Kind: "codecFoo", Key: "foo", CreateTime: now},
+			Replicas:   3,
+			Extension:  RawExtension{Raw: []byte(`{"bar":"foo"}`)},
+		}
+		data, err := codec.Marshal(in)
+		assert.Nil(t, err, codec.ContentType())
+
+		var out codecFoo
+		assert.Nil(t, codec.Unmarshal(data, &out), codec.ContentType())
+		assert.True(t, out.CreateTime.Equal(now), codec.ContentType())
+		out.CreateTime = now
+		assert.Equal(t, in, out, codec.ContentType())
+	}
+}
+
+func TestSchemeCodecs(t *testing.T) {
+	s := NewScheme()
+	_, ok := s.Codec(MIMECBOR)
+	assert.False(t, ok)
+
+	s.AddCodecs(CBORCodec)
+	codec, ok := s.Codec(MIMECBOR)
+	assert.True(t, ok)
+	assert.Equal(t, CBORCodec, codec)
+	assert.Equal(t, []Codec{JSONCodec, CBORCodec}, s.Codecs())
+}
@@ -33,3 +33,34 @@ func (re RawExtension) MarshalJSON() ([]byte, error) {
 	}
 	return re.Raw, nil
 }
+
+// MarshalCBOR encodes the RawExtension to CBOR, the Raw is always the JSON data
+func (re RawExtension) MarshalCBOR() ([]byte, error) {
+	if re.Raw == nil {
+		return CBORCodec.Marshal(re.Object)
+	}
+	var obj any
+	if err := json.Unmarshal(re.Raw, &obj); err != nil {
+		return nil, err
+	}
+	return CBORCodec.Marshal(obj)
+}
+
+func (re *RawExtension) UnmarshalCBOR(b []byte) error {
+	if re == nil {
+		return fmt.Errorf("apis.RawExtension: unmarshal cbor to nil")
+	}
+	var obj any
+	if err := CBORCodec.Unmarshal(b, &obj); err != nil {
+		return err
+	}
+	if obj == nil {
+		return nil
+	}
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	re.Raw = raw
+	return nil
+}
@@ -1,6 +1,9 @@
 package apis
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 const (
 	StatusFailure = "Failure"
@@ -15,8 +18,19 @@ type Object interface {
 	GetResourceVersion() string
 }
 
+// Versioned is implemented by the objects carry the apiVersion, e.g. the ones embed the
+// ObjectMeta. It's apart from Object so that the existing implementations of Object keep
+// working, the apiVersion of the others isn't set by the Scheme.
+type Versioned interface {
+	GetAPIVersion() string
+	SetAPIVersion(string)
+}
+
+// ObjectMeta is embedded by the objects. The kind and apiVersion are set by the Scheme, they
+// aren't stored.
 type ObjectMeta struct {
-	Kind            string    `json:"kind,omitempty"`
+	Kind            string    `json:"kind,omitempty" gearbox:"-"`
+	APIVersion      string    `json:"apiVersion,omitempty" gearbox:"-"`
 	Key             string    `json:"key,omitempty"`
 	ResourceVersion string    `json:"resourceVersion,omitempty"`
 	CreateTime      time.Time `json:"createTime,omitempty"`
@@ -39,20 +53,90 @@ func (o *ObjectMeta) SetKind(kind string) {
 	o.Kind = kind
 }
 
+func (o *ObjectMeta) GetAPIVersion() string {
+	return o.APIVersion
+}
+
+func (o *ObjectMeta) SetAPIVersion(apiVersion string) {
+	o.APIVersion = apiVersion
+}
+
 func (o *ObjectMeta) GetResourceVersion() string {
 	return o.ResourceVersion
 }
 
+func (o *ObjectMeta) SetResourceVersion(resourceVersion string) {
+	o.ResourceVersion = resourceVersion
+}
+
+// GroupVersion is the version of an API group, the group of the legacy APIs is empty
+type GroupVersion struct {
+	Group   string
+	Version string
+}
+
+// String returns "<group>/<version>", or "<version>" of the legacy APIs
+func (gv GroupVersion) String() string {
+	if gv.Group == "" {
+		return gv.Version
+	}
+	return gv.Group + "/" + gv.Version
+}
+
+func (gv GroupVersion) WithKind(kind string) GroupVersionKind {
+	return GroupVersionKind{Group: gv.Group, Version: gv.Version, Kind: kind}
+}
+
+// ParseGroupVersion parses the apiVersion of the objects
+func ParseGroupVersion(apiVersion string) GroupVersion {
+	group, version, ok := strings.Cut(apiVersion, "/")
+	if !ok {
+		return GroupVersion{Version: apiVersion}
+	}
+	return GroupVersion{Group: group, Version: version}
+}
+
+type GroupVersionKind struct {
+	Group   string `json:"group"`
+	Version string `json:"version"`
+	Kind    string `json:"kind"`
+}
+
+func (gvk GroupVersionKind) GroupVersion() GroupVersion {
+	return GroupVersion{Group: gvk.Group, Version: gvk.Version}
+}
+
+func (gvk GroupVersionKind) String() string {
+	if gvk.Version == "" {
+		return gvk.Kind
+	}
+	return gvk.GroupVersion().String() + ", Kind=" + gvk.Kind
+}
+
 type ListOptions struct {
 	Limit    int    `json:"limit,omitempty" query:"limit"`
 	Offset   int    `json:"offset,omitempty" query:"offset"`
 	Selector string `json:"selector,omitempty" query:"selector"`
 }
 
+// WatchOptions selects the objects to watch, <Selector> has the same syntax as ListOptions
+// and <Key> selects a single object.
+// <ResourceVersion> resumes the watch after the event of the version.
+// <BookmarkInterval> is the interval of the Bookmark events, it's decided by the server.
+type WatchOptions struct {
+	Key              string        `json:"key,omitempty" query:"key"`
+	Selector         string        `json:"selector,omitempty" query:"selector"`
+	ResourceVersion  string        `json:"resourceVersion,omitempty" query:"resourceVersion"`
+	BookmarkInterval time.Duration `json:"-" query:"-"`
+}
+
+// ObjectList is a page of the objects. <ResourceVersion> is the position of the watch stream
+// taken before the list, the watch resumed from it misses no change made after the list.
 type ObjectList[T Object] struct {
-	Count    int64 `json:"count"`
-	Continue bool  `json:"continue"`
-	Items    []T   `json:"items"`
+	Count           int64  `json:"count"`
+	Continue        bool   `json:"continue"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	Items           []T    `json:"items"`
 }
 
 type Status struct {
@@ -64,4 +148,21 @@ type Status struct {
 	Reason string `json:"reason"`
 	// Message is the human-readable description for current status
 	Message string `json:"message"`
+	// Details is the extended data of the reason, e.g. the invalid fields
+	Details *StatusDetails `json:"details,omitempty"`
+}
+
+// StatusDetails describes the object of the failed operation and the causes of the failure
+type StatusDetails struct {
+	Kind   string        `json:"kind,omitempty"`
+	Key    string        `json:"key,omitempty"`
+	Causes []StatusCause `json:"causes,omitempty"`
+}
+
+// StatusCause is a cause of the failure, <Field> is the path of the field that caused it, e.g.
+// "spec.containers[0].image", it's empty if the cause isn't a field.
+type StatusCause struct {
+	Field   string `json:"field,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
 }
@@ -7,18 +7,70 @@ import (
 )
 
 type Scheme struct {
-	mu         sync.Mutex
-	typeToKind map[reflect.Type]string
-	KindToType map[string]reflect.Type
+	mu          sync.RWMutex
+	typeToKind  map[reflect.Type]string
+	KindToType  map[string]reflect.Type
+	typeToGVK   map[reflect.Type]GroupVersionKind
+	gvkToType   map[GroupVersionKind]reflect.Type
+	conversions map[typePair]ConversionFunc
+	codecs      []Codec
 }
 
+// ConversionFunc converts <in> to <out>, the types of them are registered by AddConversionFunc
+type ConversionFunc func(in, out Object) error
+
+type typePair struct {
+	in, out reflect.Type
+}
+
+// NewScheme creates a Scheme, the JSONCodec is registered as the default codec
 func NewScheme() *Scheme {
 	return &Scheme{
-		typeToKind: map[reflect.Type]string{},
-		KindToType: map[string]reflect.Type{},
+		typeToKind:  map[reflect.Type]string{},
+		KindToType:  map[string]reflect.Type{},
+		typeToGVK:   map[reflect.Type]GroupVersionKind{},
+		gvkToType:   map[GroupVersionKind]reflect.Type{},
+		conversions: map[typePair]ConversionFunc{},
+		codecs:      []Codec{JSONCodec},
+	}
+}
+
+// AddCodecs registers the codecs, a codec replaces the registered one of the same content type
+func (s *Scheme) AddCodecs(codecs ...Codec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+NEXT:
+	for _, codec := range codecs {
+		for i := range s.codecs {
+			if s.codecs[i].ContentType() == codec.ContentType() {
+				s.codecs[i] = codec
+				continue NEXT
+			}
+		}
+		s.codecs = append(s.codecs, codec)
 	}
 }
 
+// Codecs returns all the registered codecs, the first one is the default
+func (s *Scheme) Codecs() []Codec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Codec(nil), s.codecs...)
+}
+
+// Codec returns the codec of the content type
+func (s *Scheme) Codec(contentType string) (Codec, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, codec := range s.codecs {
+		if codec.ContentType() == contentType {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
 func (s *Scheme) AddKnownTypes(types ...Object) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -34,11 +86,146 @@ func (s *Scheme) AddKnownTypes(types ...Object) error {
 		}
 		s.KindToType[rt.Name()] = rt
 		s.typeToKind[rt] = rt.Name()
+		gvk := GroupVersionKind{Kind: rt.Name()}
+		s.typeToGVK[rt] = gvk
+		s.gvkToType[gvk] = rt
+	}
+	return nil
+}
+
+// AddVersionedTypes registers the types of the group version, the kind is the type name.
+// The types of the same kind in the different versions are the versions of a resource.
+// KindToType keeps the first registered type of a kind.
+func (s *Scheme) AddVersionedTypes(gv GroupVersion, types ...Object) error {
+	for _, t := range types {
+		rt := reflect.TypeOf(t)
+		if rt.Kind() != reflect.Pointer {
+			return fmt.Errorf("all types must be pointer")
+		}
+		if err := s.AddKnownTypeWithName(gv.WithKind(rt.Elem().Name()), t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddKnownTypeWithName registers the type as the GroupVersionKind, e.g. the types of the
+// versions have different names in a package.
+func (s *Scheme) AddKnownTypeWithName(gvk GroupVersionKind, obj Object) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if gvk.Version == "" {
+		return fmt.Errorf("the version can't be empty")
+	}
+	rt := reflect.TypeOf(obj)
+	if rt.Kind() != reflect.Pointer {
+		return fmt.Errorf("all types must be pointer")
+	}
+	rt = rt.Elem()
+	if _, ok := s.gvkToType[gvk]; ok {
+		return fmt.Errorf("type %q already added", gvk)
+	}
+	if _, ok := s.typeToGVK[rt]; ok {
+		return fmt.Errorf("type %q already added as %q", rt.Name(), s.typeToGVK[rt])
+	}
+	if _, ok := s.KindToType[gvk.Kind]; !ok {
+		s.KindToType[gvk.Kind] = rt
+	}
+	s.typeToKind[rt] = gvk.Kind
+	s.typeToGVK[rt] = gvk
+	s.gvkToType[gvk] = rt
+	return nil
+}
+
+// ObjectGroupVersionKind returns the GroupVersionKind of the registered object, the group
+// and version are empty if it's registered by AddKnownTypes.
+func (s *Scheme) ObjectGroupVersionKind(obj Object) (GroupVersionKind, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rt := reflect.TypeOf(obj)
+	if rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	gvk, ok := s.typeToGVK[rt]
+	if !ok {
+		return GroupVersionKind{}, fmt.Errorf("%q not registered", rt.Name())
+	}
+	return gvk, nil
+}
+
+// New creates an object of the GroupVersionKind
+func (s *Scheme) New(gvk GroupVersionKind) (Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rt, ok := s.gvkToType[gvk]
+	if !ok {
+		return nil, fmt.Errorf("%q not registered", gvk)
+	}
+	return reflect.New(rt).Interface().(Object), nil
+}
+
+// SetTypeMeta sets the kind and the apiVersion of the object by the registration, the
+// apiVersion is set only if the object is Versioned.
+func (s *Scheme) SetTypeMeta(obj Object) error {
+	gvk, err := s.ObjectGroupVersionKind(obj)
+	if err != nil {
+		return err
+	}
+	obj.SetKind(gvk.Kind)
+	if versioned, ok := obj.(Versioned); ok {
+		versioned.SetAPIVersion(gvk.GroupVersion().String())
+	}
+	return nil
+}
+
+// AddConversionFunc registers the conversion from the type of <in> to the type of <out>.
+// See AddConversion for the typed version.
+func (s *Scheme) AddConversionFunc(in, out Object, fn ConversionFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inType, outType := reflect.TypeOf(in), reflect.TypeOf(out)
+	if inType.Kind() != reflect.Pointer || outType.Kind() != reflect.Pointer {
+		return fmt.Errorf("all types must be pointer")
+	}
+	s.conversions[typePair{in: inType, out: outType}] = fn
+	return nil
+}
+
+// AddConversion registers the typed conversion function between the versions
+func AddConversion[In, Out Object](s *Scheme, fn func(in In, out Out) error) error {
+	var in In
+	var out Out
+	return s.AddConversionFunc(in, out, func(in, out Object) error {
+		return fn(in.(In), out.(Out))
+	})
+}
+
+// Convert converts <in> to <out> by the registered conversion function, the objects of the
+// same type are copied. The kind and apiVersion of <out> are set if the type is registered.
+func (s *Scheme) Convert(in, out Object) error {
+	inType, outType := reflect.TypeOf(in), reflect.TypeOf(out)
+	if inType == outType {
+		reflect.ValueOf(out).Elem().Set(reflect.ValueOf(in).Elem())
+	} else {
+		s.mu.RLock()
+		fn, ok := s.conversions[typePair{in: inType, out: outType}]
+		s.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("no conversion from %s to %s", inType.Elem().Name(), outType.Elem().Name())
+		}
+		if err := fn(in, out); err != nil {
+			return err
+		}
 	}
+	// the unregistered types are converted too
+	_ = s.SetTypeMeta(out)
 	return nil
 }
 
 func (s *Scheme) AllKnownTypes() map[string]reflect.Type {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	var allKnownTypes = map[string]reflect.Type{}
 	for name, t := range s.KindToType {
 		allKnownTypes[name] = t
@@ -47,6 +234,8 @@ func (s *Scheme) AllKnownTypes() map[string]reflect.Type {
 }
 
 func (s *Scheme) ObjectKind(obj Object) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	rt := reflect.TypeOf(obj)
 	if rt.Kind() == reflect.Pointer {
 		rt = rt.Elem()
@@ -0,0 +1,60 @@
+package apis
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// keyed implements Object without the apiVersion
+type keyed struct {
+	key, kind string
+}
+
+func (k *keyed) GetKey() string             { return k.key }
+func (k *keyed) SetKey(key string)          { k.key = key }
+func (k *keyed) GetKind() string            { return k.kind }
+func (k *keyed) SetKind(kind string)        { k.kind = kind }
+func (k *keyed) GetResourceVersion() string { return "" }
+
+type bar struct {
+	ObjectMeta
+}
+
+func TestSchemeSetTypeMeta(t *testing.T) {
+	scheme := NewScheme()
+	assert.Nil(t, scheme.AddVersionedTypes(GroupVersion{Group: "apps", Version: "v1"}, &bar{}, &keyed{}))
+
+	b := &bar{}
+	assert.Nil(t, scheme.SetTypeMeta(b))
+	assert.Equal(t, "bar", b.Kind)
+	assert.Equal(t, "apps/v1", b.APIVersion)
+
+	// the objects aren't Versioned get the kind only
+	k := &keyed{}
+	assert.Nil(t, scheme.SetTypeMeta(k))
+	assert.Equal(t, "keyed", k.kind)
+}
+
+func TestSchemeConcurrentAccess(t *testing.T) {
+	scheme := NewScheme()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		assert.Nil(t, scheme.AddKnownTypes(&bar{}))
+		assert.Nil(t, scheme.AddVersionedTypes(GroupVersion{Group: "apps", Version: "v1"}, &keyed{}))
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_ = scheme.AllKnownTypes()
+			_, _ = scheme.ObjectKind(&bar{})
+		}
+	}()
+	wg.Wait()
+	kind, err := scheme.ObjectKind(&keyed{})
+	assert.Nil(t, err)
+	assert.Equal(t, "keyed", kind)
+}
@@ -0,0 +1,92 @@
+package authentication
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/emicklei/go-restful/v3"
+
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
+
+// UserInfo is the authenticated caller of a request
+type UserInfo struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Authenticator authenticates the requests, <ok> is false if the request doesn't carry the
+// credentials it knows. The error means the credentials are invalid.
+type Authenticator interface {
+	AuthenticateRequest(req *http.Request) (user *UserInfo, ok bool, err error)
+}
+
+type userKey struct{}
+
+// WithUser returns a copy of ctx carries the user
+func WithUser(ctx context.Context, user *UserInfo) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFrom returns the user of the request context, the nil user set by WithUser means
+// no user too.
+func UserFrom(ctx context.Context) (*UserInfo, bool) {
+	user, ok := ctx.Value(userKey{}).(*UserInfo)
+	return user, ok && user != nil
+}
+
+// systemUser is the user of the trusted in-process callers. It's matched by the pointer, so the
+// authenticated users of the same name aren't the system user.
+var systemUser = &UserInfo{Name: "system:gearbox", Groups: []string{"system:masters"}}
+
+// WithSystemUser returns a copy of ctx carries the system user, the calls of it aren't authorized.
+// It's for the trusted in-process callers only, e.g. the controllers and the authorizers reading
+// the policies, never set it for the requests of the users.
+func WithSystemUser(ctx context.Context) context.Context {
+	return WithUser(ctx, systemUser)
+}
+
+// IsSystemUser returns true if the user of ctx is set by WithSystemUser
+func IsSystemUser(ctx context.Context) bool {
+	user, _ := UserFrom(ctx)
+	return user == systemUser
+}
+
+// unionAuthenticator tries the authenticators in order, the first one knows the credentials decides
+type unionAuthenticator []Authenticator
+
+func Union(authenticators ...Authenticator) Authenticator {
+	return unionAuthenticator(authenticators)
+}
+
+func (union unionAuthenticator) AuthenticateRequest(req *http.Request) (*UserInfo, bool, error) {
+	for _, auth := range union {
+		user, ok, err := auth.AuthenticateRequest(req)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			return user, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// Filter authenticates the requests before they reach the routes, the user is stored in the
+// request context. The unauthenticated requests get a 401 apis.Status.
+func Filter(auth Authenticator) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		user, ok, err := auth.AuthenticateRequest(req.Request)
+		if err == nil && !ok {
+			err = errors.NewUnauthorized("the request isn't authenticated")
+		}
+		if err != nil {
+			status := errors.NewUnauthorized(err.Error()).Status()
+			resp.Header().Set("WWW-Authenticate", `Basic realm="gearbox", Bearer realm="gearbox"`)
+			_ = resp.WriteHeaderAndJson(http.StatusUnauthorized, status, restful.MIME_JSON)
+			return
+		}
+		req.Request = req.Request.WithContext(WithUser(req.Request.Context(), user))
+		chain.ProcessFilter(req, resp)
+	}
+}
@@ -0,0 +1,176 @@
+package authentication
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "auth.csv")
+	assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func newCert(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key
+}
+
+func TestFilter(t *testing.T) {
+	tokens, err := NewTokenFile(writeFile(t, "# token,user,groups\ntoken1,alice,\"dev,ops\"\ntoken2,bob\n"))
+	assert.Nil(t, err)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	users, err := NewBasicAuthFile(writeFile(t, "carol,"+string(hash)+",admin\n"))
+	assert.Nil(t, err)
+	_, err = NewBasicAuthFile(writeFile(t, "carol,secret\n"))
+	assert.NotNil(t, err)
+
+	ca, caKey := newCert(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	client, _ := newCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "dave", Organization: []string{"system"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	untrusted, _ := newCert(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "eve"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+
+	var user *UserInfo
+	container := restful.NewContainer()
+	container.Filter(Filter(Union(tokens, users, NewX509(roots))))
+	ws := new(restful.WebService)
+	ws.Route(ws.GET("/whoami").To(func(req *restful.Request, resp *restful.Response) {
+		user, _ = UserFrom(req.Request.Context())
+	}))
+	container.Add(ws)
+
+	cases := []struct {
+		name  string
+		setup func(req *http.Request)
+		user  *UserInfo
+	}{
+		{"bearer", func(req *http.Request) { req.Header.Set("Authorization", "Bearer token1") }, &UserInfo{Name: "alice", Groups: []string{"dev", "ops"}}},
+		{"bearer without groups", func(req *http.Request) { req.Header.Set("Authorization", "bearer token2") }, &UserInfo{Name: "bob"}},
+		{"invalid bearer", func(req *http.Request) { req.Header.Set("Authorization", "Bearer token3") }, nil},
+		{"basic", func(req *http.Request) { req.SetBasicAuth("carol", "secret") }, &UserInfo{Name: "carol", Groups: []string{"admin"}}},
+		{"invalid basic", func(req *http.Request) { req.SetBasicAuth("carol", "wrong") }, nil},
+		{"client certificate", func(req *http.Request) {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{client}}
+		}, &UserInfo{Name: "dave", Groups: []string{"system"}}},
+		{"untrusted certificate", func(req *http.Request) {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{untrusted}}
+		}, nil},
+		{"anonymous", func(req *http.Request) {}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			user = nil
+			req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+			c.setup(req)
+			w := httptest.NewRecorder()
+			container.ServeHTTP(w, req)
+			if c.user == nil {
+				assert.Equal(t, http.StatusUnauthorized, w.Code)
+				var status apis.Status
+				assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+				assert.Equal(t, http.StatusUnauthorized, status.Code)
+				assert.Nil(t, user)
+				return
+			}
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, c.user, user)
+		})
+	}
+}
+
+func TestUnionFileAuthenticators(t *testing.T) {
+	tokens1, err := NewTokenFile(writeFile(t, "token1,alice\n"))
+	assert.Nil(t, err)
+	tokens2, err := NewTokenFile(writeFile(t, "token2,bob\n"))
+	assert.Nil(t, err)
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.Nil(t, err)
+	users1, err := NewBasicAuthFile(writeFile(t, "carol,"+string(hash)+"\n"))
+	assert.Nil(t, err)
+	users2, err := NewBasicAuthFile(writeFile(t, "dave,"+string(hash)+"\n"))
+	assert.Nil(t, err)
+	union := Union(tokens1, users1, tokens2, users2)
+
+	// the credentials unknown by the first sources are authenticated by the next ones
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer token2")
+	user, ok, err := union.AuthenticateRequest(req)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, &UserInfo{Name: "bob"}, user)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("dave", "secret")
+	user, ok, err = union.AuthenticateRequest(req)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, &UserInfo{Name: "dave"}, user)
+
+	// the credentials unknown by all are not authenticated
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer token3")
+	_, ok, err = union.AuthenticateRequest(req)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("erin", "secret")
+	_, ok, err = union.AuthenticateRequest(req)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// the wrong password of a known user is an error
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("carol", "wrong")
+	_, ok, err = union.AuthenticateRequest(req)
+	assert.NotNil(t, err)
+	assert.False(t, ok)
+}
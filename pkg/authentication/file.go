@@ -0,0 +1,150 @@
+package authentication
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// readCSV reads the records of the csv file, the lines start with '#' are comments.
+// Every record has the fields of <minFields> at least.
+func readCSV(path string, minFields int) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed: %w", path, err)
+	}
+	for i, record := range records {
+		if len(record) < minFields {
+			return nil, fmt.Errorf("read %s failed: the record %d has %d fields, %d at least", path, i+1, len(record), minFields)
+		}
+	}
+	return records, nil
+}
+
+// parseGroups parses the optional groups field, the groups are separated by comma
+func parseGroups(record []string, idx int) []string {
+	if len(record) <= idx {
+		return nil
+	}
+	var groups []string
+	for _, group := range strings.Split(record[idx], ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+type tokenAuthenticator struct {
+	tokens map[string]*UserInfo
+}
+
+// NewTokenFile authenticates the static bearer tokens of the csv file, a line is
+// `token,user,"group1,group2"` and the groups are optional. The unknown tokens are left to
+// the next authenticator of the Union.
+func NewTokenFile(path string) (Authenticator, error) {
+	records, err := readCSV(path, 2)
+	if err != nil {
+		return nil, err
+	}
+	auth := &tokenAuthenticator{tokens: map[string]*UserInfo{}}
+	for _, record := range records {
+		if _, ok := auth.tokens[record[0]]; ok {
+			return nil, fmt.Errorf("read %s failed: the token of %q is duplicated", path, record[1])
+		}
+		auth.tokens[record[0]] = &UserInfo{Name: record[1], Groups: parseGroups(record, 2)}
+	}
+	return auth, nil
+}
+
+func (auth *tokenAuthenticator) AuthenticateRequest(req *http.Request) (*UserInfo, bool, error) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, false, nil
+	}
+	token = strings.TrimSpace(token)
+	for known, user := range auth.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return user, true, nil
+		}
+	}
+	// the token may be known by the next authenticator
+	return nil, false, nil
+}
+
+type basicAuthenticator struct {
+	users map[string]basicUser
+	// dummy is compared for the unknown users, so that they take the same time as the
+	// known ones
+	dummy []byte
+}
+
+type basicUser struct {
+	hash []byte
+	info *UserInfo
+}
+
+// NewBasicAuthFile authenticates the HTTP basic auth by the users of the csv file, a line is
+// `user,bcrypt hash of the password,"group1,group2"` and the groups are optional. The unknown
+// users are left to the next authenticator of the Union, the wrong password of a known user
+// is an error.
+func NewBasicAuthFile(path string) (Authenticator, error) {
+	records, err := readCSV(path, 2)
+	if err != nil {
+		return nil, err
+	}
+	auth := &basicAuthenticator{users: map[string]basicUser{}}
+	maxCost := bcrypt.MinCost
+	for _, record := range records {
+		cost, err := bcrypt.Cost([]byte(record[1]))
+		if err != nil {
+			return nil, fmt.Errorf("read %s failed: the password of %q isn't a bcrypt hash", path, record[0])
+		}
+		if cost > maxCost {
+			maxCost = cost
+		}
+		if _, ok := auth.users[record[0]]; ok {
+			return nil, fmt.Errorf("read %s failed: the user %q is duplicated", path, record[0])
+		}
+		auth.users[record[0]] = basicUser{
+			hash: []byte(record[1]),
+			info: &UserInfo{Name: record[0], Groups: parseGroups(record, 2)},
+		}
+	}
+	auth.dummy, err = bcrypt.GenerateFromPassword([]byte("gearbox"), maxCost)
+	if err != nil {
+		return nil, err
+	}
+	return auth, nil
+}
+
+func (auth *basicAuthenticator) AuthenticateRequest(req *http.Request) (*UserInfo, bool, error) {
+	name, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, false, nil
+	}
+	user, ok := auth.users[name]
+	if !ok {
+		// the user may be known by the next authenticator
+		_ = bcrypt.CompareHashAndPassword(auth.dummy, []byte(password))
+		return nil, false, nil
+	}
+	if bcrypt.CompareHashAndPassword(user.hash, []byte(password)) != nil {
+		return nil, false, fmt.Errorf("invalid username or password")
+	}
+	return user.info, true, nil
+}
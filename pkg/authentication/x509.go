@@ -0,0 +1,40 @@
+package authentication
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+)
+
+type x509Authenticator struct {
+	roots *x509.CertPool
+}
+
+// NewX509 authenticates the TLS client certificates signed by the roots, the user name is the
+// common name and the groups are the organizations of the subject.
+func NewX509(roots *x509.CertPool) Authenticator {
+	return &x509Authenticator{roots: roots}
+}
+
+func (auth *x509Authenticator) AuthenticateRequest(req *http.Request) (*UserInfo, bool, error) {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+		return nil, false, nil
+	}
+	cert := req.TLS.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range req.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(c)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         auth.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("verify the client certificate failed: %w", err)
+	}
+	if cert.Subject.CommonName == "" {
+		return nil, false, fmt.Errorf("the common name of the client certificate is empty")
+	}
+	return &UserInfo{Name: cert.Subject.CommonName, Groups: cert.Subject.Organization}, true, nil
+}
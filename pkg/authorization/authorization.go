@@ -0,0 +1,92 @@
+package authorization
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/storage/selector"
+)
+
+const (
+	VerbGet    = "get"
+	VerbList   = "list"
+	VerbWatch  = "watch"
+	VerbCreate = "create"
+	VerbUpdate = "update"
+	VerbDelete = "delete"
+)
+
+// Attributes describes the operation to authorize.
+// <Key> is empty for list, watch of all the objects.
+// <Selector> is the selector of list and watch.
+// <Object> is the object operated if it's known, e.g. the stored object of get, update and
+// delete, and the new object of create and update.
+type Attributes struct {
+	User     *authentication.UserInfo
+	Verb     string
+	Group    string
+	Resource string
+	Key      string
+	Selector string
+	Object   apis.Object
+}
+
+// Authorizer decides whether the user can do the operation, <reason> explains the decision.
+type Authorizer interface {
+	Authorize(ctx context.Context, attrs Attributes) (allowed bool, reason string, err error)
+}
+
+// MatchObject returns true if the fields of the object match the requirements, the fields
+// are the top level fields of the JSON encoded object.
+func MatchObject(requirements []selector.Requirement, obj apis.Object) (bool, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return false, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false, err
+	}
+	set := labels.Set{}
+	for name, value := range fields {
+		switch v := value.(type) {
+		case string:
+			set[name] = v
+		case float64:
+			set[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			set[name] = strconv.FormatBool(v)
+		}
+	}
+	for _, r := range requirements {
+		if !r.Matches(set) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CoveredBy returns true if the <requested> selector selects a subset of the objects selected
+// by <requirements>, i.e. it contains all the requirements.
+func CoveredBy(requirements []selector.Requirement, requested string) (bool, error) {
+	requestedReqs, err := selector.Parse(requested)
+	if err != nil {
+		return false, fmt.Errorf("invalid selector %q: %w", requested, err)
+	}
+	contains := map[string]bool{}
+	for _, r := range requestedReqs {
+		contains[r.String()] = true
+	}
+	for _, r := range requirements {
+		if !contains[r.String()] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
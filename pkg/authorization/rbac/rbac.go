@@ -0,0 +1,184 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/authorization"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/storage/selector"
+)
+
+// GroupVersion of the RBAC resources
+var GroupVersion = apis.GroupVersion{Group: "rbac", Version: "v1"}
+
+const (
+	// All matches all the verbs, groups or resources
+	All = "*"
+
+	SubjectKindUser  = "User"
+	SubjectKindGroup = "Group"
+)
+
+// PolicyRule allows the verbs on the resources.
+// <APIGroups> are the groups of the resources, empty matches all the groups.
+// <Keys> limits the rule to the objects of the keys, empty means all the objects.
+// <Selector> limits the rule to the objects match the selector, the list and watch must
+// select them by the selector too.
+type PolicyRule struct {
+	Verbs     []string `json:"verbs"`
+	APIGroups []string `json:"apiGroups,omitempty"`
+	Resources []string `json:"resources"`
+	Keys      []string `json:"keys,omitempty"`
+	Selector  string   `json:"selector,omitempty"`
+}
+
+// Role is a set of the rules
+type Role struct {
+	apis.ObjectMeta
+	Rules []PolicyRule `json:"rules"`
+}
+
+// Subject is a user or a group
+type Subject struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// RoleBinding grants the rules of the role of <RoleRef> key to the subjects
+type RoleBinding struct {
+	apis.ObjectMeta
+	RoleRef  string    `json:"roleRef"`
+	Subjects []Subject `json:"subjects"`
+}
+
+// AddToScheme registers the RBAC resources in the scheme
+func AddToScheme(scheme *apis.Scheme) error {
+	return scheme.AddVersionedTypes(GroupVersion, &Role{}, &RoleBinding{})
+}
+
+// Authorizer is the RBAC authorizer, it authorizes the requests by the roles and bindings cached
+// in memory. The cache is kept in sync with the resources by Run, the bindings are indexed by the
+// subjects so that a request reads only the bindings of its user and groups.
+type Authorizer struct {
+	roleClient    rest.WatchableClient[*Role]
+	bindingClient rest.WatchableClient[*RoleBinding]
+
+	mu       sync.RWMutex
+	roles    map[string]*Role
+	bindings map[string]*RoleBinding
+	// subjects indexes the keys of the bindings by their subjects
+	subjects       map[Subject]map[string]struct{}
+	rolesSynced    bool
+	bindingsSynced bool
+}
+
+// NewAuthorizer creates the RBAC authorizer of the roles and bindings read through the clients,
+// e.g. the RestAPI of them backed by a storage.Store. It denies all the requests until Run has
+// listed both of them.
+func NewAuthorizer(roles rest.WatchableClient[*Role], bindings rest.WatchableClient[*RoleBinding]) *Authorizer {
+	return &Authorizer{
+		roleClient:    roles,
+		bindingClient: bindings,
+		roles:         map[string]*Role{},
+		bindings:      map[string]*RoleBinding{},
+		subjects:      map[Subject]map[string]struct{}{},
+	}
+}
+
+// HasSynced returns true once Run has listed the roles and bindings
+func (a *Authorizer) HasSynced() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.rolesSynced && a.bindingsSynced
+}
+
+func (a *Authorizer) Authorize(ctx context.Context, attrs authorization.Attributes) (bool, string, error) {
+	if attrs.User == nil {
+		return false, "the request isn't authenticated", nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !a.rolesSynced || !a.bindingsSynced {
+		return false, "", errors.NewServiceUnavailable("the RBAC roles and bindings aren't synced yet")
+	}
+	for _, key := range a.boundBindings(attrs.User) {
+		binding := a.bindings[key]
+		role, ok := a.roles[binding.RoleRef]
+		if !ok {
+			// the binding of a deleted role grants nothing
+			continue
+		}
+		for _, rule := range role.Rules {
+			ok, err := matches(rule, attrs)
+			if err != nil {
+				return false, "", fmt.Errorf("the rule of the role %q is invalid: %w", role.Key, err)
+			}
+			if ok {
+				return true, fmt.Sprintf("allowed by the role binding %q", binding.Key), nil
+			}
+		}
+	}
+	return false, fmt.Sprintf("user %q cannot %s the resource %q in the group %q", attrs.User.Name, attrs.Verb, attrs.Resource, attrs.Group), nil
+}
+
+// boundBindings returns the sorted keys of the bindings bound to the user or its groups, the
+// order makes the reason of the allowed requests stable.
+func (a *Authorizer) boundBindings(user *authentication.UserInfo) []string {
+	keys := map[string]struct{}{}
+	subjects := []Subject{{Kind: SubjectKindUser, Name: user.Name}}
+	for _, group := range user.Groups {
+		subjects = append(subjects, Subject{Kind: SubjectKindGroup, Name: group})
+	}
+	for _, subject := range subjects {
+		for key := range a.subjects[subject] {
+			keys[key] = struct{}{}
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == All || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(rule PolicyRule, attrs authorization.Attributes) (bool, error) {
+	if !contains(rule.Verbs, attrs.Verb) || !contains(rule.Resources, attrs.Resource) {
+		return false, nil
+	}
+	if len(rule.APIGroups) != 0 && !contains(rule.APIGroups, attrs.Group) {
+		return false, nil
+	}
+	if len(rule.Keys) != 0 && (attrs.Key == "" || !contains(rule.Keys, attrs.Key)) {
+		return false, nil
+	}
+	if rule.Selector == "" {
+		return true, nil
+	}
+	requirements, err := selector.Parse(rule.Selector)
+	if err != nil {
+		return false, err
+	}
+	if attrs.Object != nil {
+		return authorization.MatchObject(requirements, attrs.Object)
+	}
+	if attrs.Verb == authorization.VerbList || attrs.Verb == authorization.VerbWatch {
+		return authorization.CoveredBy(requirements, attrs.Selector)
+	}
+	return false, nil
+}
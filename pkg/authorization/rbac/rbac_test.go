@@ -0,0 +1,144 @@
+package rbac
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/authorization"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+type fakeChannel struct {
+	ch chan rest.Event
+}
+
+func (c *fakeChannel) Stop() {}
+
+func (c *fakeChannel) ResultChan() (<-chan rest.Event, error) {
+	return c.ch, nil
+}
+
+// fakeClient lists the objects and watches the events of the channel, only the system user
+// can read them
+type fakeClient[T apis.Object] struct {
+	rest.Client[T]
+	objs []T
+	ch   chan rest.Event
+}
+
+// GetList pages the objects like the storage, the zero limit lists nothing
+func (c *fakeClient[T]) GetList(ctx context.Context, opts apis.ListOptions) ([]T, int64, error) {
+	if !authentication.IsSystemUser(ctx) {
+		return nil, 0, errors.NewForbidden("list", "Role", "", "the authorizer reads without the system user")
+	}
+	start, end := opts.Offset, opts.Offset+opts.Limit
+	if start > len(c.objs) {
+		start = len(c.objs)
+	}
+	if end > len(c.objs) {
+		end = len(c.objs)
+	}
+	return c.objs[start:end], int64(len(c.objs)), nil
+}
+
+func (c *fakeClient[T]) Watch(ctx context.Context, opts apis.WatchOptions) (rest.Channel, error) {
+	if !authentication.IsSystemUser(ctx) {
+		return nil, errors.NewForbidden("watch", "Role", "", "the authorizer reads without the system user")
+	}
+	return &fakeChannel{ch: c.ch}, nil
+}
+
+type pod struct {
+	apis.ObjectMeta
+	Image string `json:"image"`
+}
+
+func TestAuthorizer(t *testing.T) {
+	roles := &fakeClient[*Role]{ch: make(chan rest.Event), objs: []*Role{
+		{ObjectMeta: apis.ObjectMeta{Key: "viewer"}, Rules: []PolicyRule{{Verbs: []string{"get", "list", "watch"}, Resources: []string{All}}}},
+		{ObjectMeta: apis.ObjectMeta{Key: "nginx-editor"}, Rules: []PolicyRule{{
+			Verbs: []string{All}, APIGroups: []string{"apps"}, Resources: []string{"pods"}, Selector: "image=nginx",
+		}}},
+		{ObjectMeta: apis.ObjectMeta{Key: "foo-deleter"}, Rules: []PolicyRule{{Verbs: []string{"delete"}, Resources: []string{"pods"}, Keys: []string{"foo"}}}},
+	}}
+	bindings := &fakeClient[*RoleBinding]{ch: make(chan rest.Event), objs: []*RoleBinding{
+		{ObjectMeta: apis.ObjectMeta{Key: "devs"}, RoleRef: "viewer", Subjects: []Subject{{Kind: SubjectKindGroup, Name: "dev"}}},
+		{ObjectMeta: apis.ObjectMeta{Key: "alice"}, RoleRef: "nginx-editor", Subjects: []Subject{{Kind: SubjectKindUser, Name: "alice"}}},
+		{ObjectMeta: apis.ObjectMeta{Key: "bob"}, RoleRef: "foo-deleter", Subjects: []Subject{{Kind: SubjectKindUser, Name: "bob"}}},
+		{ObjectMeta: apis.ObjectMeta{Key: "dangling"}, RoleRef: "deleted", Subjects: []Subject{{Kind: SubjectKindUser, Name: "bob"}}},
+	}}
+	// the bindings and roles take several pages
+	defer func(pageSize int) { rest.ListPageSize = pageSize }(rest.ListPageSize)
+	rest.ListPageSize = 2
+	authz := NewAuthorizer(roles, bindings)
+	bob := &authentication.UserInfo{Name: "bob", Groups: []string{"dev"}}
+	listFoos := authorization.Attributes{User: bob, Verb: "list", Resource: "foos"}
+	_, _, err := authz.Authorize(context.Background(), listFoos)
+	assert.True(t, errors.IsServiceUnavailableError(err), err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go authz.Run(ctx, logr.Discard())
+	assert.Eventually(t, authz.HasSynced, 5*time.Second, 10*time.Millisecond)
+	alice := &authentication.UserInfo{Name: "alice"}
+	nginx := &pod{Image: "nginx"}
+	redis := &pod{Image: "redis"}
+
+	cases := []struct {
+		name    string
+		attrs   authorization.Attributes
+		allowed bool
+	}{
+		{"group binding", listFoos, true},
+		{"verb not allowed", authorization.Attributes{User: bob, Verb: "create", Resource: "foos"}, false},
+		{"key", authorization.Attributes{User: bob, Verb: "delete", Resource: "pods", Key: "foo"}, true},
+		{"other key", authorization.Attributes{User: bob, Verb: "delete", Resource: "pods", Key: "bar"}, false},
+		{"selector matches the object", authorization.Attributes{User: alice, Verb: "update", Group: "apps", Resource: "pods", Key: "foo", Object: nginx}, true},
+		{"selector mismatches the object", authorization.Attributes{User: alice, Verb: "update", Group: "apps", Resource: "pods", Key: "foo", Object: redis}, false},
+		{"other group", authorization.Attributes{User: alice, Verb: "update", Resource: "pods", Key: "foo", Object: nginx}, false},
+		{"list by the selector", authorization.Attributes{User: alice, Verb: "list", Group: "apps", Resource: "pods", Selector: "image=nginx,key=foo"}, true},
+		{"list all", authorization.Attributes{User: alice, Verb: "list", Group: "apps", Resource: "pods"}, false},
+		{"unknown object", authorization.Attributes{User: alice, Verb: "get", Group: "apps", Resource: "pods", Key: "foo"}, false},
+		{"anonymous", authorization.Attributes{Verb: "list", Resource: "foos"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := authentication.WithUser(context.Background(), c.attrs.User)
+			allowed, reason, err := authz.Authorize(ctx, c.attrs)
+			assert.Nil(t, err)
+			assert.Equal(t, c.allowed, allowed, reason)
+		})
+	}
+
+	// the events update the roles and the index of the bindings
+	bindings.ch <- rest.Event{Type: watch.EventTypeUpdated, Obj: &RoleBinding{
+		ObjectMeta: apis.ObjectMeta{Key: "devs"}, RoleRef: "viewer", Subjects: []Subject{{Kind: SubjectKindUser, Name: "carol"}},
+	}}
+	roles.ch <- rest.Event{Type: watch.EventTypeDeleted, Obj: &Role{ObjectMeta: apis.ObjectMeta{Key: "foo-deleter"}}}
+	carol := &authentication.UserInfo{Name: "carol"}
+	assert.Eventually(t, func() bool {
+		allowed, _, _ := authz.Authorize(context.Background(), authorization.Attributes{User: carol, Verb: "get", Resource: "pods"})
+		return allowed
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		allowed, _, _ := authz.Authorize(context.Background(), authorization.Attributes{User: bob, Verb: "delete", Resource: "pods", Key: "foo"})
+		return !allowed
+	}, 5*time.Second, 10*time.Millisecond)
+	allowed, _, err := authz.Authorize(context.Background(), listFoos)
+	assert.Nil(t, err)
+	assert.False(t, allowed)
+
+	scheme := apis.NewScheme()
+	assert.Nil(t, AddToScheme(scheme))
+	gvk, err := scheme.ObjectGroupVersionKind(&RoleBinding{})
+	assert.Nil(t, err)
+	assert.Equal(t, GroupVersion.WithKind("RoleBinding"), gvk)
+}
@@ -0,0 +1,170 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+// ResyncInterval is the interval to list and watch the roles or bindings again after the watch ends
+var ResyncInterval = time.Second
+
+// Run keeps the roles and bindings of the authorizer in sync with the resources until ctx is done.
+// Like policy.Sync, it watches each of them, replaces the cached ones with the listed ones and then
+// applies the watch events, the list and watch are restarted if the watch ends. The clients are
+// called by the system user, so that the RestAPI of them doesn't authorize the reads again.
+func (a *Authorizer) Run(ctx context.Context, logger logr.Logger) {
+	ctx = authentication.WithSystemUser(ctx)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		resync(ctx, logger.WithValues("resource", "roles"), func(ctx context.Context) error {
+			return syncResource[*Role](ctx, a.roleClient, a.replaceRoles, a.setRole, a.removeRole)
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		resync(ctx, logger.WithValues("resource", "rolebindings"), func(ctx context.Context) error {
+			return syncResource[*RoleBinding](ctx, a.bindingClient, a.replaceBindings, a.setBinding, a.removeBinding)
+		})
+	}()
+	wg.Wait()
+}
+
+func resync(ctx context.Context, logger logr.Logger, sync func(ctx context.Context) error) {
+	for {
+		err := sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error(err, "sync the RBAC resource failed, retry later")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(ResyncInterval):
+		}
+	}
+}
+
+func syncResource[T apis.Object](ctx context.Context, client rest.WatchableClient[T], replace func([]T), set func(T), remove func(string)) error {
+	// watch before the list so that the changes between them aren't lost
+	ch, err := client.Watch(ctx, apis.WatchOptions{})
+	if err != nil {
+		return err
+	}
+	defer ch.Stop()
+	events, err := ch.ResultChan()
+	if err != nil {
+		return err
+	}
+	objs, err := rest.ListAll[T](ctx, client, apis.ListOptions{})
+	if err != nil {
+		return err
+	}
+	replace(objs)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case evt, ok := <-events:
+			if !ok {
+				return fmt.Errorf("the watch is closed")
+			}
+			switch evt.Type {
+			case watch.EventTypeCreated, watch.EventTypeUpdated:
+				if obj, ok := evt.Obj.(T); ok {
+					set(obj)
+				}
+			case watch.EventTypeDeleted:
+				if evt.Obj != nil {
+					remove(evt.Obj.GetKey())
+				}
+			case watch.EventTypeError:
+				if evt.Err != nil {
+					return errors.StatusError{ErrStatus: *evt.Err}
+				}
+				return fmt.Errorf("the watch failed")
+			}
+		}
+	}
+}
+
+func (a *Authorizer) replaceRoles(roles []*Role) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.roles = make(map[string]*Role, len(roles))
+	for _, role := range roles {
+		a.roles[role.Key] = role
+	}
+	a.rolesSynced = true
+}
+
+func (a *Authorizer) setRole(role *Role) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.roles[role.Key] = role
+}
+
+func (a *Authorizer) removeRole(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.roles, key)
+}
+
+func (a *Authorizer) replaceBindings(bindings []*RoleBinding) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.bindings = make(map[string]*RoleBinding, len(bindings))
+	a.subjects = map[Subject]map[string]struct{}{}
+	for _, binding := range bindings {
+		a.indexBinding(binding)
+	}
+	a.bindingsSynced = true
+}
+
+func (a *Authorizer) setBinding(binding *RoleBinding) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.unindexBinding(binding.Key)
+	a.indexBinding(binding)
+}
+
+func (a *Authorizer) removeBinding(key string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.unindexBinding(key)
+}
+
+func (a *Authorizer) indexBinding(binding *RoleBinding) {
+	a.bindings[binding.Key] = binding
+	for _, subject := range binding.Subjects {
+		if a.subjects[subject] == nil {
+			a.subjects[subject] = map[string]struct{}{}
+		}
+		a.subjects[subject][binding.Key] = struct{}{}
+	}
+}
+
+func (a *Authorizer) unindexBinding(key string) {
+	binding, ok := a.bindings[key]
+	if !ok {
+		return
+	}
+	delete(a.bindings, key)
+	for _, subject := range binding.Subjects {
+		delete(a.subjects[subject], key)
+		if len(a.subjects[subject]) == 0 {
+			delete(a.subjects, subject)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/storage"
+)
+
+const defaultPageSize = 100
+
+// Action is the result of importing a single object
+type Action string
+
+const (
+	ActionCreated  Action = "Created"
+	ActionUpdated  Action = "Updated"
+	ActionConflict Action = "Conflict"
+)
+
+// Record is one line of the NDJSON stream, the object is kept in the api form.
+type Record struct {
+	Resource string          `json:"resource"`
+	Kind     string          `json:"kind,omitempty"`
+	Object   json.RawMessage `json:"object"`
+}
+
+// Resource knowns how to export and import the objects of one registered kind.
+type Resource interface {
+	Name() string
+	// Export calls fn for every object of the resource, page by page
+	Export(ctx context.Context, pageSize int, fn func(apis.Object) error) error
+	// Import create the object if its key don't exist, or update it otherwise
+	Import(ctx context.Context, raw []byte, opts ImportOptions) (Action, string, error)
+}
+
+type resource[T any, PT interface {
+	apis.Object
+	*T
+}] struct {
+	name   string
+	client rest.Client[PT]
+}
+
+// NewResource wraps a rest.Client, e.g. a RestAPI or a HTTPRestClient, so the
+// objects behind it can be exported and imported.
+func NewResource[T any, PT interface {
+	apis.Object
+	*T
+}](name string, client rest.Client[PT]) Resource {
+	return &resource[T, PT]{name: name, client: client}
+}
+
+func (r *resource[T, PT]) Name() string {
+	return r.name
+}
+
+func (r *resource[T, PT]) Export(ctx context.Context, pageSize int, fn func(apis.Object) error) error {
+	for offset := 0; ; offset += pageSize {
+		objs, count, err := r.client.GetList(ctx, apis.ListOptions{Offset: offset, Limit: pageSize})
+		if err != nil {
+			return err
+		}
+		for _, obj := range objs {
+			if err := fn(obj); err != nil {
+				return err
+			}
+		}
+		if len(objs) < pageSize || int64(offset+pageSize) >= count {
+			return nil
+		}
+	}
+}
+
+func (r *resource[T, PT]) Import(ctx context.Context, raw []byte, opts ImportOptions) (Action, string, error) {
+	if opts.Overwrite {
+		var err error
+		if raw, err = clearResourceVersion(raw); err != nil {
+			return "", "", err
+		}
+	}
+	var obj = PT(new(T))
+	if err := json.Unmarshal(raw, obj); err != nil {
+		return "", "", err
+	}
+	key := obj.GetKey()
+	if key == "" {
+		return "", "", errors.NewBadRequest("the key can't be empty")
+	}
+
+	_, err := r.client.Get(ctx, key)
+	switch {
+	case errors.IsNotFoundError(err):
+		if _, err := r.client.Create(ctx, obj); err != nil {
+			if errors.IsConflictError(err) {
+				return ActionConflict, key, err
+			}
+			return "", key, err
+		}
+		return ActionCreated, key, nil
+	case err != nil:
+		return "", key, err
+	}
+
+	if err := r.client.Update(ctx, key, obj); err != nil {
+		if errors.IsConflictError(err) {
+			return ActionConflict, key, err
+		}
+		return "", key, err
+	}
+	return ActionUpdated, key, nil
+}
+
+type ExportOptions struct {
+	// PageSize is the number of objects fetched per GetList call, default to 100
+	PageSize int
+}
+
+// Export streams every object of the given resources to w as NDJSON.
+// The objects are listed page by page, writes happen in the meantime may or may not be exported.
+func Export(ctx context.Context, w io.Writer, resources []Resource, opts ExportOptions) error {
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultPageSize
+	}
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	for _, res := range resources {
+		err := res.Export(ctx, opts.PageSize, func(obj apis.Object) error {
+			raw, err := json.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			return encoder.Encode(Record{Resource: res.Name(), Kind: obj.GetKind(), Object: raw})
+		})
+		if err != nil {
+			return fmt.Errorf("export resource %q failed: %w", res.Name(), err)
+		}
+	}
+	return bw.Flush()
+}
+
+type ImportOptions struct {
+	// DryRun sends the writes as dry-run, see storage.WithDryRun, the server runs the admission
+	// and the conflict checks of the writes but persists nothing.
+	DryRun bool
+	// Overwrite drops the resourceVersion of the backup, so the current objects are overwritten
+	// whatever their versions, e.g. to clone the backup into another environment. By default the
+	// objects changed since the backup are reported as conflicts instead of being overwritten.
+	Overwrite bool
+}
+
+// clearResourceVersion removes the resourceVersion of the object, the update of the object
+// without the resourceVersion overwrites the current one.
+func clearResourceVersion(raw []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["resourceVersion"]; !ok {
+		return raw, nil
+	}
+	delete(fields, "resourceVersion")
+	return json.Marshal(fields)
+}
+
+// Conflict describes an object that can't be imported because it conflicts with the current state
+type Conflict struct {
+	Line     int
+	Resource string
+	Key      string
+	Message  string
+}
+
+type Report struct {
+	Created   int
+	Updated   int
+	Conflicts []Conflict
+}
+
+// Import replays the NDJSON stream produced by Export. Objects are created or updated
+// with their original keys, conflicts are collected in the report and don't abort the import.
+func Import(ctx context.Context, r io.Reader, resources []Resource, opts ImportOptions) (*Report, error) {
+	if opts.DryRun {
+		ctx = storage.WithDryRun(ctx)
+	}
+	var byName = map[string]Resource{}
+	for _, res := range resources {
+		byName[res.Name()] = res
+	}
+
+	report := &Report{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return report, fmt.Errorf("line %d: %w", line, err)
+		}
+		res, ok := byName[record.Resource]
+		if !ok {
+			return report, fmt.Errorf("line %d: unknown resource %q", line, record.Resource)
+		}
+		action, key, err := res.Import(ctx, record.Object, opts)
+		switch action {
+		case ActionCreated:
+			report.Created++
+		case ActionUpdated:
+			report.Updated++
+		case ActionConflict:
+			report.Conflicts = append(report.Conflicts, Conflict{
+				Line:     line,
+				Resource: record.Resource,
+				Key:      key,
+				Message:  err.Error(),
+			})
+		default:
+			return report, fmt.Errorf("line %d: import %s %q failed: %w", line, record.Resource, key, err)
+		}
+	}
+	return report, scanner.Err()
+}
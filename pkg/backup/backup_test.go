@@ -0,0 +1,178 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/storage"
+)
+
+type Foo struct {
+	apis.ObjectMeta
+	Bar string `json:"bar"`
+}
+
+type memClient struct {
+	objs map[string]Foo
+}
+
+func (c *memClient) Get(ctx context.Context, key string) (*Foo, error) {
+	obj, ok := c.objs[key]
+	if !ok {
+		return nil, errors.NewNotFound("Foo", key)
+	}
+	return &obj, nil
+}
+
+func (c *memClient) GetList(ctx context.Context, opts apis.ListOptions) ([]*Foo, int64, error) {
+	var keys []string
+	for key := range c.objs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var out []*Foo
+	for i := opts.Offset; i < len(keys) && i < opts.Offset+opts.Limit; i++ {
+		obj := c.objs[keys[i]]
+		out = append(out, &obj)
+	}
+	return out, int64(len(keys)), nil
+}
+
+func (c *memClient) Create(ctx context.Context, obj *Foo) (*Foo, error) {
+	obj.ResourceVersion = "1"
+	if !storage.IsDryRun(ctx) {
+		c.objs[obj.Key] = *obj
+	}
+	return obj, nil
+}
+
+func (c *memClient) Update(ctx context.Context, key string, obj *Foo) error {
+	if obj.ResourceVersion != "" && obj.ResourceVersion != c.objs[key].ResourceVersion {
+		return errors.NewConflict(assert.AnError)
+	}
+	rv, _ := strconv.Atoi(obj.ResourceVersion)
+	obj.ResourceVersion = strconv.Itoa(rv + 1)
+	if !storage.IsDryRun(ctx) {
+		c.objs[key] = *obj
+	}
+	return nil
+}
+
+func (c *memClient) Delete(ctx context.Context, key string) error {
+	delete(c.objs, key)
+	return nil
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := &memClient{objs: map[string]Foo{}}
+	for i := 0; i < 5; i++ {
+		key := "foo" + strconv.Itoa(i)
+		src.objs[key] = Foo{ObjectMeta: apis.ObjectMeta{Kind: "Foo", Key: key, ResourceVersion: "1"}, Bar: key}
+	}
+
+	var buf bytes.Buffer
+	err := Export(ctx, &buf, []Resource{NewResource[Foo, *Foo]("foos", src)}, ExportOptions{PageSize: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, bytes.Count(buf.Bytes(), []byte("\n")))
+
+	dst := &memClient{objs: map[string]Foo{
+		"foo0": {ObjectMeta: apis.ObjectMeta{Key: "foo0", ResourceVersion: "1"}},
+		"foo1": {ObjectMeta: apis.ObjectMeta{Key: "foo1", ResourceVersion: "3"}},
+	}}
+	resources := []Resource{NewResource[Foo, *Foo]("foos", dst)}
+
+	report, err := Import(ctx, bytes.NewReader(buf.Bytes()), resources, ImportOptions{DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, report.Created)
+	assert.Equal(t, 1, report.Updated)
+	assert.Len(t, report.Conflicts, 1)
+	assert.Len(t, dst.objs, 2)
+	assert.Equal(t, "", dst.objs["foo0"].Bar)
+
+	report, err = Import(ctx, bytes.NewReader(buf.Bytes()), resources, ImportOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, report.Created)
+	assert.Equal(t, 1, report.Updated)
+	assert.Len(t, report.Conflicts, 1)
+	assert.Equal(t, "foo1", report.Conflicts[0].Key)
+	assert.Equal(t, "foo2", dst.objs["foo2"].Bar)
+	assert.Equal(t, "foo0", dst.objs["foo0"].Bar)
+
+	// the resourceVersion of the backup is dropped to overwrite the objects
+	report, err = Import(ctx, bytes.NewReader(buf.Bytes()), resources, ImportOptions{Overwrite: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 5, report.Updated)
+	assert.Len(t, report.Conflicts, 0)
+	assert.Equal(t, "foo1", dst.objs["foo1"].Bar)
+
+	_, err = Import(ctx, bytes.NewReader(buf.Bytes()), nil, ImportOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestImportHTTP(t *testing.T) {
+	// the server has "foo0" only, the other keys are 404
+	var updated, created, dryRuns []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/foos/")
+		if r.Method != http.MethodGet {
+			dryRuns = append(dryRuns, r.URL.Query().Get("dryRun"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.Method == http.MethodGet && key == "foo0":
+			_, _ = w.Write([]byte(`{"key": "foo0", "resourceVersion": "7"}`))
+		case r.Method == http.MethodGet:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(errors.NewNotFound("foos", key).Status())
+		case r.Method == http.MethodPut:
+			updated = append(updated, string(body))
+			_, _ = w.Write(body)
+		case r.Method == http.MethodPost:
+			created = append(created, string(body))
+			_, _ = w.Write(body)
+		}
+	}))
+	defer server.Close()
+
+	client := rest.NewHTTPRestClient[RawObject]("foos", server.URL, nil, rest.ClientOptions{})
+	resources := []Resource{NewResource[RawObject, *RawObject]("foos", client)}
+	input := `{"resource": "foos", "object": {"key": "foo0", "resourceVersion": "1", "bar": "a"}}
+{"resource": "foos", "object": {"key": "foo1", "resourceVersion": "1", "bar": "b"}}
+`
+	report, err := Import(context.Background(), strings.NewReader(input), resources, ImportOptions{Overwrite: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Updated)
+	assert.JSONEq(t, `{"key": "foo0", "bar": "a"}`, updated[0])
+	assert.JSONEq(t, `{"key": "foo1", "bar": "b"}`, created[0])
+	assert.Equal(t, []string{"", ""}, dryRuns)
+
+	// the dry-run is checked by the server
+	dryRuns = nil
+	_, err = Import(context.Background(), strings.NewReader(input), resources, ImportOptions{DryRun: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"All", "All"}, dryRuns)
+}
+
+func TestRawObject(t *testing.T) {
+	var obj RawObject
+	assert.Nil(t, obj.UnmarshalJSON([]byte(`{"key":"foo","resourceVersion":"2","bar":"baz"}`)))
+	assert.Equal(t, "foo", obj.GetKey())
+	assert.Equal(t, "2", obj.GetResourceVersion())
+	bs, err := obj.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, `{"key":"foo","resourceVersion":"2","bar":"baz"}`, string(bs))
+}
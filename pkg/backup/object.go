@@ -0,0 +1,29 @@
+package backup
+
+import (
+	"encoding/json"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+)
+
+// RawObject keeps the original JSON document of an object, it can be used to
+// backup the resources whose go type is unknown, e.g. through a HTTPRestClient.
+type RawObject struct {
+	apis.ObjectMeta
+	Raw json.RawMessage
+}
+
+func (o *RawObject) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, &o.ObjectMeta); err != nil {
+		return err
+	}
+	o.Raw = append(o.Raw[0:0], b...)
+	return nil
+}
+
+func (o RawObject) MarshalJSON() ([]byte, error) {
+	if o.Raw == nil {
+		return json.Marshal(o.ObjectMeta)
+	}
+	return o.Raw, nil
+}
@@ -9,10 +9,13 @@ type CreateEvent struct {
 }
 
 type UpdateEvent struct {
+	// ObjectOld is the object before the update
+	ObjectOld apis.Object
 	ObjectNew apis.Object
 }
 
 type DeleteEvent struct {
+	// Object is the final state of the deleted object
 	Object apis.Object
 
 	// DeleteStateUnknown is true if the Delete event was missed but we identified the object
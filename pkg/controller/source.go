@@ -2,8 +2,12 @@ package controller
 
 import (
 	"context"
+	"net/http"
 	"time"
 
+	"github.com/sirupsen/logrus"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
 	"github.com/sunyakun/gearbox/pkg/rest"
 	"github.com/sunyakun/gearbox/pkg/watch"
 )
@@ -14,63 +18,197 @@ type Source interface {
 	Start(context.Context, EventHandler, RateLimiter, ...Predicate) error
 }
 
+// Lister lists all the objects watched by a Source and the resourceVersion of the watch stream
+// taken before the list, see apis.ObjectList.
+type Lister func(ctx context.Context) ([]apis.Object, string, error)
+
+// WatchFunc watches the objects from the resourceVersion
+type WatchFunc func(ctx context.Context, resourceVersion string) (rest.Channel, error)
+
+const (
+	relistMinBackoff = time.Second
+	relistMaxBackoff = 30 * time.Second
+)
+
 type source struct {
 	channel rest.Channel
+	list    Lister
+	watch   WatchFunc
 }
 
-// NewSource create a Srouce to handle the storage Create/Update/Delete event
+// NewSource create a Srouce to handle the storage Create/Update/Delete event. The events
+// missed by an expired watch are lost and the Source stops when the channel is closed, use
+// NewRelistSource to recover them.
 func NewSource(channel rest.Channel) *source {
 	return &source{
 		channel: channel,
 	}
 }
 
+// NewRelistSource create a Source lists the objects by <list> and watches them by <watch> from
+// the resourceVersion of the list. The Source lists and watches again when the watch ends, e.g.
+// it reports the 410 Expired error, the listed objects are delivered as Create events. The objects
+// deleted while the watch is down are not detected.
+func NewRelistSource(list Lister, watch WatchFunc) *source {
+	return &source{
+		list:  list,
+		watch: watch,
+	}
+}
+
+// NewListWatchSource create a relist Source of the objects selected by <opts> through <client>,
+// see NewRelistSource.
+func NewListWatchSource[T apis.Object](client rest.ListWatcher[T], opts apis.WatchOptions) *source {
+	list := func(ctx context.Context) ([]apis.Object, string, error) {
+		var (
+			objs            []apis.Object
+			resourceVersion string
+		)
+		for offset := 0; ; offset += rest.ListPageSize {
+			page, err := client.List(ctx, apis.ListOptions{Offset: offset, Limit: rest.ListPageSize, Selector: opts.Selector})
+			if err != nil {
+				return nil, "", err
+			}
+			// the version of the first page is taken before all the pages
+			if offset == 0 {
+				resourceVersion = page.ResourceVersion
+			}
+			for _, obj := range page.Items {
+				if opts.Key == "" || obj.GetKey() == opts.Key {
+					objs = append(objs, obj)
+				}
+			}
+			if len(page.Items) < rest.ListPageSize || int64(offset+rest.ListPageSize) >= page.Count {
+				return objs, resourceVersion, nil
+			}
+		}
+	}
+	watch := func(ctx context.Context, resourceVersion string) (rest.Channel, error) {
+		watchOpts := opts
+		watchOpts.ResourceVersion = resourceVersion
+		return client.Watch(ctx, watchOpts)
+	}
+	return NewRelistSource(list, watch)
+}
+
+// relist lists the objects and watches them from the version of the list until it succeeds
+// or ctx is done, the listed objects are delivered as Create events.
+func (s *source) relist(ctx context.Context, eventHandler EventHandler, rateLimiter RateLimiter, predicates []Predicate) (rest.Channel, bool) {
+	backoff := relistMinBackoff
+	for {
+		objs, resourceVersion, err := s.list(ctx)
+		if err == nil {
+			for _, obj := range objs {
+				s.create(ctx, obj, eventHandler, rateLimiter, predicates)
+			}
+			var channel rest.Channel
+			if channel, err = s.watch(ctx, resourceVersion); err == nil {
+				return channel, true
+			}
+			logrus.Errorf("watch the objects from %q failed: %s", resourceVersion, err)
+		} else {
+			logrus.Errorf("relist the objects failed: %s", err)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, false
+		}
+		if backoff *= 2; backoff > relistMaxBackoff {
+			backoff = relistMaxBackoff
+		}
+	}
+}
+
+func (s *source) create(ctx context.Context, obj apis.Object, eventHandler EventHandler, rateLimiter RateLimiter, predicates []Predicate) {
+	createEvent := CreateEvent{Object: obj}
+	for _, predicate := range predicates {
+		if !predicate.Create(createEvent) {
+			return
+		}
+	}
+	eventHandler.Create(ctx, createEvent, rateLimiter)
+}
+
 func (s *source) Start(ctx context.Context, eventHandler EventHandler, rateLimiter RateLimiter, predicates ...Predicate) error {
-	eventCh, err := s.channel.ResultChan()
-	if err != nil {
-		return err
+	if s.list == nil {
+		eventCh, err := s.channel.ResultChan()
+		if err != nil {
+			return err
+		}
+		go s.consume(ctx, eventCh, eventHandler, rateLimiter, predicates)
+		return nil
 	}
 	go func() {
-	MAIN_LOOP:
 		for {
-			select {
-			case evt := <-eventCh:
-				if evt.Obj.GetKey() == "" {
-					continue
-				}
-				switch evt.Type {
-				case watch.EventTypeCreated:
-					createEvent := CreateEvent{Object: evt.Obj}
-					for _, predicate := range predicates {
-						if !predicate.Create(createEvent) {
-							continue MAIN_LOOP
-						}
+			channel, ok := s.relist(ctx, eventHandler, rateLimiter, predicates)
+			if !ok {
+				return
+			}
+			eventCh, err := channel.ResultChan()
+			if err != nil {
+				logrus.Errorf("watch the objects failed: %s", err)
+			} else if !s.consume(ctx, eventCh, eventHandler, rateLimiter, predicates) {
+				channel.Stop()
+				return
+			}
+			channel.Stop()
+		}
+	}()
+	return nil
+}
+
+// consume handles the events until the watch ends, it returns false if ctx is done. The watch
+// ends when the channel is closed or it reports the 410 Expired error.
+func (s *source) consume(ctx context.Context, eventCh <-chan rest.Event, eventHandler EventHandler, rateLimiter RateLimiter, predicates []Predicate) bool {
+MAIN_LOOP:
+	for {
+		select {
+		case evt, ok := <-eventCh:
+			if !ok {
+				// the watch is terminated
+				return true
+			}
+			if evt.Type == watch.EventTypeError {
+				if evt.Err != nil && evt.Err.Code == http.StatusGone {
+					if s.list == nil {
+						logrus.Warnf("the watch is expired, the events in between are lost: %s", evt.Err.Message)
+						continue
 					}
-					eventHandler.Create(ctx, createEvent, rateLimiter)
-				case watch.EventTypeUpdated:
-					updateEvent := UpdateEvent{ObjectNew: evt.Obj}
-					for _, predicate := range predicates {
-						if !predicate.Update(updateEvent) {
-							continue MAIN_LOOP
-						}
+					return true
+				}
+				if evt.Err != nil {
+					logrus.Warnf("the watch reports an error: %s", evt.Err.Message)
+				}
+				continue
+			}
+			if evt.Obj == nil || evt.Obj.GetKey() == "" {
+				continue
+			}
+			switch evt.Type {
+			case watch.EventTypeCreated:
+				s.create(ctx, evt.Obj, eventHandler, rateLimiter, predicates)
+			case watch.EventTypeUpdated:
+				updateEvent := UpdateEvent{ObjectOld: evt.OldObj, ObjectNew: evt.Obj}
+				for _, predicate := range predicates {
+					if !predicate.Update(updateEvent) {
+						continue MAIN_LOOP
 					}
-					eventHandler.Update(ctx, updateEvent, rateLimiter)
-				case watch.EventTypeDeleted:
-					deleteEvent := DeleteEvent{Object: evt.Obj}
-					for _, predicate := range predicates {
-						if !predicate.Delete(deleteEvent) {
-							continue MAIN_LOOP
-						}
+				}
+				eventHandler.Update(ctx, updateEvent, rateLimiter)
+			case watch.EventTypeDeleted:
+				deleteEvent := DeleteEvent{Object: evt.Obj}
+				for _, predicate := range predicates {
+					if !predicate.Delete(deleteEvent) {
+						continue MAIN_LOOP
 					}
-					eventHandler.Delete(ctx, deleteEvent, rateLimiter)
 				}
-			case <-ctx.Done():
-				return
+				eventHandler.Delete(ctx, deleteEvent, rateLimiter)
 			}
+		case <-ctx.Done():
+			return false
 		}
-	}()
-
-	return nil
+	}
 }
 
 type TimerSource struct {
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/reconcile"
+	"github.com/sunyakun/gearbox/pkg/rest"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+type fakeChannel struct {
+	ch chan rest.Event
+}
+
+func (c *fakeChannel) Stop() {}
+
+func (c *fakeChannel) ResultChan() (<-chan rest.Event, error) {
+	return c.ch, nil
+}
+
+func TestSourceRelist(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	expired := errors.NewExpired("expired").Status()
+	// the first watch expires, the second one is closed by the server, the third one stays
+	var channels []*fakeChannel
+	for _, events := range [][]rest.Event{
+		{{Type: watch.EventTypeCreated, Obj: &apis.ObjectMeta{Key: "foo"}}, {Type: watch.EventTypeError, Err: &expired}},
+		{{Type: watch.EventTypeDeleted, Obj: &apis.ObjectMeta{Key: "baz"}}},
+		{{Type: watch.EventTypeDeleted, Obj: &apis.ObjectMeta{Key: "qux"}}},
+	} {
+		ch := &fakeChannel{ch: make(chan rest.Event, len(events))}
+		for _, evt := range events {
+			ch.ch <- evt
+		}
+		if len(channels) == 1 {
+			close(ch.ch)
+		}
+		channels = append(channels, ch)
+	}
+
+	listed := 0
+	var versions []string
+	src := NewRelistSource(func(ctx context.Context) ([]apis.Object, string, error) {
+		if listed++; listed == 2 {
+			return nil, "", errors.NewServiceUnavailable("unavailable")
+		}
+		return []apis.Object{&apis.ObjectMeta{Key: fmt.Sprintf("bar%d", listed)}}, fmt.Sprintf("v%d", listed), nil
+	}, func(ctx context.Context, resourceVersion string) (rest.Channel, error) {
+		versions = append(versions, resourceVersion)
+		return channels[len(versions)-1], nil
+	})
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	assert.Nil(t, src.Start(ctx, EnqueueHandler, queue))
+
+	// the relist is retried, the listed objects are enqueued before the events of the watch
+	var keys []string
+	for len(keys) == 0 || keys[len(keys)-1] != "qux" {
+		assert.Eventually(t, func() bool { return queue.Len() != 0 }, 5*time.Second, time.Millisecond)
+		item, _ := queue.Get()
+		keys = append(keys, item.(reconcile.Request).Key)
+		queue.Done(item)
+	}
+	assert.Equal(t, []string{"bar1", "foo", "bar3", "baz", "bar4", "qux"}, keys)
+	assert.Equal(t, 4, listed)
+	// every watch resumes from the version of its list
+	assert.Equal(t, []string{"v1", "v3", "v4"}, versions)
+}
@@ -0,0 +1,127 @@
+package discovery
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/emicklei/go-restful/v3"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/rest"
+)
+
+// Path is where the discovery document is served
+const Path = "/apis"
+
+const (
+	VerbGet    = "get"
+	VerbList   = "list"
+	VerbCreate = "create"
+	VerbUpdate = "update"
+	VerbDelete = "delete"
+	VerbWatch  = "watch"
+	VerbPatch  = "patch"
+)
+
+// APIResourceList is the discovery document, it lists all the resources served by the server
+type APIResourceList struct {
+	Kind      string        `json:"kind"`
+	Resources []APIResource `json:"resources"`
+}
+
+// APIResource describes a resource, <Kind> is empty if the type isn't registered in the scheme.
+// <StorageVersion> is the version persisted in the storage, the other versions are converted from it.
+// The gearbox resources aren't namespaced, <Namespaced> is kept for the generic tooling.
+type APIResource struct {
+	Name           string   `json:"name"`
+	Kind           string   `json:"kind"`
+	Group          string   `json:"group,omitempty"`
+	Version        string   `json:"version"`
+	StorageVersion string   `json:"storageVersion,omitempty"`
+	Verbs          []string `json:"verbs"`
+	Namespaced     bool     `json:"namespaced"`
+	ShortNames     []string `json:"shortNames,omitempty"`
+}
+
+// Install serves the discovery document of the container at Path. The document is
+// built on every request so that it covers the resources installed later.
+func Install(container *restful.Container, scheme *apis.Scheme) {
+	ws := new(restful.WebService)
+	ws.Path(Path).Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("").To(func(req *restful.Request, resp *restful.Response) {
+		_ = resp.WriteHeaderAndJson(http.StatusOK, Build(container, scheme), restful.MIME_JSON)
+	}).Doc("the discovery document").Writes(APIResourceList{}))
+	container.Add(ws)
+}
+
+// Build builds the discovery document from the routes of the rest.Resource installed into the container
+func Build(container *restful.Container, scheme *apis.Scheme) *APIResourceList {
+	list := &APIResourceList{Kind: "APIResourceList", Resources: []APIResource{}}
+	index := map[string]int{}
+	for _, ws := range container.RegisteredWebServices() {
+		for _, route := range ws.Routes() {
+			meta, ok := route.Metadata[rest.ResourceMetadataKey].(rest.ResourceMetadata)
+			if !ok {
+				continue
+			}
+			id := apis.GroupVersion{Group: meta.Group, Version: meta.Version}.String() + "/" + meta.Name
+			i, ok := index[id]
+			if !ok {
+				i = len(list.Resources)
+				index[id] = i
+				resource := APIResource{
+					Name:           meta.Name,
+					Group:          meta.Group,
+					Version:        meta.Version,
+					StorageVersion: meta.StorageVersion,
+					Verbs:          []string{},
+					ShortNames:     meta.ShortNames,
+				}
+				if scheme != nil && meta.Object != nil {
+					resource.Kind, _ = scheme.ObjectKind(meta.Object)
+				}
+				list.Resources = append(list.Resources, resource)
+			}
+			list.Resources[i].Verbs = append(list.Resources[i].Verbs, verbs(route)...)
+		}
+	}
+	for i := range list.Resources {
+		sort.Strings(list.Resources[i].Verbs)
+	}
+	sort.Slice(list.Resources, func(i, j int) bool {
+		a, b := list.Resources[i], list.Resources[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Version < b.Version
+	})
+	return list
+}
+
+// verbs returns the verbs served by the route, the routes of a single object have the path parameter
+func verbs(route restful.Route) []string {
+	single := strings.Contains(route.Path, "{")
+	switch route.Method {
+	case http.MethodGet:
+		if single {
+			return []string{VerbGet}
+		}
+		if _, ok := route.Metadata[rest.WatchEventMetadataKey]; ok {
+			return []string{VerbList, VerbWatch}
+		}
+		return []string{VerbList}
+	case http.MethodPost:
+		return []string{VerbCreate}
+	case http.MethodPut:
+		return []string{VerbUpdate}
+	case http.MethodPatch:
+		return []string{VerbPatch}
+	case http.MethodDelete:
+		return []string{VerbDelete}
+	}
+	return nil
+}
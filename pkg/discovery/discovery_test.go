@@ -0,0 +1,47 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/rest"
+)
+
+type Foo struct {
+	apis.ObjectMeta `json:",inline"`
+}
+
+func TestDiscovery(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddKnownTypes(&Foo{}))
+	container := restful.NewContainer()
+	api := rest.NewRestAPI[Foo, *Foo, Foo]("foos", nil, scheme, nil, logr.Discard(), nil)
+	api.SetShortNames("fo")
+	api.Install(container)
+	Install(container, scheme)
+
+	req := httptest.NewRequest(http.MethodGet, Path, nil)
+	w := httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list APIResourceList
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, APIResourceList{
+		Kind: "APIResourceList",
+		Resources: []APIResource{{
+			Name:           "foos",
+			Kind:           "Foo",
+			Version:        "v1",
+			StorageVersion: "v1",
+			Verbs:          []string{VerbCreate, VerbDelete, VerbGet, VerbList, VerbUpdate, VerbWatch},
+			ShortNames:     []string{"fo"},
+		}},
+	}, list)
+}
@@ -1,8 +1,10 @@
 package errors
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/sunyakun/gearbox/pkg/apis"
 )
@@ -23,8 +25,17 @@ func (s StatusError) Error() string {
 	return s.ErrStatus.Message
 }
 
+// FromError returns the APIStatus of the error or the error it wraps
+func FromError(err error) (APIStatus, bool) {
+	var status APIStatus
+	if err == nil || !errors.As(err, &status) {
+		return nil, false
+	}
+	return status, true
+}
+
 func getErrorCodeAndReason(err error) (int, string) {
-	if e, ok := err.(StatusError); ok {
+	if e, ok := FromError(err); ok {
 		return e.Status().Code, e.Status().Reason
 	}
 	return 0, ""
@@ -54,6 +65,19 @@ func NewNotFound(kind, key string) StatusError {
 	}
 }
 
+// NewUnauthorized means the request isn't authenticated
+func NewUnauthorized(message string) StatusError {
+	return StatusError{
+		ErrStatus: apis.Status{
+			ObjectMeta: apis.ObjectMeta{Kind: "Status"},
+			Code:       http.StatusUnauthorized,
+			Status:     apis.StatusFailure,
+			Reason:     http.StatusText(http.StatusUnauthorized),
+			Message:    message,
+		},
+	}
+}
+
 func NewForbidden(operate, kind, key, message string) StatusError {
 	return StatusError{
 		ErrStatus: apis.Status{
@@ -66,6 +90,30 @@ func NewForbidden(operate, kind, key, message string) StatusError {
 	}
 }
 
+// ReasonInvalid is the reason of the status of NewInvalid
+const ReasonInvalid = "Invalid"
+
+// NewInvalid means the object of the kind and key has the invalid fields, every field error
+// is a cause of the status details
+func NewInvalid(kind, key string, errs FieldErrorList) StatusError {
+	causes := make([]apis.StatusCause, 0, len(errs))
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		causes = append(causes, apis.StatusCause{Field: err.Field, Reason: string(err.Type), Message: err.Error()})
+		messages = append(messages, err.Error())
+	}
+	return StatusError{
+		ErrStatus: apis.Status{
+			ObjectMeta: apis.ObjectMeta{Kind: "Status"},
+			Code:       http.StatusUnprocessableEntity,
+			Status:     apis.StatusFailure,
+			Reason:     ReasonInvalid,
+			Message:    fmt.Sprintf("%s %q is invalid: %s", kind, key, strings.Join(messages, ", ")),
+			Details:    &apis.StatusDetails{Kind: kind, Key: key, Causes: causes},
+		},
+	}
+}
+
 func NewConflict(err error) StatusError {
 	return StatusError{
 		ErrStatus: apis.Status{
@@ -90,6 +138,32 @@ func NewInternalError(err error) StatusError {
 	}
 }
 
+// NewExpired means the requested resource version or the watch is too old and can't
+// be served anymore, the client should relist.
+func NewExpired(message string) StatusError {
+	return StatusError{
+		ErrStatus: apis.Status{
+			ObjectMeta: apis.ObjectMeta{Kind: "Status"},
+			Code:       http.StatusGone,
+			Status:     apis.StatusFailure,
+			Reason:     "Expired",
+			Message:    message,
+		},
+	}
+}
+
+func NewServiceUnavailable(message string) StatusError {
+	return StatusError{
+		ErrStatus: apis.Status{
+			ObjectMeta: apis.ObjectMeta{Kind: "Status"},
+			Code:       http.StatusServiceUnavailable,
+			Status:     apis.StatusFailure,
+			Reason:     http.StatusText(http.StatusServiceUnavailable),
+			Message:    message,
+		},
+	}
+}
+
 func IsBadRequestError(err error) bool {
 	if code, _ := getErrorCodeAndReason(err); code == http.StatusBadRequest {
 		return true
@@ -104,6 +178,13 @@ func IsNotFoundError(err error) bool {
 	return false
 }
 
+func IsUnauthorizedError(err error) bool {
+	if code, _ := getErrorCodeAndReason(err); code == http.StatusUnauthorized {
+		return true
+	}
+	return false
+}
+
 func IsForbiddenError(err error) bool {
 	if code, _ := getErrorCodeAndReason(err); code == http.StatusForbidden {
 		return true
@@ -111,6 +192,13 @@ func IsForbiddenError(err error) bool {
 	return false
 }
 
+func IsInvalidError(err error) bool {
+	if code, _ := getErrorCodeAndReason(err); code == http.StatusUnprocessableEntity {
+		return true
+	}
+	return false
+}
+
 func IsConflictError(err error) bool {
 	if code, _ := getErrorCodeAndReason(err); code == http.StatusConflict {
 		return true
@@ -124,3 +212,17 @@ func IsInternalError(err error) bool {
 	}
 	return false
 }
+
+func IsExpiredError(err error) bool {
+	if code, _ := getErrorCodeAndReason(err); code == http.StatusGone {
+		return true
+	}
+	return false
+}
+
+func IsServiceUnavailableError(err error) bool {
+	if code, _ := getErrorCodeAndReason(err); code == http.StatusServiceUnavailable {
+		return true
+	}
+	return false
+}
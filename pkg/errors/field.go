@@ -0,0 +1,158 @@
+package errors
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FieldPath is the path of a field from the root of the object, e.g. "spec.containers[0].image"
+type FieldPath struct {
+	name   string
+	index  string
+	parent *FieldPath
+}
+
+// NewFieldPath creates the path of the root field and its children
+func NewFieldPath(name string, more ...string) *FieldPath {
+	path := &FieldPath{name: name}
+	for _, name := range more {
+		path = &FieldPath{name: name, parent: path}
+	}
+	return path
+}
+
+// Child returns the path of the child fields
+func (p *FieldPath) Child(name string, more ...string) *FieldPath {
+	path := NewFieldPath(name, more...)
+	root := path
+	for root.parent != nil {
+		root = root.parent
+	}
+	root.parent = p
+	return path
+}
+
+// Index returns the path of the element of the array
+func (p *FieldPath) Index(index int) *FieldPath {
+	return &FieldPath{index: strconv.Itoa(index), parent: p}
+}
+
+// Key returns the path of the value of the map
+func (p *FieldPath) Key(key string) *FieldPath {
+	return &FieldPath{index: key, parent: p}
+}
+
+func (p *FieldPath) String() string {
+	var elems []*FieldPath
+	for path := p; path != nil; path = path.parent {
+		elems = append(elems, path)
+	}
+	var b strings.Builder
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i].name == "" {
+			fmt.Fprintf(&b, "[%s]", elems[i].index)
+			continue
+		}
+		if b.Len() != 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(elems[i].name)
+	}
+	return b.String()
+}
+
+// FieldErrorType is the machine-readable reason of a FieldError
+type FieldErrorType string
+
+const (
+	FieldValueRequired     FieldErrorType = "FieldValueRequired"
+	FieldValueInvalid      FieldErrorType = "FieldValueInvalid"
+	FieldValueNotSupported FieldErrorType = "FieldValueNotSupported"
+	FieldValueDuplicate    FieldErrorType = "FieldValueDuplicate"
+	FieldValueForbidden    FieldErrorType = "FieldValueForbidden"
+	FieldValueTooLong      FieldErrorType = "FieldValueTooLong"
+)
+
+// FieldError is the validation error of a field, <BadValue> is omitted from the message if it's nil
+type FieldError struct {
+	Type     FieldErrorType
+	Field    string
+	BadValue any
+	Detail   string
+}
+
+func (e *FieldError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", e.Field, e.description())
+	if e.BadValue != nil {
+		fmt.Fprintf(&b, ": %#v", e.BadValue)
+	}
+	if e.Detail != "" {
+		fmt.Fprintf(&b, ": %s", e.Detail)
+	}
+	return b.String()
+}
+
+func (e *FieldError) description() string {
+	switch e.Type {
+	case FieldValueRequired:
+		return "Required value"
+	case FieldValueNotSupported:
+		return "Unsupported value"
+	case FieldValueDuplicate:
+		return "Duplicate value"
+	case FieldValueForbidden:
+		return "Forbidden"
+	case FieldValueTooLong:
+		return "Too long"
+	default:
+		return "Invalid value"
+	}
+}
+
+// FieldRequired means the required field is empty
+func FieldRequired(path *FieldPath, detail string) *FieldError {
+	return &FieldError{Type: FieldValueRequired, Field: path.String(), Detail: detail}
+}
+
+// FieldInvalid means the value of the field is invalid
+func FieldInvalid(path *FieldPath, value any, detail string) *FieldError {
+	return &FieldError{Type: FieldValueInvalid, Field: path.String(), BadValue: value, Detail: detail}
+}
+
+// FieldNotSupported means the value isn't one of the valid values
+func FieldNotSupported(path *FieldPath, value any, validValues []string) *FieldError {
+	var detail string
+	if len(validValues) != 0 {
+		detail = fmt.Sprintf("supported values: %q", validValues)
+	}
+	return &FieldError{Type: FieldValueNotSupported, Field: path.String(), BadValue: value, Detail: detail}
+}
+
+// FieldDuplicate means the value of the field must be unique
+func FieldDuplicate(path *FieldPath, value any) *FieldError {
+	return &FieldError{Type: FieldValueDuplicate, Field: path.String(), BadValue: value}
+}
+
+// FieldForbidden means the field can't be set, e.g. it's immutable
+func FieldForbidden(path *FieldPath, detail string) *FieldError {
+	return &FieldError{Type: FieldValueForbidden, Field: path.String(), Detail: detail}
+}
+
+// FieldTooLong means the value of the field is longer than <max>
+func FieldTooLong(path *FieldPath, value any, max int) *FieldError {
+	return &FieldError{Type: FieldValueTooLong, Field: path.String(), BadValue: value, Detail: fmt.Sprintf("must have at most %d bytes", max)}
+}
+
+// FieldErrorList is the errors of the fields of an object, the validation functions append the
+// errors to it and return NewInvalid if it's not empty.
+type FieldErrorList []*FieldError
+
+// ToStatusError returns the NewInvalid error of the list, nil if the list is empty
+func (list FieldErrorList) ToStatusError(kind, key string) error {
+	if len(list) == 0 {
+		return nil
+	}
+	return NewInvalid(kind, key, list)
+}
@@ -0,0 +1,39 @@
+package errors
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldPath(t *testing.T) {
+	spec := NewFieldPath("spec")
+	assert.Equal(t, "spec", spec.String())
+	assert.Equal(t, "spec.containers[0].image", spec.Child("containers").Index(0).Child("image").String())
+	assert.Equal(t, "metadata.labels[app]", NewFieldPath("metadata", "labels").Key("app").String())
+	assert.Equal(t, "spec.template.spec", spec.Child("template", "spec").String())
+}
+
+func TestNewInvalid(t *testing.T) {
+	containers := NewFieldPath("spec", "containers")
+	errs := FieldErrorList{
+		FieldRequired(containers.Index(0).Child("image"), ""),
+		FieldNotSupported(NewFieldPath("spec", "restartPolicy"), "Sometimes", []string{"Always", "Never"}),
+	}
+	err := errs.ToStatusError("deployment", "web")
+	assert.True(t, IsInvalidError(err))
+	// the wrapped errors are matched too
+	assert.True(t, IsInvalidError(fmt.Errorf("create failed: %w", err)))
+
+	status := err.(StatusError).Status()
+	assert.Equal(t, http.StatusUnprocessableEntity, status.Code)
+	assert.Equal(t, `deployment "web" is invalid: spec.containers[0].image: Required value, `+
+		`spec.restartPolicy: Unsupported value: "Sometimes": supported values: ["Always" "Never"]`, status.Message)
+	assert.Len(t, status.Details.Causes, 2)
+	assert.Equal(t, "spec.restartPolicy", status.Details.Causes[1].Field)
+	assert.Equal(t, string(FieldValueNotSupported), status.Details.Causes[1].Reason)
+
+	assert.Nil(t, FieldErrorList{}.ToStatusError("deployment", "web"))
+}
@@ -0,0 +1,394 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/rest"
+)
+
+// Path is where the OpenAPI v3 document is served
+const Path = "/openapi/v3"
+
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem is the operations of a path, keyed by the lower-case http method
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string              `json:"operationId,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Tags        []string            `json:"tags,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// Schema is the subset of the JSON schema used by the generated documents, the empty
+// schema accepts any value.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Install serves the OpenAPI v3 document of the container at Path. The document is
+// generated on every request so that it covers the routes added after the installation.
+func Install(container *restful.Container, scheme *apis.Scheme, info Info) {
+	ws := new(restful.WebService)
+	ws.Path(Path).Produces(restful.MIME_JSON)
+	ws.Route(ws.GET("").To(func(req *restful.Request, resp *restful.Response) {
+		doc := Build(container, scheme, info)
+		_ = resp.WriteHeaderAndJson(http.StatusOK, doc, restful.MIME_JSON)
+	}).Doc("the OpenAPI v3 document"))
+	container.Add(ws)
+}
+
+// Build generates the OpenAPI v3 document from the routes of the container, the schemas
+// of the known types of the scheme and the types of the routes are in the components.
+func Build(container *restful.Container, scheme *apis.Scheme, info Info) *Document {
+	b := &builder{schemas: map[string]*Schema{}}
+	if scheme != nil {
+		var kinds []string
+		types := scheme.AllKnownTypes()
+		for kind := range types {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			b.schemaOf(types[kind])
+		}
+	}
+
+	doc := &Document{OpenAPI: Version, Info: info, Paths: map[string]PathItem{}}
+	for _, ws := range container.RegisteredWebServices() {
+		if ws.RootPath() == Path {
+			continue
+		}
+		tag := strings.Trim(ws.RootPath(), "/")
+		for _, route := range ws.Routes() {
+			path := strings.TrimSuffix(route.Path, "/")
+			if path == "" {
+				path = "/"
+			}
+			item, ok := doc.Paths[path]
+			if !ok {
+				item = PathItem{}
+				doc.Paths[path] = item
+			}
+			op := b.operation(route)
+			if tag != "" {
+				op.Tags = []string{tag}
+			}
+			item[strings.ToLower(route.Method)] = op
+		}
+	}
+	doc.Components.Schemas = b.schemas
+	return doc
+}
+
+type builder struct {
+	schemas map[string]*Schema
+}
+
+func (b *builder) operation(route restful.Route) *Operation {
+	op := &Operation{
+		OperationID: route.Operation,
+		Summary:     route.Doc,
+		Description: route.Notes,
+		Deprecated:  route.Deprecated,
+		Responses:   map[string]Response{},
+	}
+	for _, param := range route.ParameterDocs {
+		data := param.Data()
+		switch data.Kind {
+		case restful.PathParameterKind, restful.QueryParameterKind, restful.HeaderParameterKind:
+			in := map[int]string{
+				restful.PathParameterKind:   "path",
+				restful.QueryParameterKind:  "query",
+				restful.HeaderParameterKind: "header",
+			}[data.Kind]
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:        data.Name,
+				In:          in,
+				Description: data.Description,
+				Required:    data.Required || data.Kind == restful.PathParameterKind,
+				Schema:      parameterSchema(data),
+			})
+		case restful.BodyParameterKind:
+			op.RequestBody = &RequestBody{
+				Description: data.Description,
+				Required:    true,
+				Content:     b.content(route.Consumes, route.ReadSample, nil),
+			}
+		}
+	}
+
+	watchEvent := route.Metadata[rest.WatchEventMetadataKey]
+	codes := make([]int, 0, len(route.ResponseErrors))
+	for code := range route.ResponseErrors {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		respErr := route.ResponseErrors[code]
+		op.Responses[strconv.Itoa(code)] = Response{
+			Description: respErr.Message,
+			Content:     b.content(route.Produces, respErr.Model, watchEvent),
+		}
+	}
+	if route.DefaultResponse != nil {
+		op.Responses["default"] = Response{
+			Description: route.DefaultResponse.Message,
+			Content:     b.content(route.Produces, route.DefaultResponse.Model, nil),
+		}
+	}
+	if len(op.Responses) == 0 {
+		op.Responses["200"] = Response{
+			Description: http.StatusText(http.StatusOK),
+			Content:     b.content(route.Produces, route.WriteSample, nil),
+		}
+	}
+	return op
+}
+
+// content returns the media types of the sample, the watch streams are described by the
+// <watchEvent> sample.
+func (b *builder) content(contentTypes []string, sample, watchEvent any) map[string]MediaType {
+	if sample == nil {
+		return nil
+	}
+	content := map[string]MediaType{}
+	for _, ct := range contentTypes {
+		switch ct {
+		case rest.MIMENDJSON, rest.MIMEEventStream:
+			if watchEvent != nil {
+				content[ct] = MediaType{Schema: b.schemaOf(reflect.TypeOf(watchEvent))}
+			}
+		default:
+			content[ct] = MediaType{Schema: b.schemaOf(reflect.TypeOf(sample))}
+		}
+	}
+	return content
+}
+
+func parameterSchema(data restful.ParameterData) *Schema {
+	schema := &Schema{Format: data.DataFormat}
+	switch data.DataType {
+	case "int", "integer", "int32", "int64":
+		schema.Type = "integer"
+	case "bool", "boolean":
+		schema.Type = "boolean"
+	case "number", "float", "double":
+		schema.Type = "number"
+	default:
+		schema.Type = "string"
+	}
+	return schema
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	rawType      = reflect.TypeOf(apis.RawExtension{})
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaOf returns the schema of the type, the named structs are added to the components
+// and referenced.
+func (b *builder) schemaOf(rt reflect.Type) *Schema {
+	for rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	switch rt {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64"}
+	case rawType, rawJSONType:
+		return &Schema{}
+	}
+
+	switch rt.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if rt.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schemaOf(rt.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schemaOf(rt.Elem())}
+	case reflect.Struct:
+		name := schemaName(rt)
+		if name == "" {
+			return b.structSchema(rt)
+		}
+		if _, ok := b.schemas[name]; !ok {
+			// the placeholder breaks the recursion of the self-referencing types
+			b.schemas[name] = &Schema{}
+			*b.schemas[name] = *b.structSchema(rt)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+func (b *builder) structSchema(rt reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	b.addProperties(schema, rt)
+	return schema
+}
+
+// addProperties adds the fields as the properties by the json tags, the embedded structs
+// are inlined like encoding/json.
+func (b *builder) addProperties(schema *Schema, rt reflect.Type) {
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := field.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			b.addProperties(schema, ft)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.Contains(opts, "string") {
+			schema.Properties[name] = &Schema{Type: "string"}
+			continue
+		}
+		schema.Properties[name] = b.schemaOf(field.Type)
+	}
+}
+
+// schemaName returns the component name of the struct qualified by its package, so that the
+// types of the same name in the different packages, e.g. v1.Foo and v2.Foo, don't collide.
+// It's "com.example.app.v1.Foo" for the Foo of example.com/app/v1, and the generic types are
+// named by their type arguments, e.g. "com.example.app.v1.FooList" for apis.ObjectList[*v1.Foo]
+// and "com.example.app.v1.FooWatchEvent" for rest.WatchEvent[*v1.Foo].
+func schemaName(rt reflect.Type) string {
+	name := rt.Name()
+	base, args, ok := strings.Cut(name, "[")
+	if !ok {
+		if name == "" {
+			return ""
+		}
+		return qualifiedName(rt.PkgPath(), name)
+	}
+	var (
+		pkgPath  string
+		argNames []string
+	)
+	for _, arg := range strings.Split(strings.TrimSuffix(args, "]"), ",") {
+		arg = strings.TrimLeft(strings.TrimSpace(arg), "*[]")
+		if idx := strings.LastIndex(arg, "."); idx >= 0 {
+			if pkgPath == "" {
+				pkgPath = arg[:idx]
+			}
+			arg = arg[idx+1:]
+		}
+		argNames = append(argNames, arg)
+	}
+	if base == "ObjectList" {
+		base = "List"
+	}
+	return qualifiedName(pkgPath, strings.Join(argNames, "")+base)
+}
+
+// qualifiedName prefixes the name by the package path in the reverse domain notation, the
+// characters not allowed in the component names are replaced by "_".
+func qualifiedName(pkgPath, name string) string {
+	if pkgPath == "" {
+		return name
+	}
+	parts := strings.Split(pkgPath, "/")
+	if domain := strings.Split(parts[0], "."); len(domain) > 1 {
+		for i, j := 0, len(domain)-1; i < j; i, j = i+1, j-1 {
+			domain[i], domain[j] = domain[j], domain[i]
+		}
+		parts[0] = strings.Join(domain, ".")
+	}
+	qualified := strings.Join(append(parts, name), ".")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, qualified)
+}
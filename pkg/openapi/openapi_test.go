@@ -0,0 +1,118 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	v1 "github.com/sunyakun/gearbox/pkg/openapi/testdata/v1"
+	v2 "github.com/sunyakun/gearbox/pkg/openapi/testdata/v2"
+	"github.com/sunyakun/gearbox/pkg/rest"
+)
+
+type Foo struct {
+	apis.ObjectMeta `json:",inline"`
+	Image           string            `json:"image"`
+	Replicas        *int32            `json:"replicas,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Extra           apis.RawExtension `json:"extra"`
+	Children        []*Foo            `json:"children,omitempty"`
+	internal        string
+}
+
+const (
+	fooName    = "com.github.sunyakun.gearbox.pkg.openapi.Foo"
+	statusName = "com.github.sunyakun.gearbox.pkg.apis.Status"
+)
+
+func TestBuild(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddKnownTypes(&Foo{}))
+	container := restful.NewContainer()
+	rest.NewRestAPI[Foo, *Foo, Foo]("foos", nil, scheme, nil, logr.Discard(), nil).Install(container)
+	Install(container, scheme, Info{Title: "gearbox", Version: "v1"})
+
+	req := httptest.NewRequest(http.MethodGet, Path, nil)
+	w := httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var doc Document
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, Version, doc.OpenAPI)
+	assert.NotContains(t, doc.Paths, Path)
+
+	foo := doc.Components.Schemas[fooName]
+	assert.Equal(t, &Schema{Type: "string"}, foo.Properties["image"])
+	assert.Equal(t, &Schema{Type: "string"}, foo.Properties["key"])
+	assert.Equal(t, &Schema{Type: "string", Format: "date-time"}, foo.Properties["createTime"])
+	assert.Equal(t, &Schema{Type: "integer", Format: "int32"}, foo.Properties["replicas"])
+	assert.Equal(t, &Schema{Type: "object", AdditionalProperties: &Schema{Type: "string"}}, foo.Properties["labels"])
+	assert.Equal(t, &Schema{}, foo.Properties["extra"])
+	assert.Equal(t, &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/" + fooName}}, foo.Properties["children"])
+	assert.NotContains(t, foo.Properties, "internal")
+	assert.Contains(t, doc.Components.Schemas[statusName].Properties, "reason")
+	assert.Equal(t, &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/" + fooName}}, doc.Components.Schemas[fooName+"List"].Properties["items"])
+
+	list := doc.Paths["/foos"]["get"]
+	assert.Equal(t, "listFoos", list.OperationID)
+	assert.Equal(t, []string{"foos"}, list.Tags)
+	var selector *Parameter
+	for i := range list.Parameters {
+		if list.Parameters[i].Name == "selector" {
+			selector = &list.Parameters[i]
+		}
+	}
+	assert.Equal(t, &Parameter{Name: "selector", In: "query", Description: "selector expression", Schema: &Schema{Type: "string"}}, selector)
+	assert.Equal(t, "#/components/schemas/"+fooName+"List", list.Responses["200"].Content[apis.MIMEJSON].Schema.Ref)
+	assert.Equal(t, "#/components/schemas/"+fooName+"WatchEvent", list.Responses["200"].Content[rest.MIMENDJSON].Schema.Ref)
+	assert.Equal(t, "#/components/schemas/"+statusName, list.Responses["default"].Content[apis.MIMEJSON].Schema.Ref)
+
+	get := doc.Paths["/foos/{foos}"]["get"]
+	assert.Equal(t, Parameter{Name: "foos", In: "path", Description: "the resource foos", Required: true, Schema: &Schema{Type: "string"}}, get.Parameters[0])
+	create := doc.Paths["/foos"]["post"]
+	assert.Equal(t, "#/components/schemas/"+fooName, create.RequestBody.Content[apis.MIMEJSON].Schema.Ref)
+}
+
+func TestSchemaName(t *testing.T) {
+	assert.Equal(t, fooName, schemaName(reflect.TypeOf(Foo{})))
+	assert.Equal(t, fooName+"List", schemaName(reflect.TypeOf(apis.ObjectList[*Foo]{})))
+	assert.Equal(t, fooName+"WatchEvent", schemaName(reflect.TypeOf(rest.WatchEvent[*Foo]{})))
+	// the types of the same name in the different packages don't collide
+	assert.Equal(t, "com.github.sunyakun.gearbox.pkg.openapi.testdata.v1.Foo", schemaName(reflect.TypeOf(v1.Foo{})))
+	assert.Equal(t, "com.github.sunyakun.gearbox.pkg.openapi.testdata.v2.Foo", schemaName(reflect.TypeOf(v2.Foo{})))
+	assert.Equal(t, "com.github.sunyakun.gearbox.pkg.openapi.testdata.v2.FooList", schemaName(reflect.TypeOf(apis.ObjectList[*v2.Foo]{})))
+	assert.Equal(t, "time.Time", schemaName(reflect.TypeOf(time.Time{})))
+}
+
+func TestBuildVersioned(t *testing.T) {
+	scheme := apis.NewScheme()
+	gv1, gv2 := apis.GroupVersion{Group: "apps", Version: "v1"}, apis.GroupVersion{Group: "apps", Version: "v2"}
+	assert.Nil(t, scheme.AddVersionedTypes(gv1, &v1.Foo{}))
+	assert.Nil(t, scheme.AddVersionedTypes(gv2, &v2.Foo{}))
+	container := restful.NewContainer()
+	storage := rest.NewRestAPI[v1.Foo, *v1.Foo, v1.Foo]("foos", nil, scheme, nil, logr.Discard(), nil)
+	storage.Install(container)
+	versioned, err := rest.NewVersionedAPI[v2.Foo, *v2.Foo, v1.Foo, *v1.Foo](storage, scheme)
+	assert.Nil(t, err)
+	versioned.Install(container)
+
+	// the versions of the kind Foo have their own schemas
+	doc := Build(container, scheme, Info{Title: "gearbox", Version: "v1"})
+	v1Name := schemaName(reflect.TypeOf(v1.Foo{}))
+	v2Name := schemaName(reflect.TypeOf(v2.Foo{}))
+	assert.Contains(t, doc.Components.Schemas[v1Name].Properties, "image")
+	assert.NotContains(t, doc.Components.Schemas[v1Name].Properties, "images")
+	assert.Contains(t, doc.Components.Schemas[v2Name].Properties, "images")
+	assert.NotContains(t, doc.Components.Schemas[v2Name].Properties, "image")
+	assert.Equal(t, "#/components/schemas/"+v1Name, doc.Paths["/apps/v1/foos"]["post"].RequestBody.Content[apis.MIMEJSON].Schema.Ref)
+	assert.Equal(t, "#/components/schemas/"+v2Name, doc.Paths["/apps/v2/foos"]["post"].RequestBody.Content[apis.MIMEJSON].Schema.Ref)
+	assert.Equal(t, "#/components/schemas/"+v2Name+"List", doc.Paths["/apps/v2/foos"]["get"].Responses["200"].Content[apis.MIMEJSON].Schema.Ref)
+}
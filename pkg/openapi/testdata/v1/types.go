@@ -0,0 +1,8 @@
+package v1
+
+import "github.com/sunyakun/gearbox/pkg/apis"
+
+type Foo struct {
+	apis.ObjectMeta `json:",inline"`
+	Image           string `json:"image"`
+}
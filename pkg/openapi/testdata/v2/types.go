@@ -0,0 +1,9 @@
+package v2
+
+import "github.com/sunyakun/gearbox/pkg/apis"
+
+// Foo is the v2 of v1.Foo, the images are split
+type Foo struct {
+	apis.ObjectMeta `json:",inline"`
+	Images          []string `json:"images"`
+}
@@ -0,0 +1,421 @@
+package rest
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm/schema"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/util"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	nullTimeType = reflect.TypeOf(sql.NullTime{})
+)
+
+type convertFunc func(from, to reflect.Value) error
+
+type fieldHook struct {
+	apiType     reflect.Type
+	storageType reflect.Type
+	toStorage   convertFunc
+	fromStorage convertFunc
+}
+
+type autoConverterConfig struct {
+	mappings map[string]string
+	hooks    map[string]fieldHook
+	ignored  map[string]bool
+}
+
+type AutoConverterOption func(*autoConverterConfig)
+
+// WithFieldMapping maps the api field to the storage field. The api field is a dot separated path,
+// e.g. "Key" or "Spec.Replicas", the storage field is either the go field name or the gorm column.
+func WithFieldMapping(apiField, storageField string) AutoConverterOption {
+	return func(cfg *autoConverterConfig) {
+		cfg.mappings[apiField] = storageField
+	}
+}
+
+// WithIgnoredFields ignores the api fields like the `gearbox:"-"` tag, e.g. the fields of the
+// embedded structs of the other packages, the fields are dot separated paths like WithFieldMapping.
+func WithIgnoredFields(apiFields ...string) AutoConverterOption {
+	return func(cfg *autoConverterConfig) {
+		for _, apiField := range apiFields {
+			cfg.ignored[apiField] = true
+		}
+	}
+}
+
+// WithFieldHook overrides the conversion of the api field, A and S must be the exact types of
+// the api field and the storage field it mapped to.
+func WithFieldHook[A, S any](apiField string, toStorage func(A) (S, error), fromStorage func(S) (A, error)) AutoConverterOption {
+	return func(cfg *autoConverterConfig) {
+		cfg.hooks[apiField] = fieldHook{
+			apiType:     reflect.TypeOf((*A)(nil)).Elem(),
+			storageType: reflect.TypeOf((*S)(nil)).Elem(),
+			toStorage: func(from, to reflect.Value) error {
+				s, err := toStorage(from.Interface().(A))
+				if err != nil {
+					return err
+				}
+				to.Set(reflect.ValueOf(&s).Elem())
+				return nil
+			},
+			fromStorage: func(from, to reflect.Value) error {
+				a, err := fromStorage(from.Interface().(S))
+				if err != nil {
+					return err
+				}
+				to.Set(reflect.ValueOf(&a).Elem())
+				return nil
+			},
+		}
+	}
+}
+
+type fieldConverter struct {
+	path         string
+	apiIndex     []int
+	storageIndex []int
+	toStorage    convertFunc
+	fromStorage  convertFunc
+}
+
+var _ Converter[*apis.ObjectMeta, struct{}] = &AutoConverter[*apis.ObjectMeta, struct{}]{}
+
+// AutoConverter converts between the api type and the storage type by reflection.
+// The fields are mapped by name, by the gorm column given in the `gearbox:"column=<column>"` tag,
+// or by WithFieldMapping. The fields tagged with `gearbox:"-"` or given to WithIgnoredFields are
+// ignored, every other field must have a counterpart in the storage type.
+//
+// The embedded structs are flattened, a nested struct without counterpart is mapped field by field.
+// Besides the assignable and convertible types, it knowns how to convert T from and to *T,
+// time.Time from and to sql.NullTime, and struct, map or slice from and to a JSON-encoded
+// string or []byte column. The numbers out of the range of the target type are errors, the nil
+// *T is stored as the zero value and the zero value is read back as nil.
+type AutoConverter[PT apis.Object, ST any] struct {
+	fields []fieldConverter
+}
+
+// NewAutoConverter inspects PT and ST, the mismatches of the fields are reported here rather than
+// at conversion time.
+func NewAutoConverter[PT apis.Object, ST any](opts ...AutoConverterOption) (*AutoConverter[PT, ST], error) {
+	cfg := &autoConverterConfig{mappings: map[string]string{}, hooks: map[string]fieldHook{}, ignored: map[string]bool{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	apiRt := reflect.TypeOf((*PT)(nil)).Elem()
+	if apiRt.Kind() != reflect.Pointer || apiRt.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s must be a pointer to struct", apiRt)
+	}
+	apiRt = apiRt.Elem()
+	storageRt := reflect.TypeOf((*ST)(nil)).Elem()
+	if storageRt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s must be a struct", storageRt)
+	}
+
+	b := &autoConverterBuilder{cfg: cfg, storageFields: indexStorageFields(storageRt), storageRt: storageRt}
+	if err := b.walk(apiRt, nil, ""); err != nil {
+		return nil, err
+	}
+	for path := range cfg.mappings {
+		if !b.used[path] {
+			return nil, fmt.Errorf("no such field %s.%s", apiRt.Name(), path)
+		}
+	}
+	for path := range cfg.hooks {
+		if !b.used[path] {
+			return nil, fmt.Errorf("no such field %s.%s", apiRt.Name(), path)
+		}
+	}
+	for path := range cfg.ignored {
+		if !b.used[path] {
+			return nil, fmt.Errorf("no such field %s.%s", apiRt.Name(), path)
+		}
+	}
+	return &AutoConverter[PT, ST]{fields: b.fields}, nil
+}
+
+func (c *AutoConverter[PT, ST]) FromStorage(from *ST, to PT) error {
+	src, dst := reflect.ValueOf(from).Elem(), reflect.ValueOf(to).Elem()
+	for _, f := range c.fields {
+		if err := f.fromStorage(src.FieldByIndex(f.storageIndex), dst.FieldByIndex(f.apiIndex)); err != nil {
+			return fmt.Errorf("convert %s from storage failed: %w", f.path, err)
+		}
+	}
+	return nil
+}
+
+func (c *AutoConverter[PT, ST]) ToStorage(from PT, to *ST) error {
+	src, dst := reflect.ValueOf(from).Elem(), reflect.ValueOf(to).Elem()
+	for _, f := range c.fields {
+		if err := f.toStorage(src.FieldByIndex(f.apiIndex), dst.FieldByIndex(f.storageIndex)); err != nil {
+			return fmt.Errorf("convert %s to storage failed: %w", f.path, err)
+		}
+	}
+	return nil
+}
+
+// indexStorageFields index the visible fields by name and by gorm column, the fields promoted
+// through embedded pointers are ignored.
+func indexStorageFields(rt reflect.Type) map[string]reflect.StructField {
+	var namer schema.NamingStrategy
+	fields := map[string]reflect.StructField{}
+	for _, f := range reflect.VisibleFields(rt) {
+		if !f.IsExported() || f.Anonymous || throughPointer(rt, f.Index) {
+			continue
+		}
+		column := schema.ParseTagSetting(f.Tag.Get("gorm"), ";")["COLUMN"]
+		if column == "" {
+			column = namer.ColumnName("", f.Name)
+		}
+		fields[f.Name] = f
+		if _, ok := fields[column]; !ok {
+			fields[column] = f
+		}
+	}
+	return fields
+}
+
+func throughPointer(rt reflect.Type, index []int) bool {
+	for _, i := range index[:len(index)-1] {
+		f := rt.Field(i)
+		if f.Type.Kind() == reflect.Pointer {
+			return true
+		}
+		rt = f.Type
+	}
+	return false
+}
+
+type autoConverterBuilder struct {
+	cfg           *autoConverterConfig
+	storageRt     reflect.Type
+	storageFields map[string]reflect.StructField
+	fields        []fieldConverter
+	used          map[string]bool
+}
+
+func (b *autoConverterBuilder) walk(rt reflect.Type, index []int, prefix string) error {
+	if b.used == nil {
+		b.used = map[string]bool{}
+	}
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		fieldIndex := append(append([]int{}, index...), i)
+		path := prefix + f.Name
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := b.walk(f.Type, fieldIndex, prefix); err != nil {
+				return err
+			}
+			continue
+		}
+
+		column, skip := util.ParseGearboxTag(f.Tag.Get("gearbox"))
+		if b.cfg.ignored[path] {
+			b.used[path] = true
+			skip = true
+		}
+		if skip {
+			continue
+		}
+		if mapping, ok := b.cfg.mappings[path]; ok {
+			column = mapping
+			b.used[path] = true
+		}
+
+		var (
+			target reflect.StructField
+			found  bool
+		)
+		if column != "" {
+			if target, found = b.storageFields[column]; !found {
+				return fmt.Errorf("%s: the storage type %s has no field %q", path, b.storageRt.Name(), column)
+			}
+		} else {
+			target, found = b.storageFields[f.Name]
+		}
+
+		if !found {
+			if f.Type.Kind() == reflect.Struct && f.Type != timeType {
+				if err := b.walk(f.Type, fieldIndex, path+"."); err != nil {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("%s: the storage type %s has no field %q, ignore it by the `gearbox:\"-\"` tag or WithIgnoredFields",
+				path, b.storageRt.Name(), f.Name)
+		}
+
+		fc := fieldConverter{path: path, apiIndex: fieldIndex, storageIndex: target.Index}
+		if hook, ok := b.cfg.hooks[path]; ok {
+			b.used[path] = true
+			if hook.apiType != f.Type || hook.storageType != target.Type {
+				return fmt.Errorf("%s: the hook converts %s to %s, but the fields are %s and %s",
+					path, hook.apiType, hook.storageType, f.Type, target.Type)
+			}
+			fc.toStorage, fc.fromStorage = hook.toStorage, hook.fromStorage
+		} else {
+			fc.toStorage = convertValue(f.Type, target.Type)
+			fc.fromStorage = convertValue(target.Type, f.Type)
+			if fc.toStorage == nil || fc.fromStorage == nil {
+				return fmt.Errorf("%s: can't convert %s from and to %s.%s (%s)", path, f.Type, b.storageRt.Name(), target.Name, target.Type)
+			}
+		}
+		b.fields = append(b.fields, fc)
+	}
+	return nil
+}
+
+func isJSONColumn(rt reflect.Type) bool {
+	return rt.Kind() == reflect.String || (rt.Kind() == reflect.Slice && rt.Elem().Kind() == reflect.Uint8)
+}
+
+func isJSONValue(rt reflect.Type) bool {
+	if rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	switch rt.Kind() {
+	case reflect.Struct:
+		return rt != timeType
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return !isJSONColumn(rt)
+	}
+	return false
+}
+
+func isScalar(k reflect.Kind) bool {
+	return (k >= reflect.Bool && k <= reflect.Complex128) || k == reflect.String
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isNumber(k reflect.Kind) bool {
+	return isInt(k) || isUint(k) || (k >= reflect.Float32 && k <= reflect.Complex128)
+}
+
+// lossless returns true if the number <converted> from <src> has the same value
+func lossless(src, converted reflect.Value) bool {
+	from, to := src.Kind(), converted.Kind()
+	switch {
+	case isInt(from) && isUint(to) && src.Int() < 0:
+		return false
+	case isUint(from) && isInt(to) && converted.Int() < 0:
+		return false
+	case (from == reflect.Float32 || from == reflect.Float64) && math.IsNaN(src.Float()):
+		return true
+	}
+	return converted.Convert(src.Type()).Equal(src)
+}
+
+// convertValue returns the function converts the value of type from to the value of type to,
+// nil is returned if the types are incompatible.
+func convertValue(from, to reflect.Type) convertFunc {
+	switch {
+	case from == to || from.AssignableTo(to):
+		return func(src, dst reflect.Value) error {
+			dst.Set(src)
+			return nil
+		}
+	case isScalar(from.Kind()) && isScalar(to.Kind()) && from.ConvertibleTo(to) &&
+		(from.Kind() == reflect.String) == (to.Kind() == reflect.String):
+		if !isNumber(from.Kind()) || !isNumber(to.Kind()) || from.Kind() == to.Kind() {
+			return func(src, dst reflect.Value) error {
+				dst.Set(src.Convert(to))
+				return nil
+			}
+		}
+		return func(src, dst reflect.Value) error {
+			converted := src.Convert(to)
+			if !lossless(src, converted) {
+				return fmt.Errorf("%v overflows or truncates as %s", src, to)
+			}
+			dst.Set(converted)
+			return nil
+		}
+	case from.Kind() == reflect.Pointer && convertValue(from.Elem(), to) != nil && !isJSONValue(from):
+		elemFn := convertValue(from.Elem(), to)
+		return func(src, dst reflect.Value) error {
+			if src.IsNil() {
+				dst.Set(reflect.Zero(to))
+				return nil
+			}
+			return elemFn(src.Elem(), dst)
+		}
+	case to.Kind() == reflect.Pointer && convertValue(from, to.Elem()) != nil && !isJSONValue(to):
+		elemFn := convertValue(from, to.Elem())
+		return func(src, dst reflect.Value) error {
+			// the zero value, e.g. the NULL column, is nil
+			if src.IsZero() || (from == nullTimeType && !src.Interface().(sql.NullTime).Valid) {
+				dst.Set(reflect.Zero(to))
+				return nil
+			}
+			v := reflect.New(to.Elem())
+			if err := elemFn(src, v.Elem()); err != nil {
+				return err
+			}
+			dst.Set(v)
+			return nil
+		}
+	case from == timeType && to == nullTimeType:
+		return func(src, dst reflect.Value) error {
+			t := src.Interface().(time.Time)
+			dst.Set(reflect.ValueOf(sql.NullTime{Time: t, Valid: !t.IsZero()}))
+			return nil
+		}
+	case from == nullTimeType && to == timeType:
+		return func(src, dst reflect.Value) error {
+			dst.Set(reflect.ValueOf(src.Interface().(sql.NullTime).Time))
+			return nil
+		}
+	case isJSONValue(from) && isJSONColumn(to):
+		return func(src, dst reflect.Value) error {
+			bs, err := json.Marshal(src.Interface())
+			if err != nil {
+				return err
+			}
+			if to.Kind() == reflect.String {
+				dst.SetString(string(bs))
+			} else {
+				dst.SetBytes(bs)
+			}
+			return nil
+		}
+	case isJSONColumn(from) && isJSONValue(to):
+		return func(src, dst reflect.Value) error {
+			var bs []byte
+			if from.Kind() == reflect.String {
+				bs = []byte(src.String())
+			} else {
+				bs = src.Bytes()
+			}
+			v := reflect.New(to)
+			if len(bs) != 0 {
+				if err := json.Unmarshal(bs, v.Interface()); err != nil {
+					return err
+				}
+			}
+			dst.Set(v.Elem())
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package rest
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+)
+
+type fooSpec struct {
+	Replicas int               `json:"replicas"`
+	Args     []string          `json:"args"`
+	Env      map[string]string `json:"env"`
+}
+
+type fooStatus struct {
+	Phase string
+}
+
+type foo struct {
+	apis.ObjectMeta
+	Image    string     `gearbox:"column=image_name"`
+	Spec     fooSpec    `gearbox:"column=spec"`
+	Status   fooStatus  `json:"status"`
+	Timeout  *int64     `json:"timeout"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished"`
+	Owner    string     `json:"owner"`
+	Ignored  string     `gearbox:"-"`
+}
+
+type fooModel struct {
+	ID        int64        `gorm:"column:id;primaryKey;autoIncrement:true"`
+	Name      string       `gorm:"column:name"`
+	Revision  string       `gorm:"column:revision"`
+	ImageName string       `gorm:"column:image_name"`
+	Spec      []byte       `gorm:"column:spec"`
+	Phase     string       `gorm:"column:phase"`
+	Timeout   int32        `gorm:"column:timeout"`
+	Started   sql.NullTime `gorm:"column:started"`
+	Finished  time.Time    `gorm:"column:finished"`
+	Owner     string       `gorm:"column:owner"`
+	Ignored   string       `gorm:"column:ignored"`
+}
+
+var fooMetaOptions = []AutoConverterOption{
+	WithFieldMapping("Key", "name"),
+	WithFieldMapping("ResourceVersion", "Revision"),
+	WithIgnoredFields("CreateTime", "UpdateTime"),
+}
+
+func TestAutoConverter(t *testing.T) {
+	c, err := NewAutoConverter[*foo, fooModel](append(fooMetaOptions,
+		WithFieldHook("Owner",
+			func(owner string) (string, error) { return strings.ToUpper(owner), nil },
+			func(owner string) (string, error) { return strings.ToLower(owner), nil },
+		),
+	)...)
+	assert.Nil(t, err)
+
+	timeout := int64(30)
+	started := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	obj := &foo{
+		ObjectMeta: apis.ObjectMeta{Key: "foo", ResourceVersion: "2"},
+		Image:      "nginx",
+		Spec:       fooSpec{Replicas: 3, Args: []string{"-v"}, Env: map[string]string{"A": "B"}},
+		Status:     fooStatus{Phase: "Running"},
+		Timeout:    &timeout,
+		Started:    started,
+		Owner:      "bob",
+		Ignored:    "ignored",
+	}
+
+	var model fooModel
+	assert.Nil(t, c.ToStorage(obj, &model))
+	assert.Equal(t, "foo", model.Name)
+	assert.Equal(t, "2", model.Revision)
+	assert.Equal(t, "nginx", model.ImageName)
+	assert.JSONEq(t, `{"replicas":3,"args":["-v"],"env":{"A":"B"}}`, string(model.Spec))
+	assert.Equal(t, "Running", model.Phase)
+	assert.Equal(t, int32(30), model.Timeout)
+	assert.Equal(t, sql.NullTime{Time: started, Valid: true}, model.Started)
+	assert.True(t, model.Finished.IsZero())
+	assert.Equal(t, "BOB", model.Owner)
+	assert.Equal(t, "", model.Ignored)
+
+	var out foo
+	assert.Nil(t, c.FromStorage(&model, &out))
+	obj.Ignored = ""
+	assert.Equal(t, obj, &out)
+
+	// the NULL column is read back as nil
+	assert.Nil(t, out.Finished)
+	finished := started.Add(time.Hour)
+	obj.Finished = &finished
+	assert.Nil(t, c.ToStorage(obj, &model))
+	assert.Nil(t, c.FromStorage(&model, &out))
+	assert.Equal(t, finished, *out.Finished)
+
+	// out of the range of int32
+	timeout = 1 << 40
+	obj.Finished = nil
+	assert.ErrorContains(t, c.ToStorage(obj, &model), "Timeout")
+}
+
+func TestAutoConverterNarrowing(t *testing.T) {
+	type numbers struct {
+		apis.ObjectMeta
+		Small    int64
+		Count    int
+		Ratio    float64
+		Unsigned uint64
+	}
+	type numbersModel struct {
+		Name     string
+		Revision string
+		Small    int8
+		Count    uint32
+		Ratio    int
+		Unsigned int64
+	}
+	c, err := NewAutoConverter[*numbers, numbersModel](fooMetaOptions...)
+	assert.Nil(t, err)
+
+	var model numbersModel
+	assert.Nil(t, c.ToStorage(&numbers{Small: -128, Count: 7, Ratio: 2, Unsigned: 9}, &model))
+	assert.Equal(t, numbersModel{Small: -128, Count: 7, Ratio: 2, Unsigned: 9}, model)
+
+	for _, obj := range []*numbers{
+		{Small: 128},
+		{Count: -1},
+		{Ratio: 1.5},
+		{Unsigned: 1 << 63},
+	} {
+		assert.NotNil(t, c.ToStorage(obj, &model), "%+v", obj)
+	}
+	assert.NotNil(t, c.FromStorage(&numbersModel{Count: 1 << 31, Unsigned: -1}, &numbers{}))
+}
+
+func TestAutoConverterMismatch(t *testing.T) {
+	type badColumn struct {
+		apis.ObjectMeta
+		Image string `gearbox:"column=image"`
+	}
+	_, err := NewAutoConverter[*badColumn, fooModel](fooMetaOptions...)
+	assert.ErrorContains(t, err, "no field \"image\"")
+
+	type badType struct {
+		apis.ObjectMeta
+		Owner int
+	}
+	_, err = NewAutoConverter[*badType, fooModel](fooMetaOptions...)
+	assert.ErrorContains(t, err, "can't convert")
+
+	type unmapped struct {
+		apis.ObjectMeta
+		Missing string
+	}
+	_, err = NewAutoConverter[*unmapped, fooModel](fooMetaOptions...)
+	assert.ErrorContains(t, err, "no field \"Missing\"")
+	_, err = NewAutoConverter[*unmapped, fooModel](append(fooMetaOptions, WithIgnoredFields("Missing"))...)
+	assert.Nil(t, err)
+
+	_, err = NewAutoConverter[*foo, fooModel](append(fooMetaOptions,
+		WithFieldHook("Owner",
+			func(owner string) (int, error) { return 0, nil },
+			func(owner int) (string, error) { return "", nil },
+		),
+	)...)
+	assert.ErrorContains(t, err, "the hook converts")
+
+	_, err = NewAutoConverter[*foo, fooModel](append(fooMetaOptions, WithFieldMapping("Missing", "name"))...)
+	assert.ErrorContains(t, err, "no such field")
+}
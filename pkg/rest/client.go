@@ -1,21 +1,68 @@
 package rest
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/storage"
 	"github.com/imroc/req/v3"
+	"golang.org/x/time/rate"
 )
 
 type BodyTransformerFunc func(rawBody []byte, req *req.Request, resp *req.Response) (transformedBody []byte, err error)
 
+// AuthFunc returns the headers to authenticate a request, e.g. the Authorization header.
+// It's called before every request so that the credentials can be refreshed.
+type AuthFunc func(ctx context.Context) (map[string]string, error)
+
+// BearerToken authenticates the requests with the static bearer token
+func BearerToken(token string) AuthFunc {
+	return func(ctx context.Context) (map[string]string, error) {
+		return map[string]string{"Authorization": "Bearer " + token}, nil
+	}
+}
+
+// ClientOptions used to construct the HTTPRestClient.
+// <MaxRetries> is the max number of retries of a request, 0 disables the retry. The idempotent
+// requests (GET, PUT and DELETE) are retried on the connection errors and the 5xx responses, all
+// the requests are retried on the 429 and 503 responses.
+// <MinBackoff> and <MaxBackoff> bound the exponential backoff between the retries, default to
+// DefaultMinBackoff and DefaultMaxBackoff. The Retry-After header of the response takes precedence
+// within the same bounds.
+// <RateLimiter> limits the requests on the client side, it can be shared between the clients
+// to limit them together. Nil means no limit.
+// <Timeout> is the timeout of every single request, the watch requests are excluded.
+// <Auth> sets the authentication headers of the requests.
+type ClientOptions struct {
+	MaxRetries  int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	RateLimiter *rate.Limiter
+	Timeout     time.Duration
+	Auth        AuthFunc
+}
+
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+)
+
 type HTTPRestClient[T any, PT interface {
 	apis.Object
 	*T
 }] struct {
 	ResourceName string
 	C            *req.Client
+	Options      ClientOptions
 }
 
 func NewHTTPRestClient[T any, PT interface {
@@ -24,21 +71,34 @@ func NewHTTPRestClient[T any, PT interface {
 }](
 	resourceName, baseurl string,
 	respBodyTransformer BodyTransformerFunc,
+	opts ClientOptions,
 ) *HTTPRestClient[T, PT] {
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
 	c := req.C().
 		SetResponseBodyTransformer(respBodyTransformer).
 		SetBaseURL(baseurl).
 		SetCommonHeader("Content-Type", "application/json")
+	if opts.Timeout > 0 {
+		c.SetTimeout(opts.Timeout)
+	}
 
 	return &HTTPRestClient[T, PT]{
 		ResourceName: resourceName,
 		C:            c,
+		Options:      opts,
 	}
 }
 
 func (cli *HTTPRestClient[T, PT]) Get(ctx context.Context, key string) (PT, error) {
 	var t T
-	_, err := cli.C.R().SetSuccessResult(&t).Get(fmt.Sprintf("%s/%s", cli.ResourceName, key))
+	err := cli.do(ctx, http.MethodGet, fmt.Sprintf("%s/%s", cli.ResourceName, key), func(r *req.Request) {
+		r.SetSuccessResult(&t)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -46,17 +106,39 @@ func (cli *HTTPRestClient[T, PT]) Get(ctx context.Context, key string) (PT, erro
 }
 
 func (cli *HTTPRestClient[T, PT]) GetList(ctx context.Context, opts apis.ListOptions) ([]PT, int64, error) {
-	var objList apis.ObjectList[PT]
-	_, err := cli.C.R().SetSuccessResult(&objList).Get(cli.ResourceName)
+	objList, err := cli.List(ctx, opts)
 	if err != nil {
 		return nil, 0, err
 	}
 	return objList.Items, objList.Count, nil
 }
 
+// List lists the objects with the resourceVersion the Watch can resume from
+func (cli *HTTPRestClient[T, PT]) List(ctx context.Context, opts apis.ListOptions) (*apis.ObjectList[PT], error) {
+	var objList apis.ObjectList[PT]
+	err := cli.do(ctx, http.MethodGet, cli.ResourceName, func(r *req.Request) {
+		r.SetSuccessResult(&objList)
+		if opts.Offset > 0 {
+			r.SetQueryParam("offset", strconv.Itoa(opts.Offset))
+		}
+		if opts.Limit > 0 {
+			r.SetQueryParam("limit", strconv.Itoa(opts.Limit))
+		}
+		if opts.Selector != "" {
+			r.SetQueryParam("selector", opts.Selector)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &objList, nil
+}
+
 func (cli *HTTPRestClient[T, PT]) Create(ctx context.Context, obj PT) (PT, error) {
 	var t T
-	_, err := cli.C.R().SetSuccessResult(&t).SetBody(obj).Post(cli.ResourceName)
+	err := cli.do(ctx, http.MethodPost, cli.ResourceName, func(r *req.Request) {
+		r.SetSuccessResult(&t).SetBody(obj)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +147,9 @@ func (cli *HTTPRestClient[T, PT]) Create(ctx context.Context, obj PT) (PT, error
 
 func (cli *HTTPRestClient[T, PT]) Update(ctx context.Context, key string, obj PT) error {
 	var t T
-	_, err := cli.C.R().SetSuccessResult(&t).SetBody(obj).Put(fmt.Sprintf("%s/%s", cli.ResourceName, key))
+	err := cli.do(ctx, http.MethodPut, fmt.Sprintf("%s/%s", cli.ResourceName, key), func(r *req.Request) {
+		r.SetSuccessResult(&t).SetBody(obj)
+	})
 	if err != nil {
 		return err
 	}
@@ -73,9 +157,127 @@ func (cli *HTTPRestClient[T, PT]) Update(ctx context.Context, key string, obj PT
 }
 
 func (cli *HTTPRestClient[T, PT]) Delete(ctx context.Context, key string) error {
-	_, err := cli.C.R().Delete(fmt.Sprintf("%s/%s", cli.ResourceName, key))
+	err := cli.do(ctx, http.MethodDelete, fmt.Sprintf("%s/%s", cli.ResourceName, key), nil)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// do sends the request with ctx and retries it by the ClientOptions, the error response is
+// decoded into an errors.StatusError. <setup> sets up the request of every attempt, the writes
+// of a storage.WithDryRun context are sent with dryRun=All.
+func (cli *HTTPRestClient[T, PT]) do(ctx context.Context, method, url string, setup func(r *req.Request)) error {
+	for attempt := 0; ; attempt++ {
+		r := cli.C.R()
+		if setup != nil {
+			setup(r)
+		}
+		if method != http.MethodGet && storage.IsDryRun(ctx) {
+			r.SetQueryParam("dryRun", "All")
+		}
+		if err := cli.prepare(ctx, r); err != nil {
+			return err
+		}
+		resp, err := r.Send(method, url)
+		if err == nil && resp.IsErrorState() {
+			err = decodeStatusError(resp.StatusCode, bytes.NewReader(resp.Bytes()))
+		}
+		if err == nil || attempt >= cli.Options.MaxRetries || !retryable(method, resp, err) || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-time.After(cli.retryInterval(resp, attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// prepare waits for the rate limiter and authenticates the request
+func (cli *HTTPRestClient[T, PT]) prepare(ctx context.Context, r *req.Request) error {
+	r.SetContext(ctx)
+	if cli.Options.RateLimiter != nil {
+		if err := cli.Options.RateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if cli.Options.Auth != nil {
+		headers, err := cli.Options.Auth(ctx)
+		if err != nil {
+			return err
+		}
+		r.SetHeaders(headers)
+	}
+	return nil
+}
+
+func retryable(method string, resp *req.Response, err error) bool {
+	if resp != nil && resp.Response != nil {
+		switch resp.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		}
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	if e, ok := err.(errors.StatusError); ok {
+		return e.Status().Code >= http.StatusInternalServerError
+	}
+	// the connection errors
+	return true
+}
+
+// retryInterval returns the Retry-After of the response if any, otherwise the exponential backoff.
+// The Retry-After is bounded by the MinBackoff and MaxBackoff, e.g. a date in the past is the MinBackoff.
+func (cli *HTTPRestClient[T, PT]) retryInterval(resp *req.Response, attempt int) time.Duration {
+	if resp != nil && resp.Response != nil {
+		if after := resp.Header.Get("Retry-After"); after != "" {
+			if seconds, err := strconv.Atoi(after); err == nil && seconds >= 0 {
+				return cli.clampBackoff(time.Duration(seconds) * time.Second)
+			}
+			if t, err := http.ParseTime(after); err == nil {
+				return cli.clampBackoff(time.Until(t))
+			}
+		}
+	}
+	backoff := cli.Options.MinBackoff << attempt
+	if backoff <= 0 || backoff > cli.Options.MaxBackoff {
+		backoff = cli.Options.MaxBackoff
+	}
+	return backoff
+}
+
+func (cli *HTTPRestClient[T, PT]) clampBackoff(d time.Duration) time.Duration {
+	if d < cli.Options.MinBackoff {
+		return cli.Options.MinBackoff
+	}
+	if d > cli.Options.MaxBackoff {
+		return cli.Options.MaxBackoff
+	}
+	return d
+}
+
+// decodeStatusError decodes the Status responded by the server, the status code is used if
+// the body isn't a Status.
+func decodeStatusError(code int, body io.Reader) error {
+	var status apis.Status
+	data, err := io.ReadAll(body)
+	if err == nil {
+		err = json.Unmarshal(data, &status)
+	}
+	if err != nil || status.Code == 0 {
+		status = apis.Status{
+			ObjectMeta: apis.ObjectMeta{Kind: "Status"},
+			Code:       code,
+			Status:     apis.StatusFailure,
+			Reason:     http.StatusText(code),
+			Message:    strings.TrimSpace(string(data)),
+		}
+	}
+	return errors.StatusError{ErrStatus: status}
+}
@@ -0,0 +1,122 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/imroc/req/v3"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
+
+func TestHTTPRestClient(t *testing.T) {
+	resource := &fakeResource{objs: map[string]*foo{}}
+	container := restful.NewContainer()
+	NewHandler[foo, *foo](resource, nil).AddToContainer(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+	cli := NewHTTPRestClient[foo, *foo]("foos", server.URL, nil, ClientOptions{})
+	ctx := context.Background()
+
+	obj, err := cli.Create(ctx, &foo{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"})
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx", obj.Image)
+
+	obj, err = cli.Get(ctx, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx", obj.Image)
+
+	_, err = cli.Get(ctx, "bar")
+	assert.True(t, errors.IsNotFoundError(err))
+	assert.Equal(t, `foo "bar" not found`, err.Error())
+
+	objs, count, err := cli.GetList(ctx, apis.ListOptions{Limit: 10})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), count)
+	assert.Len(t, objs, 1)
+
+	// the context is passed through
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = cli.Get(canceled, "foo")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestHTTPRestClientRetryInterval(t *testing.T) {
+	cli := NewHTTPRestClient[foo, *foo]("foos", "http://127.0.0.1", nil, ClientOptions{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Minute,
+	})
+	retryAfter := func(after string) *req.Response {
+		resp := &req.Response{Response: &http.Response{Header: http.Header{}}}
+		resp.Header.Set("Retry-After", after)
+		return resp
+	}
+	assert.Equal(t, 2*time.Second, cli.retryInterval(retryAfter("2"), 0))
+	// the Retry-After is bounded by the backoff
+	assert.Equal(t, time.Second, cli.retryInterval(retryAfter("0"), 0))
+	assert.Equal(t, time.Minute, cli.retryInterval(retryAfter("86400"), 0))
+	assert.Equal(t, time.Second, cli.retryInterval(retryAfter(time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)), 0))
+	assert.Equal(t, time.Minute, cli.retryInterval(retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)), 0))
+	// the exponential backoff
+	assert.Equal(t, 4*time.Second, cli.retryInterval(nil, 2))
+	assert.Equal(t, time.Minute, cli.retryInterval(nil, 10))
+}
+
+func TestHTTPRestClientRetry(t *testing.T) {
+	var calls int32
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		n := atomic.AddInt32(&calls, 1)
+		switch {
+		case r.Method == http.MethodGet && n == 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.Method == http.MethodGet && n == 2:
+			w.WriteHeader(http.StatusBadGateway)
+		case r.Method == http.MethodGet:
+			_, _ = w.Write([]byte(`{"key":"foo","image":"nginx"}`))
+		default:
+			// the POST isn't idempotent, it's only retried on 429 and 503
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	cli := NewHTTPRestClient[foo, *foo]("foos", server.URL, nil, ClientOptions{
+		MaxRetries:  3,
+		MinBackoff:  time.Millisecond,
+		RateLimiter: limiter,
+		Timeout:     time.Second,
+		Auth:        BearerToken("token"),
+	})
+
+	obj, err := cli.Get(context.Background(), "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx", obj.Image)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, "Bearer token", auth)
+
+	atomic.StoreInt32(&calls, 0)
+	_, err = cli.Create(context.Background(), &foo{ObjectMeta: apis.ObjectMeta{Key: "foo"}})
+	assert.Equal(t, http.StatusInternalServerError, err.(errors.StatusError).Status().Code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// the rate limiter is shared
+	limiter.SetLimit(rate.Every(time.Hour))
+	limiter.SetBurst(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = cli.Get(ctx, "foo")
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,210 @@
+package rest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/imroc/req/v3"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+var _ WatchableClient[*apis.ObjectMeta] = &HTTPRestClient[apis.ObjectMeta, *apis.ObjectMeta]{}
+
+// watchMaxLineSize is the max size of an event of the watch stream
+const watchMaxLineSize = 16 * 1024 * 1024
+
+// Watch streams the events from the watch endpoint of the server. The watch reconnects
+// automatically and resumes after the last received resourceVersion. If the server reports
+// the version as expired, an Error event with the 410 Expired status is delivered and the
+// watch ends like the in-process watches, the consumer is expected to List and watch again
+// from the resourceVersion of the list.
+// The error of the first connection is returned, e.g. the invalid selector. The reconnections
+// back off by the MinBackoff and MaxBackoff of the ClientOptions.
+func (cli *HTTPRestClient[T, PT]) Watch(ctx context.Context, opts apis.WatchOptions) (Channel, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	ch := &clientChannel[T, PT]{
+		ctx:    ctx,
+		cancel: cancel,
+		// the stream lasts longer than any request timeout
+		c:    cli.C.Clone().SetTimeout(0),
+		cli:  cli,
+		opts: opts,
+		ch:   make(chan Event),
+	}
+	body, err := ch.connect()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go ch.run(body)
+	return ch, nil
+}
+
+type clientChannel[T any, PT interface {
+	apis.Object
+	*T
+}] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	c      *req.Client
+	cli    *HTTPRestClient[T, PT]
+	// opts.ResourceVersion is updated by the received events
+	opts apis.WatchOptions
+	ch   chan Event
+}
+
+func (c *clientChannel[T, PT]) Stop() {
+	c.cancel()
+}
+
+func (c *clientChannel[T, PT]) ResultChan() (<-chan Event, error) {
+	return c.ch, nil
+}
+
+// connect sends the watch request, the error is an errors.StatusError if the server responds one
+func (c *clientChannel[T, PT]) connect() (io.ReadCloser, error) {
+	params := map[string]string{"watch": "true"}
+	if c.opts.Key != "" {
+		params["key"] = c.opts.Key
+	}
+	if c.opts.Selector != "" {
+		params["selector"] = c.opts.Selector
+	}
+	if c.opts.ResourceVersion != "" {
+		params["resourceVersion"] = c.opts.ResourceVersion
+	}
+	r := c.c.R().
+		SetHeader("Accept", MIMENDJSON).
+		SetQueryParams(params).
+		DisableAutoReadResponse()
+	if err := c.cli.prepare(c.ctx, r); err != nil {
+		return nil, err
+	}
+	resp, err := r.Get(c.cli.ResourceName)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, decodeStatusError(resp.StatusCode, resp.Body)
+	}
+	return resp.Body, nil
+}
+
+func (c *clientChannel[T, PT]) run(body io.ReadCloser) {
+	defer close(c.ch)
+	minBackoff, maxBackoff := c.cli.Options.MinBackoff, c.cli.Options.MaxBackoff
+	backoff := minBackoff
+	for {
+		// the stream worked, reconnect immediately
+		worked := body != nil && c.stream(body)
+		if c.ctx.Err() != nil {
+			return
+		}
+
+		if worked {
+			backoff = minBackoff
+		} else {
+			select {
+			case <-time.After(backoff):
+			case <-c.ctx.Done():
+				return
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+
+		var err error
+		body, err = c.connect()
+		switch {
+		case err == nil:
+		case isTerminal(err):
+			status := err.(errors.StatusError).Status()
+			c.send(Event{Type: watch.EventTypeError, Err: &status})
+			return
+		}
+	}
+}
+
+// isTerminal returns true if the request won't succeed by retrying, e.g. the resource is
+// not found, the request is forbidden or the resourceVersion is expired.
+func isTerminal(err error) bool {
+	e, ok := err.(errors.StatusError)
+	if !ok {
+		return false
+	}
+	code := e.Status().Code
+	return code >= 400 && code < 500 && code != http.StatusTooManyRequests
+}
+
+// stream reads the events until the stream ends, it returns true if any event is received.
+// The 410 Expired Error event is delivered and ends the watch.
+func (c *clientChannel[T, PT]) stream(body io.ReadCloser) bool {
+	defer body.Close()
+	received := false
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, watchMaxLineSize)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		received = true
+		var evt WatchEvent[PT]
+		if err := json.Unmarshal(scanner.Bytes(), &evt); err != nil {
+			status := errors.NewInternalError(fmt.Errorf("decode the watch event failed: %w", err)).Status()
+			if !c.send(Event{Type: watch.EventTypeError, Err: &status}) {
+				return received
+			}
+			continue
+		}
+		if evt.Type == watch.EventTypeError && evt.Status != nil {
+			switch {
+			case evt.Status.Code == http.StatusGone:
+				c.send(c.toEvent(evt))
+				c.cancel()
+				return received
+			case evt.Status.Code != http.StatusInternalServerError:
+				// the watch is terminated by the server, resume it
+				return received
+			}
+		}
+		if evt.ResourceVersion != "" {
+			c.opts.ResourceVersion = evt.ResourceVersion
+		}
+		if !c.send(c.toEvent(evt)) {
+			return received
+		}
+	}
+	return received
+}
+
+func (c *clientChannel[T, PT]) toEvent(evt WatchEvent[PT]) Event {
+	result := Event{Type: evt.Type, ResourceVersion: evt.ResourceVersion, Err: evt.Status}
+	// keep the nil interface for the events without objects
+	if evt.Object != nil {
+		result.Obj = evt.Object
+	}
+	if evt.OldObject != nil {
+		result.OldObj = evt.OldObject
+	}
+	return result
+}
+
+func (c *clientChannel[T, PT]) send(evt Event) bool {
+	select {
+	case c.ch <- evt:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,146 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+func TestHTTPRestClientWatch(t *testing.T) {
+	var mu sync.Mutex
+	var versions []string
+	resource := &fakeResource{objs: map[string]*foo{}, resourceVersion: "f.0", watch: func(opts apis.WatchOptions) (Channel, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		versions = append(versions, opts.ResourceVersion)
+		switch len(versions) {
+		case 1:
+			// the stream ends after an event, the client resumes from e.1
+			ch := &fakeChannel{ch: make(chan Event, 1)}
+			ch.ch <- Event{Type: watch.EventTypeCreated, ResourceVersion: "e.1", Obj: &foo{ObjectMeta: apis.ObjectMeta{Key: "foo"}}}
+			close(ch.ch)
+			return ch, nil
+		case 2:
+			return nil, errors.NewExpired("expired")
+		default:
+			ch := &fakeChannel{ch: make(chan Event, 1)}
+			ch.ch <- Event{Type: watch.EventTypeUpdated, ResourceVersion: "f.1", Obj: &foo{ObjectMeta: apis.ObjectMeta{Key: "bar"}}}
+			return ch, nil
+		}
+	}}
+	container := restful.NewContainer()
+	NewHandler[foo, *foo](resource, nil).AddToContainer(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	cli := NewHTTPRestClient[foo, *foo]("foos", server.URL, nil, ClientOptions{})
+	ch, err := cli.Watch(context.Background(), apis.WatchOptions{Selector: "image=nginx"})
+	assert.Nil(t, err)
+	defer ch.Stop()
+	resultCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+
+	next := func() Event {
+		select {
+		case evt, ok := <-resultCh:
+			assert.True(t, ok)
+			return evt
+		case <-time.After(5 * time.Second):
+			t.Fatal("wait for the event timeout")
+		}
+		return Event{}
+	}
+	evt := next()
+	assert.Equal(t, watch.EventTypeCreated, evt.Type)
+	assert.Equal(t, "e.1", evt.ResourceVersion)
+	assert.Equal(t, "foo", evt.Obj.(*foo).Key)
+
+	// the version is expired, the watch ends
+	evt = next()
+	assert.Equal(t, watch.EventTypeError, evt.Type)
+	assert.Equal(t, http.StatusGone, evt.Err.Code)
+	select {
+	case _, ok := <-resultCh:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel isn't closed after the expired error")
+	}
+
+	// relist and watch from the version of the list
+	list, err := cli.List(context.Background(), apis.ListOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, "f.0", list.ResourceVersion)
+	ch, err = cli.Watch(context.Background(), apis.WatchOptions{ResourceVersion: list.ResourceVersion})
+	assert.Nil(t, err)
+	defer ch.Stop()
+	resultCh, err = ch.ResultChan()
+	assert.Nil(t, err)
+	evt = next()
+	assert.Equal(t, watch.EventTypeUpdated, evt.Type)
+	assert.Equal(t, "bar", evt.Obj.(*foo).Key)
+
+	mu.Lock()
+	assert.Equal(t, []string{"", "e.1", "f.0"}, versions)
+	mu.Unlock()
+
+	ch.Stop()
+	select {
+	case _, ok := <-resultCh:
+		assert.False(t, ok)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel isn't closed after stop")
+	}
+
+	// the error of the first connection is returned
+	resource.watch = func(opts apis.WatchOptions) (Channel, error) {
+		return nil, errors.NewBadRequest("invalid selector")
+	}
+	_, err = cli.Watch(context.Background(), apis.WatchOptions{Selector: "!!"})
+	assert.True(t, errors.IsBadRequestError(err))
+}
+
+func TestHTTPRestClientWatchReconnect(t *testing.T) {
+	var mu sync.Mutex
+	connections := 0
+	resource := &fakeResource{watch: func(opts apis.WatchOptions) (Channel, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		connections++
+		// every stream ends after an event
+		ch := &fakeChannel{ch: make(chan Event, 1)}
+		ch.ch <- Event{Type: watch.EventTypeBookmark, ResourceVersion: fmt.Sprintf("e.%d", connections)}
+		close(ch.ch)
+		return ch, nil
+	}}
+	container := restful.NewContainer()
+	NewHandler[foo, *foo](resource, nil).AddToContainer(container)
+	server := httptest.NewServer(container)
+	defer server.Close()
+
+	// the stream that worked is resumed without the backoff
+	cli := NewHTTPRestClient[foo, *foo]("foos", server.URL, nil, ClientOptions{MinBackoff: time.Hour, MaxBackoff: time.Hour})
+	ch, err := cli.Watch(context.Background(), apis.WatchOptions{})
+	assert.Nil(t, err)
+	defer ch.Stop()
+	resultCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+	for _, version := range []string{"e.1", "e.2", "e.3"} {
+		select {
+		case evt := <-resultCh:
+			assert.Equal(t, version, evt.ResourceVersion)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the watch isn't resumed immediately")
+		}
+	}
+}
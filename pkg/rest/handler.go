@@ -1,12 +1,20 @@
 package rest
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sunyakun/gearbox/pkg/apis"
 	pkgerrors "github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/storage"
 	"github.com/emicklei/go-restful/v3"
 	"github.com/sirupsen/logrus"
 )
@@ -17,48 +25,254 @@ type Handler[T any, PT interface {
 }] struct {
 	resource     Resource[PT]
 	resourceName string
+	codecs       []apis.Codec
+	// bookmarkInterval is the interval of the Bookmark events of the watch, they keep
+	// the idle connections alive too.
+	bookmarkInterval time.Duration
+	// stopCh is closed by StopWatches to end the watch streams
+	stopCh   chan struct{}
+	stopOnce sync.Once
 }
 
+const (
+	MIMENDJSON      = "application/x-ndjson"
+	MIMEEventStream = "text/event-stream"
+)
+
+const DefaultWatchBookmarkInterval = 30 * time.Second
+
+// WatchEventMetadataKey is the route metadata of the sample event of the watch stream
+const WatchEventMetadataKey = "gearbox.watchEvent"
+
+// ResourceMetadataKey is the route metadata of the ResourceMetadata the route serves
+const ResourceMetadataKey = "gearbox.resource"
+
+// ResourceMetadata describes the resource served by the routes, <Object> is a sample of
+// the resource type.
+type ResourceMetadata struct {
+	Name           string
+	Group          string
+	Version        string
+	StorageVersion string
+	ShortNames     []string
+	Object         apis.Object
+}
+
+// NewHandler creates the Handler, the request and response bodies are encoded by
+// the codecs registered in the scheme. Only JSON is supported if the scheme is nil.
 func NewHandler[T any, PT interface {
 	apis.Object
 	*T
-}](resource Resource[PT]) *Handler[T, PT] {
+}](resource Resource[PT], scheme *apis.Scheme) *Handler[T, PT] {
+	codecs := []apis.Codec{apis.JSONCodec}
+	if scheme != nil {
+		codecs = scheme.Codecs()
+	}
 	return &Handler[T, PT]{
 		resource:     resource,
 		resourceName: resource.Name(),
+		codecs:       codecs,
+
+		bookmarkInterval: DefaultWatchBookmarkInterval,
+		stopCh:           make(chan struct{}),
+	}
+}
+
+// StopWatches ends the outstanding and the later watch streams. http.Server.Shutdown doesn't
+// cancel the contexts of the active requests, register it by http.Server.RegisterOnShutdown so
+// that the watches don't hold the shutdown.
+func (hdl *Handler[T, PT]) StopWatches() {
+	hdl.stopOnce.Do(func() {
+		close(hdl.stopCh)
+	})
+}
+
+func (hdl *Handler[T, PT]) contentTypes() []string {
+	var contentTypes []string
+	for _, codec := range hdl.codecs {
+		contentTypes = append(contentTypes, codec.ContentType())
+	}
+	return contentTypes
+}
+
+func (hdl *Handler[T, PT]) codec(mediaType string) (apis.Codec, bool) {
+	for _, codec := range hdl.codecs {
+		if codec.ContentType() == mediaType {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// acceptRange is a media range of the Accept header, <order> is its position in the header
+type acceptRange struct {
+	mediaType string
+	q         float64
+	order     int
+}
+
+// parseAccept parses the Accept header, the invalid media ranges are ignored
+func parseAccept(header string) []acceptRange {
+	var ranges []acceptRange
+	for _, accept := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q, order: len(ranges)})
+	}
+	return ranges
+}
+
+// match returns the specificity of the media range matches the media type, -1 if it doesn't match
+func (r acceptRange) match(mediaType string) int {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	rangeType, rangeSubtype, _ := strings.Cut(r.mediaType, "/")
+	switch {
+	case r.mediaType == mediaType:
+		return 2
+	case rangeType == typ && rangeSubtype == "*":
+		return 1
+	case rangeType == "*" && rangeSubtype == "*":
+		return 0
+	}
+	return -1
+}
+
+// quality returns the most specific media range of the Accept header matches the media type
+func quality(ranges []acceptRange, mediaType string) (acceptRange, bool) {
+	var (
+		best        acceptRange
+		specificity = -1
+	)
+	for _, r := range ranges {
+		if s := r.match(mediaType); s > specificity {
+			best, specificity = r, s
+		}
+	}
+	return best, specificity >= 0
+}
+
+// responseCodec negotiates the codec of the response by the Accept header. The codec of the
+// highest quality is used, the ties are broken by the order of the Accept header, then by the
+// order of the codecs. The default codec is used if there is no acceptable one.
+func (hdl *Handler[T, PT]) responseCodec(req *restful.Request) apis.Codec {
+	ranges := parseAccept(req.HeaderParameter(restful.HEADER_Accept))
+	var (
+		codec apis.Codec
+		best  acceptRange
+	)
+	for _, c := range hdl.codecs {
+		r, ok := quality(ranges, c.ContentType())
+		if !ok || r.q == 0 {
+			continue
+		}
+		if codec == nil || r.q > best.q || (r.q == best.q && r.order < best.order) {
+			codec, best = c, r
+		}
 	}
+	if codec == nil {
+		return hdl.codecs[0]
+	}
+	return codec
+}
+
+// readEntity decodes the request body by the codec of the Content-Type header
+func (hdl *Handler[T, PT]) readEntity(req *restful.Request, v any) error {
+	codec := hdl.codecs[0]
+	if contentType := req.HeaderParameter(restful.HEADER_ContentType); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return pkgerrors.NewBadRequest(err.Error())
+		}
+		var ok bool
+		if codec, ok = hdl.codec(mediaType); !ok {
+			return pkgerrors.NewBadRequest(fmt.Sprintf("unsupported content type %q", mediaType))
+		}
+	}
+	data, err := io.ReadAll(req.Request.Body)
+	if err != nil {
+		return err
+	}
+	if err := codec.Unmarshal(data, v); err != nil {
+		return pkgerrors.NewBadRequest(err.Error())
+	}
+	return nil
+}
+
+// writeEntity encodes the response body by the negotiated codec
+func (hdl *Handler[T, PT]) writeEntity(req *restful.Request, resp *restful.Response, code int, v any) error {
+	codec := hdl.responseCodec(req)
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp.Header().Set(restful.HEADER_ContentType, codec.ContentType())
+	resp.WriteHeader(code)
+	_, err = resp.Write(data)
+	return err
 }
 
 func (hdl *Handler[T, PT]) Error(req *restful.Request, resp *restful.Response, err error) {
 	var status apis.Status
-	if apiStatus, ok := err.(pkgerrors.APIStatus); ok {
+	// the wrapped StatusError is written intact too, e.g. the field errors of NewInvalid
+	if apiStatus, ok := pkgerrors.FromError(err); ok {
 		status = apiStatus.Status()
 	} else {
 		status = pkgerrors.NewInternalError(err).Status()
 	}
 
-	resp.WriteHeader(status.Code)
-
-	err = resp.WriteEntity(status)
+	err = hdl.writeEntity(req, resp, status.Code, status)
 	if err != nil {
 		logrus.Errorf("internal server error: %s", err)
 		resp.InternalServerError()
 	}
 }
 
+// writeContext returns the context of a write request, the dryRun parameter is carried by the context
+func (hdl *Handler[T, PT]) writeContext(req *restful.Request) (context.Context, error) {
+	ctx := req.Request.Context()
+	switch dryRun := req.QueryParameter("dryRun"); dryRun {
+	case "":
+	case "All":
+		ctx = storage.WithDryRun(ctx)
+	default:
+		return nil, pkgerrors.NewBadRequest(fmt.Sprintf("unsupported dryRun %q, the valid value is \"All\"", dryRun))
+	}
+	return ctx, nil
+}
+
 func (hdl *Handler[T, PT]) Get(req *restful.Request, resp *restful.Response) {
 	resource, err := hdl.resource.Get(req.Request.Context(), req.PathParameter(hdl.resourceName))
 	if err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
-	if err := resp.WriteAsJson(resource); err != nil {
+	if err := hdl.writeEntity(req, resp, http.StatusOK, resource); err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
 }
 
 func (hdl *Handler[T, PT]) List(req *restful.Request, resp *restful.Response) {
+	if watch := req.QueryParameter("watch"); watch != "" {
+		isWatch, err := strconv.ParseBool(watch)
+		if err != nil {
+			hdl.Error(req, resp, pkgerrors.NewBadRequest(fmt.Sprintf("invalid watch %q", watch)))
+			return
+		}
+		if isWatch {
+			hdl.Watch(req, resp)
+			return
+		}
+	}
+
 	offset := req.QueryParameter("offset")
 	limit := req.QueryParameter("limit")
 
@@ -88,7 +302,7 @@ func (hdl *Handler[T, PT]) List(req *restful.Request, resp *restful.Response) {
 		}
 	}
 
-	objs, cnt, err := hdl.resource.GetList(req.Request.Context(), apis.ListOptions{
+	objList, err := hdl.resource.List(req.Request.Context(), apis.ListOptions{
 		Offset:   offsetVal,
 		Limit:    limitVal,
 		Selector: req.QueryParameter("selector"),
@@ -98,44 +312,152 @@ func (hdl *Handler[T, PT]) List(req *restful.Request, resp *restful.Response) {
 		return
 	}
 
-	objList := apis.ObjectList[PT]{
-		Count: cnt,
-		Items: objs,
+	if int64(offsetVal)+int64(limitVal) < objList.Count {
+		objList.Continue = true
 	}
 
-	if int64(offsetVal)+int64(limitVal) < cnt {
-		objList.Continue = true
+	err = hdl.writeEntity(req, resp, http.StatusOK, objList)
+	if err != nil {
+		hdl.Error(req, resp, err)
+		return
+	}
+}
+
+// Watch streams the events as newline-delimited JSON, or as server-sent events if the client
+// accepts text/event-stream. The stream ends when the client disconnects, the request context
+// is cancelled, StopWatches is called, or the watch is terminated by the server with an Error event.
+func (hdl *Handler[T, PT]) Watch(req *restful.Request, resp *restful.Response) {
+	sse := hdl.acceptsEventStream(req)
+	opts := apis.WatchOptions{
+		Key:              req.QueryParameter("key"),
+		Selector:         req.QueryParameter("selector"),
+		ResourceVersion:  req.QueryParameter("resourceVersion"),
+		BookmarkInterval: hdl.bookmarkInterval,
+	}
+	if sse && opts.ResourceVersion == "" {
+		// set by the EventSource of the browsers on reconnecting
+		opts.ResourceVersion = req.HeaderParameter("Last-Event-ID")
 	}
 
-	err = resp.WriteAsJson(objList)
+	select {
+	case <-hdl.stopCh:
+		hdl.Error(req, resp, pkgerrors.NewServiceUnavailable("the server is shutting down"))
+		return
+	default:
+	}
+
+	ctx := req.Request.Context()
+	channel, err := hdl.resource.Watch(ctx, opts)
+	if err != nil {
+		hdl.Error(req, resp, err)
+		return
+	}
+	defer channel.Stop()
+	eventCh, err := channel.ResultChan()
 	if err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
+
+	contentType := MIMENDJSON
+	if sse {
+		contentType = MIMEEventStream
+	}
+	resp.Header().Set(restful.HEADER_ContentType, contentType)
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+	flusher, _ := resp.ResponseWriter.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case evt, ok := <-eventCh:
+			if !ok {
+				return
+			}
+			if err := writeWatchEvent(resp, evt, sse); err != nil {
+				logrus.Errorf("write the watch event failed: %s", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-ctx.Done():
+			return
+		case <-hdl.stopCh:
+			return
+		}
+	}
+}
+
+func (hdl *Handler[T, PT]) acceptsEventStream(req *restful.Request) bool {
+	// the event stream must be accepted explicitly, the wildcards select the default NDJSON stream
+	for _, r := range parseAccept(req.HeaderParameter(restful.HEADER_Accept)) {
+		if r.mediaType == MIMEEventStream && r.q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func writeWatchEvent(w io.Writer, evt Event, sse bool) error {
+	data, err := json.Marshal(WatchEvent[apis.Object]{
+		Type:            evt.Type,
+		ResourceVersion: evt.ResourceVersion,
+		Object:          evt.Obj,
+		OldObject:       evt.OldObj,
+		Status:          evt.Err,
+	})
+	if err != nil {
+		return err
+	}
+	if !sse {
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	if evt.ResourceVersion != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", evt.ResourceVersion); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", evt.Type, data)
+	return err
 }
 
 func (hdl *Handler[T, PT]) Update(req *restful.Request, resp *restful.Response) {
+	ctx, err := hdl.writeContext(req)
+	if err != nil {
+		hdl.Error(req, resp, err)
+		return
+	}
 	var t PT = new(T)
-	if err := req.ReadEntity(t); err != nil {
+	if err := hdl.readEntity(req, t); err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
-	if err := hdl.resource.Update(req.Request.Context(), req.PathParameter(hdl.resourceName), t); err != nil {
+	if err := hdl.resource.Update(ctx, req.PathParameter(hdl.resourceName), t); err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
-	if err := resp.WriteAsJson(t); err != nil {
+	if err := hdl.writeEntity(req, resp, http.StatusOK, t); err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
 }
 
 func (hdl *Handler[T, PT]) Delete(req *restful.Request, resp *restful.Response) {
-	if err := hdl.resource.Delete(req.Request.Context(), req.PathParameter(hdl.resourceName)); err != nil {
+	ctx, err := hdl.writeContext(req)
+	if err != nil {
+		hdl.Error(req, resp, err)
+		return
+	}
+	if err := hdl.resource.Delete(ctx, req.PathParameter(hdl.resourceName)); err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
-	if err := resp.WriteEntity(apis.Status{
+	if err := hdl.writeEntity(req, resp, http.StatusOK, apis.Status{
 		ObjectMeta: apis.ObjectMeta{Kind: "Status"},
 		Code:       http.StatusOK,
 		Status:     apis.StatusSuccess,
@@ -148,57 +470,116 @@ func (hdl *Handler[T, PT]) Delete(req *restful.Request, resp *restful.Response)
 }
 
 func (hdl *Handler[T, PT]) Create(req *restful.Request, resp *restful.Response) {
+	ctx, err := hdl.writeContext(req)
+	if err != nil {
+		hdl.Error(req, resp, err)
+		return
+	}
 	var t PT = new(T)
-	if err := req.ReadEntity(t); err != nil {
+	if err := hdl.readEntity(req, t); err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
-	obj, err := hdl.resource.Create(req.Request.Context(), t)
+	obj, err := hdl.resource.Create(ctx, t)
 	if err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
-	if err := resp.WriteEntity(obj); err != nil {
+	if err := hdl.writeEntity(req, resp, http.StatusOK, obj); err != nil {
 		hdl.Error(req, resp, err)
 		return
 	}
 }
 
+// ResourcePath returns "/<group>/<version>/<resource>", or "/<resource>" of the legacy APIs
+func ResourcePath(gv apis.GroupVersion, resource string) string {
+	if gv.Group == "" {
+		return "/" + resource
+	}
+	return "/" + gv.Group + "/" + gv.Version + "/" + resource
+}
+
 func (hdl *Handler[T, PT]) AddToContainer(container *restful.Container) {
 	ws := new(restful.WebService)
 	keyParam := restful.PathParameter(hdl.resourceName, "the resource "+hdl.resourceName).DataType("string")
+	dryRunParam := restful.QueryParameter("dryRun", "when set to All, the write is checked but not persisted").DataType("string")
+
+	gv := apis.GroupVersion{Group: hdl.resource.Group(), Version: hdl.resource.Version()}
+	ws.Path(ResourcePath(gv, hdl.resourceName)).
+		ApiVersion(gv.String()).
+		Doc("API for " + gv.String() + "/" + hdl.resource.Name()).
+		Consumes(hdl.contentTypes()...).
+		Produces(hdl.contentTypes()...)
 
-	ws.Path("/" + hdl.resource.Name()).
-		ApiVersion(hdl.resource.Version()).
-		Doc("API for " + hdl.resource.Version() + "/" + hdl.resource.Name()).
-		Consumes(restful.MIME_JSON).
-		Produces(restful.MIME_JSON)
+	operation := strings.ToUpper(hdl.resourceName[:1]) + hdl.resourceName[1:]
+	if gv.Group != "" {
+		// the versions of a resource are served together
+		operation += strings.ToUpper(gv.Version[:1]) + gv.Version[1:]
+	}
+	status := apis.Status{}
+	meta := ResourceMetadata{Name: hdl.resourceName, Group: gv.Group, Version: gv.Version, Object: PT(new(T))}
+	if namer, ok := hdl.resource.(ShortNamer); ok {
+		meta.ShortNames = namer.ShortNames()
+	}
+	if versioner, ok := hdl.resource.(StorageVersioner); ok {
+		meta.StorageVersion = versioner.StorageVersion()
+	}
 
 	// get
 	ws.Route(ws.GET(fmt.Sprintf("/{%s}", hdl.resourceName)).
 		To(hdl.Get).
-		Param(keyParam))
+		Operation("get"+operation).
+		Param(keyParam).
+		Returns(http.StatusOK, "OK", new(T)).
+		DefaultReturns("the failure status", status).
+		Metadata(ResourceMetadataKey, meta))
 
 	// update
 	ws.Route(ws.PUT(fmt.Sprintf("/{%s}", hdl.resourceName)).
 		To(hdl.Update).
-		Param(keyParam))
+		Operation("update"+operation).
+		Param(keyParam).
+		Param(dryRunParam).
+		Reads(new(T)).
+		Returns(http.StatusOK, "OK", new(T)).
+		DefaultReturns("the failure status", status).
+		Metadata(ResourceMetadataKey, meta))
 
 	// delete
 	ws.Route(ws.DELETE(fmt.Sprintf("/{%s}", hdl.resourceName)).
 		To(hdl.Delete).
-		Param(keyParam))
+		Operation("delete"+operation).
+		Param(keyParam).
+		Param(dryRunParam).
+		Returns(http.StatusOK, "OK", status).
+		DefaultReturns("the failure status", status).
+		Metadata(ResourceMetadataKey, meta))
 
-	// list
+	// list and watch
 	ws.Route(ws.GET("/").
-		To(hdl.List)).
+		To(hdl.List).
+		Operation("list"+operation).
+		Produces(append(hdl.contentTypes(), MIMENDJSON, MIMEEventStream)...).
 		Param(restful.QueryParameter("limit", "the limit size").DataType("int")).
 		Param(restful.QueryParameter("offset", "the offset").DataType("int")).
-		Param(restful.QueryParameter("selector", "selector expression").DataType("string"))
+		Param(restful.QueryParameter("selector", "selector expression").DataType("string")).
+		Param(restful.QueryParameter("watch", "stream the events instead of listing").DataType("boolean")).
+		Param(restful.QueryParameter("key", "watch the single object of the key").DataType("string")).
+		Param(restful.QueryParameter("resourceVersion", "resume the watch after the event of the version").DataType("string")).
+		Returns(http.StatusOK, "OK", apis.ObjectList[PT]{}).
+		Metadata(WatchEventMetadataKey, WatchEvent[PT]{}).
+		DefaultReturns("the failure status", status).
+		Metadata(ResourceMetadataKey, meta))
 
 	// create
 	ws.Route(ws.POST("/").
-		To(hdl.Create))
+		To(hdl.Create).
+		Operation("create"+operation).
+		Param(dryRunParam).
+		Reads(new(T)).
+		Returns(http.StatusOK, "OK", new(T)).
+		DefaultReturns("the failure status", status).
+		Metadata(ResourceMetadataKey, meta))
 
 	container.Add(ws)
 }
@@ -0,0 +1,236 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+type fakeResource struct {
+	group           string
+	objs            map[string]*foo
+	resourceVersion string
+	watch           func(opts apis.WatchOptions) (Channel, error)
+}
+
+func (r *fakeResource) Get(ctx context.Context, key string) (*foo, error) {
+	obj, ok := r.objs[key]
+	if !ok {
+		return nil, errors.NewNotFound("foo", key)
+	}
+	return obj, nil
+}
+
+func (r *fakeResource) GetList(ctx context.Context, opts apis.ListOptions) ([]*foo, int64, error) {
+	var objs []*foo
+	for _, obj := range r.objs {
+		objs = append(objs, obj)
+	}
+	return objs, int64(len(objs)), nil
+}
+
+func (r *fakeResource) List(ctx context.Context, opts apis.ListOptions) (*apis.ObjectList[*foo], error) {
+	objs, count, err := r.GetList(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &apis.ObjectList[*foo]{Count: count, ResourceVersion: r.resourceVersion, Items: objs}, nil
+}
+
+func (r *fakeResource) Create(ctx context.Context, obj *foo) (*foo, error) {
+	r.objs[obj.Key] = obj
+	return obj, nil
+}
+
+func (r *fakeResource) Update(ctx context.Context, key string, obj *foo) error {
+	r.objs[key] = obj
+	return nil
+}
+
+func (r *fakeResource) Delete(ctx context.Context, key string) error {
+	delete(r.objs, key)
+	return nil
+}
+
+func (r *fakeResource) Watch(ctx context.Context, opts apis.WatchOptions) (Channel, error) {
+	return r.watch(opts)
+}
+
+type fakeChannel struct {
+	ch chan Event
+}
+
+func (c *fakeChannel) Stop() {}
+
+func (c *fakeChannel) ResultChan() (<-chan Event, error) {
+	return c.ch, nil
+}
+
+func (r *fakeResource) Name() string { return "foos" }
+
+func (r *fakeResource) Group() string { return r.group }
+
+func (r *fakeResource) Version() string { return "v1" }
+
+func (r *fakeResource) Install(*restful.Container) {}
+
+func TestHandlerCodecs(t *testing.T) {
+	scheme := apis.NewScheme()
+	scheme.AddCodecs(apis.CBORCodec)
+	container := restful.NewContainer()
+	NewHandler[foo, *foo](&fakeResource{objs: map[string]*foo{}}, scheme).AddToContainer(container)
+
+	obj := &foo{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"}
+	body, err := apis.CBORCodec.Marshal(obj)
+	assert.Nil(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/foos", bytes.NewReader(body))
+	req.Header.Set("Content-Type", apis.MIMECBOR)
+	req.Header.Set("Accept", apis.MIMECBOR)
+	w := httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, apis.MIMECBOR, w.Header().Get("Content-Type"))
+	var out foo
+	assert.Nil(t, apis.CBORCodec.Unmarshal(w.Body.Bytes(), &out))
+	assert.Equal(t, "nginx", out.Image)
+
+	// JSON is the default
+	req = httptest.NewRequest(http.MethodGet, "/foos/foo", nil)
+	w = httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, apis.MIMEJSON, w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"key":"foo"`)
+
+	// the errors are encoded by the negotiated codec too
+	req = httptest.NewRequest(http.MethodGet, "/foos/bar", nil)
+	req.Header.Set("Accept", "application/xml;q=0.9, application/cbor")
+	w = httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var status apis.Status
+	assert.Nil(t, apis.CBORCodec.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, http.StatusNotFound, status.Code)
+}
+
+func TestHandlerAcceptNegotiation(t *testing.T) {
+	scheme := apis.NewScheme()
+	scheme.AddCodecs(apis.CBORCodec)
+	hdl := NewHandler[foo, *foo](&fakeResource{}, scheme)
+	for accept, expected := range map[string]string{
+		"":                 apis.MIMEJSON,
+		"application/cbor": apis.MIMECBOR,
+		"application/json;q=0.5, application/cbor": apis.MIMECBOR,
+		"application/cbor;q=0.5, application/json": apis.MIMEJSON,
+		"application/json, application/cbor":       apis.MIMEJSON,
+		"application/cbor, application/json":       apis.MIMECBOR,
+		"*/*":                                      apis.MIMEJSON,
+		"application/*":                            apis.MIMEJSON,
+		"*/*;q=0.1, application/cbor;q=0.2":        apis.MIMECBOR,
+		"application/*, application/json;q=0":      apis.MIMECBOR,
+		"application/json;q=0":                     apis.MIMEJSON,
+		"application/cbor;q=0.0":                   apis.MIMEJSON,
+		"application/cbor;q=invalid":               apis.MIMEJSON,
+		"text/*, application/cbor;q=0.1":           apis.MIMECBOR,
+		"application/xml":                          apis.MIMEJSON,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/foos", nil)
+		req.Header.Set("Accept", accept)
+		assert.Equal(t, expected, hdl.responseCodec(restful.NewRequest(req)).ContentType(), accept)
+	}
+}
+
+func TestHandlerWatch(t *testing.T) {
+	var watchOpts apis.WatchOptions
+	resource := &fakeResource{watch: func(opts apis.WatchOptions) (Channel, error) {
+		watchOpts = opts
+		if opts.ResourceVersion == "expired" {
+			return nil, errors.NewExpired("expired")
+		}
+		status := errors.NewServiceUnavailable("disconnected").Status()
+		ch := &fakeChannel{ch: make(chan Event, 3)}
+		ch.ch <- Event{Type: watch.EventTypeCreated, ResourceVersion: "e.1", Obj: &foo{ObjectMeta: apis.ObjectMeta{Key: "foo"}}}
+		ch.ch <- Event{Type: watch.EventTypeBookmark, ResourceVersion: "e.2"}
+		ch.ch <- Event{Type: watch.EventTypeError, Err: &status}
+		close(ch.ch)
+		return ch, nil
+	}}
+	container := restful.NewContainer()
+	NewHandler[foo, *foo](resource, nil).AddToContainer(container)
+
+	req := httptest.NewRequest(http.MethodGet, "/foos?watch=true&selector=image%3Dnginx&resourceVersion=e.0", nil)
+	w := httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, MIMENDJSON, w.Header().Get("Content-Type"))
+	assert.Equal(t, apis.WatchOptions{Selector: "image=nginx", ResourceVersion: "e.0", BookmarkInterval: DefaultWatchBookmarkInterval}, watchOpts)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+	var evt WatchEvent[*foo]
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &evt))
+	assert.Equal(t, watch.EventTypeCreated, evt.Type)
+	assert.Equal(t, "foo", evt.Object.Key)
+	assert.JSONEq(t, `{"type":"Bookmark","resourceVersion":"e.2"}`, lines[1])
+	evt = WatchEvent[*foo]{}
+	assert.Nil(t, json.Unmarshal([]byte(lines[2]), &evt))
+	assert.Equal(t, http.StatusServiceUnavailable, evt.Status.Code)
+
+	// server-sent events, resumed by the EventSource
+	req = httptest.NewRequest(http.MethodGet, "/foos?watch=1", nil)
+	req.Header.Set("Accept", MIMEEventStream)
+	req.Header.Set("Last-Event-ID", "e.0")
+	w = httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, MIMEEventStream, w.Header().Get("Content-Type"))
+	assert.Equal(t, "e.0", watchOpts.ResourceVersion)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "id: e.1\nevent: Created\ndata: {"))
+	assert.Contains(t, w.Body.String(), "id: e.2\nevent: Bookmark\ndata: {\"type\":\"Bookmark\",\"resourceVersion\":\"e.2\"}\n\n")
+
+	req = httptest.NewRequest(http.MethodGet, "/foos?watch=true&resourceVersion=expired", nil)
+	w = httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusGone, w.Code)
+}
+
+func TestHandlerStopWatches(t *testing.T) {
+	watching := make(chan struct{})
+	resource := &fakeResource{watch: func(opts apis.WatchOptions) (Channel, error) {
+		close(watching)
+		return &fakeChannel{ch: make(chan Event)}, nil
+	}}
+	hdl := NewHandler[foo, *foo](resource, nil)
+	container := restful.NewContainer()
+	hdl.AddToContainer(container)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodGet, "/foos?watch=true", nil)
+		container.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-watching
+	hdl.StopWatches()
+	hdl.StopWatches()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the watch isn't stopped")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/foos?watch=true", nil)
+	w := httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
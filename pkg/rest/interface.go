@@ -23,12 +23,32 @@ type Client[T apis.Object] interface {
 
 type WatchableClient[T apis.Object] interface {
 	Client[T]
-	Watch(ctx context.Context) (Channel, error)
+	Watch(ctx context.Context, opts apis.WatchOptions) (Channel, error)
 }
 
-type Resource[T apis.Object] interface {
+// ListWatcher lists the objects with the resourceVersion of the watch stream, the watch from
+// the version after the list misses no change, see apis.ObjectList.
+type ListWatcher[T apis.Object] interface {
 	WatchableClient[T]
+	List(ctx context.Context, opts apis.ListOptions) (*apis.ObjectList[T], error)
+}
+
+// Resource is served at "/<group>/<version>/<name>", or "/<name>" if the group is empty.
+type Resource[T apis.Object] interface {
+	ListWatcher[T]
 	Name() string
+	Group() string
 	Version() string
 	Install(*restful.Container)
 }
+
+// ShortNamer is implemented by the resources having short names, e.g. "po" of "pods"
+type ShortNamer interface {
+	ShortNames() []string
+}
+
+// StorageVersioner is implemented by the resources to report the version persisted in the
+// storage, the other versions are converted from it.
+type StorageVersioner interface {
+	StorageVersion() string
+}
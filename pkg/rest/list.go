@@ -0,0 +1,29 @@
+package rest
+
+import (
+	"context"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+)
+
+// ListPageSize is the page size of ListAll
+var ListPageSize = 100
+
+// ListAll lists all the objects selected by opts page by page, the Offset and Limit of opts
+// are ignored. The zero Limit isn't "no limit" for all the clients, e.g. the server lists 10
+// objects by default, so the callers need all the objects page through them by ListAll.
+func ListAll[T apis.Object](ctx context.Context, client Client[T], opts apis.ListOptions) ([]T, error) {
+	var all []T
+	for {
+		opts.Offset, opts.Limit = len(all), ListPageSize
+		objs, count, err := client.GetList(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, objs...)
+		// the server may return less than the limit, the count decides the end
+		if len(objs) == 0 || int64(len(all)) >= count {
+			return all, nil
+		}
+	}
+}
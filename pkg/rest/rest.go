@@ -2,9 +2,12 @@ package rest
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/sunyakun/gearbox/pkg/admission"
 	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/authorization"
 	"github.com/sunyakun/gearbox/pkg/errors"
 	"github.com/sunyakun/gearbox/pkg/storage"
 	"github.com/sunyakun/gearbox/pkg/storage/selector"
@@ -21,10 +24,13 @@ type RestAPI[T any, PT interface {
 	converter    Converter[PT, ST]
 	store        storage.WatchableStore[ST]
 	resourceName string
+	group        string
 	version      string
 	logger       logr.Logger
 	admit        admission.Interface
 	scheme       *apis.Scheme
+	shortNames   []string
+	authorizer   authorization.Authorizer
 }
 
 func NewRestAPI[T any, PT interface {
@@ -34,7 +40,7 @@ func NewRestAPI[T any, PT interface {
 	resourceName string, store storage.WatchableStore[ST], scheme *apis.Scheme, converter Converter[PT, ST], logger logr.Logger, admits []admission.Interface,
 ) *RestAPI[T, PT, ST] {
 
-	return &RestAPI[T, PT, ST]{
+	rest := &RestAPI[T, PT, ST]{
 		converter:    converter,
 		store:        store,
 		resourceName: resourceName,
@@ -43,16 +49,86 @@ func NewRestAPI[T any, PT interface {
 		admit:        admission.NewChainHandler(admits...),
 		scheme:       scheme,
 	}
+	// the group version of the types added by AddVersionedTypes
+	if scheme != nil {
+		if gvk, err := scheme.ObjectGroupVersionKind(PT(new(T))); err == nil && gvk.Version != "" {
+			rest.group, rest.version = gvk.Group, gvk.Version
+		}
+	}
+	return rest
 }
 
 func (rest *RestAPI[T, PT, ST]) Name() string {
 	return rest.resourceName
 }
 
+func (rest *RestAPI[T, PT, ST]) Group() string {
+	return rest.group
+}
+
 func (rest *RestAPI[T, PT, ST]) Version() string {
 	return rest.version
 }
 
+// StorageVersion returns the version of the RestAPI, it's the version persisted in the storage
+func (rest *RestAPI[T, PT, ST]) StorageVersion() string {
+	return rest.version
+}
+
+// SetShortNames sets the short names published by the discovery
+func (rest *RestAPI[T, PT, ST]) SetShortNames(names ...string) {
+	rest.shortNames = names
+}
+
+func (rest *RestAPI[T, PT, ST]) ShortNames() []string {
+	return rest.shortNames
+}
+
+// resourceVersionSetter is implemented by the objects embed apis.ObjectMeta
+type resourceVersionSetter interface {
+	SetResourceVersion(string)
+}
+
+// SetAuthorizer sets the authorizer called before the operations. The calls without a user in
+// the context are Unauthorized once it's set, the trusted in-process callers, e.g. the controllers,
+// call with authentication.WithSystemUser to skip the authorization.
+func (rest *RestAPI[T, PT, ST]) SetAuthorizer(authorizer authorization.Authorizer) {
+	rest.authorizer = authorizer
+}
+
+// authorize returns the Forbidden error if the user of the context can't do the operation
+func (rest *RestAPI[T, PT, ST]) authorize(ctx context.Context, attrs authorization.Attributes) error {
+	if rest.authorizer == nil || authentication.IsSystemUser(ctx) {
+		return nil
+	}
+	user, ok := authentication.UserFrom(ctx)
+	if !ok {
+		return errors.NewUnauthorized("the request isn't authenticated")
+	}
+	attrs.User = user
+	attrs.Group = rest.group
+	attrs.Resource = rest.resourceName
+	allowed, reason, err := rest.authorizer.Authorize(ctx, attrs)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		kind, _ := rest.scheme.ObjectKind(PT(new(T)))
+		return errors.NewForbidden(attrs.Verb, kind, attrs.Key, reason)
+	}
+	return nil
+}
+
+// authorizeStored authorizes the operation on the stored object of the key, it's nil if the
+// object can't be read, e.g. it's not found.
+func (rest *RestAPI[T, PT, ST]) authorizeStored(ctx context.Context, verb, key string, stored PT) error {
+	attrs := authorization.Attributes{Verb: verb, Key: key}
+	if stored != nil {
+		attrs.Object = stored
+	}
+	return rest.authorize(ctx, attrs)
+}
+
 func (rest *RestAPI[T, PT, ST]) convertStorageError(err error, obj apis.Object) error {
 	kind, e := rest.scheme.ObjectKind(obj)
 	if e != nil {
@@ -65,28 +141,52 @@ func (rest *RestAPI[T, PT, ST]) convertStorageError(err error, obj apis.Object)
 		return errors.NewConflict(err)
 	case storage.IsConcurrentConclictError(err):
 		return errors.NewConflict(err)
+	case storage.IsInvalidSelectorError(err):
+		return errors.NewBadRequest(err.Error())
 	}
 	return err
 }
 
-func (rest *RestAPI[T, PT, ST]) doAdmit(ctx context.Context, operation admission.Operation, obj apis.Object) error {
+// checkDryRun returns BadRequest if the write of the context is a dry-run but the store can't
+// roll it back, see storage.DryRunner.
+func (rest *RestAPI[T, PT, ST]) checkDryRun(ctx context.Context) error {
+	if !storage.IsDryRun(ctx) {
+		return nil
+	}
+	if dryRunner, ok := rest.store.(storage.DryRunner); ok && dryRunner.SupportsDryRun() {
+		return nil
+	}
+	return errors.NewBadRequest(fmt.Sprintf("the store of %s doesn't support dryRun", rest.resourceName))
+}
+
+// doAdmit admits the operation, <oldObj> is the stored object of update and delete
+func (rest *RestAPI[T, PT, ST]) doAdmit(ctx context.Context, operation admission.Operation, obj, oldObj PT) error {
 	attrs := &admission.Attribute{
 		Object:       obj,
 		Operation:    operation,
 		ResourceName: rest.resourceName,
+		DryRun:       storage.IsDryRun(ctx),
+	}
+	if oldObj != nil {
+		attrs.OldObject = oldObj
+	}
+	attrs.UserInfo, _ = authentication.UserFrom(ctx)
+	if rest.scheme != nil {
+		attrs.Kind, _ = rest.scheme.ObjectGroupVersionKind(obj)
 	}
 	if rest.admit != admission.Interface(nil) && rest.admit.Handles(operation) {
-		validation, ok := rest.admit.(admission.ValidationInterface)
+		// the validators see the final object after all the mutations
+		mutation, ok := rest.admit.(admission.MutationInterface)
 		if ok {
-			if err := validation.Validate(ctx, attrs); err != nil {
-				rest.logger.Error(err, "do validator admit failed", "operation", operation)
+			if err := mutation.Admit(ctx, attrs); err != nil {
+				rest.logger.Error(err, "do mutation admission failed", "operation", operation)
 				return err
 			}
 		}
-		mutation, ok := rest.admit.(admission.MutationInterface)
+		validation, ok := rest.admit.(admission.ValidationInterface)
 		if ok {
-			if err := mutation.Admit(ctx, attrs); err != nil {
-				rest.logger.Error(err, "do mutation admission failed", "operation", operation)
+			if err := validation.Validate(ctx, attrs); err != nil {
+				rest.logger.Error(err, "do validator admit failed", "operation", operation)
 				return err
 			}
 		}
@@ -95,6 +195,20 @@ func (rest *RestAPI[T, PT, ST]) doAdmit(ctx context.Context, operation admission
 }
 
 func (rest *RestAPI[T, PT, ST]) Get(ctx context.Context, key string) (PT, error) {
+	obj, err := rest.get(ctx, key)
+	if err != nil {
+		if e := rest.authorize(ctx, authorization.Attributes{Verb: authorization.VerbGet, Key: key}); e != nil {
+			return nil, e
+		}
+		return nil, err
+	}
+	if err := rest.authorize(ctx, authorization.Attributes{Verb: authorization.VerbGet, Key: key, Object: obj}); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (rest *RestAPI[T, PT, ST]) get(ctx context.Context, key string) (PT, error) {
 	var obj = PT(new(T))
 	obj.SetKey(key)
 	storeObj, err := rest.store.Get(ctx, key)
@@ -104,11 +218,9 @@ func (rest *RestAPI[T, PT, ST]) Get(ctx context.Context, key string) (PT, error)
 	if err := rest.converter.FromStorage(storeObj, obj); err != nil {
 		return nil, err
 	}
-	kind, err := rest.scheme.ObjectKind(obj)
-	if err != nil {
+	if err := rest.scheme.SetTypeMeta(obj); err != nil {
 		return nil, err
 	}
-	obj.SetKind(kind)
 	return obj, nil
 }
 
@@ -124,6 +236,9 @@ func (rest *RestAPI[T, PT, ST]) GetList(ctx context.Context, opts apis.ListOptio
 	if err != nil {
 		return nil, 0, err
 	}
+	if err := rest.authorize(ctx, authorization.Attributes{Verb: authorization.VerbList, Selector: opts.Selector}); err != nil {
+		return nil, 0, err
+	}
 
 	storeObjs, count, err := rest.store.GetList(ctx, storage.ListOptions{
 		Offset:       opts.Offset,
@@ -139,21 +254,39 @@ func (rest *RestAPI[T, PT, ST]) GetList(ctx context.Context, opts apis.ListOptio
 		if err := rest.converter.FromStorage(storeobj, obj); err != nil {
 			return nil, 0, err
 		}
-		kind, err := rest.scheme.ObjectKind(obj)
-		if err != nil {
+		if err := rest.scheme.SetTypeMeta(obj); err != nil {
 			return nil, 0, err
 		}
-		obj.SetKind(kind)
 		outs = append(outs, obj)
 	}
 	return outs, count, err
 }
 
+// List lists the objects like GetList, the resourceVersion of the list is taken from the store
+// before the list.
+func (rest *RestAPI[T, PT, ST]) List(ctx context.Context, opts apis.ListOptions) (*apis.ObjectList[PT], error) {
+	rv, err := rest.store.ResourceVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objs, count, err := rest.GetList(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &apis.ObjectList[PT]{Count: count, ResourceVersion: rv, Items: objs}, nil
+}
+
 func (rest *RestAPI[T, PT, ST]) Create(ctx context.Context, obj PT) (PT, error) {
 	if obj.GetKey() == "" {
 		return nil, errors.NewBadRequest("the key can't be empty")
 	}
-	if err := rest.doAdmit(ctx, admission.Create, obj); err != nil {
+	if err := rest.checkDryRun(ctx); err != nil {
+		return nil, err
+	}
+	if err := rest.authorize(ctx, authorization.Attributes{Verb: authorization.VerbCreate, Key: obj.GetKey(), Object: obj}); err != nil {
+		return nil, err
+	}
+	if err := rest.doAdmit(ctx, admission.Create, obj, nil); err != nil {
 		return nil, err
 	}
 	var storeObj = new(ST)
@@ -167,17 +300,41 @@ func (rest *RestAPI[T, PT, ST]) Create(ctx context.Context, obj PT) (PT, error)
 	if err := rest.converter.FromStorage(newStoreObj, obj); err != nil {
 		return nil, err
 	}
-	kind, err := rest.scheme.ObjectKind(obj)
-	if err != nil {
+	if err := rest.scheme.SetTypeMeta(obj); err != nil {
 		return nil, err
 	}
-	obj.SetKind(kind)
 	return obj, nil
 }
 
+// Update replaces the object of the key. The object without the resourceVersion is updated
+// on the version read before the admission, the outdated resourceVersion is a Conflict.
 func (rest *RestAPI[T, PT, ST]) Update(ctx context.Context, key string, obj PT) error {
+	if err := rest.checkDryRun(ctx); err != nil {
+		return err
+	}
 	obj.SetKey(key)
-	if err := rest.doAdmit(ctx, admission.Update, obj); err != nil {
+	oldObj, err := rest.get(ctx, key)
+	// the user must be allowed to update both the stored object and the new one, the
+	// authorization goes first so that the forbidden users can't tell whether it exists
+	if e := rest.authorizeStored(ctx, authorization.VerbUpdate, key, oldObj); e != nil {
+		return e
+	}
+	if e := rest.authorize(ctx, authorization.Attributes{Verb: authorization.VerbUpdate, Key: key, Object: obj}); e != nil {
+		return e
+	}
+	if err != nil {
+		return err
+	}
+	// the store compares the resourceVersion in the write transaction, so the object
+	// admitted against oldObj isn't written over a newer one
+	if rv := obj.GetResourceVersion(); rv == "" {
+		if setter, ok := any(obj).(resourceVersionSetter); ok {
+			setter.SetResourceVersion(oldObj.GetResourceVersion())
+		}
+	} else if rv != oldObj.GetResourceVersion() {
+		return errors.NewConflict(fmt.Errorf("the resourceVersion %q of %q is outdated, the latest is %q", rv, key, oldObj.GetResourceVersion()))
+	}
+	if err := rest.doAdmit(ctx, admission.Update, obj, oldObj); err != nil {
 		return err
 	}
 	var storeObj = new(ST)
@@ -194,25 +351,57 @@ func (rest *RestAPI[T, PT, ST]) Update(ctx context.Context, key string, obj PT)
 }
 
 func (rest *RestAPI[T, PT, ST]) Delete(ctx context.Context, key string) error {
-	var obj = PT(new(T))
-	obj.SetKey(key)
-	if err := rest.doAdmit(ctx, admission.Delete, &apis.ObjectMeta{
-		Key: key,
-	}); err != nil {
+	if err := rest.checkDryRun(ctx); err != nil {
+		return err
+	}
+	obj, err := rest.get(ctx, key)
+	if e := rest.authorizeStored(ctx, authorization.VerbDelete, key, obj); e != nil {
+		return e
+	}
+	if err != nil {
+		return err
+	}
+	if err := rest.doAdmit(ctx, admission.Delete, obj, obj); err != nil {
 		return err
 	}
-	return rest.convertStorageError(rest.store.Delete(ctx, key, nil), obj)
+	// the store deletes the object only if it's still the version admitted
+	var storeObj *ST
+	precondition := PT(new(T))
+	if setter, ok := any(precondition).(resourceVersionSetter); ok && obj.GetResourceVersion() != "" {
+		precondition.SetKey(key)
+		setter.SetResourceVersion(obj.GetResourceVersion())
+		storeObj = new(ST)
+		if err := rest.converter.ToStorage(precondition, storeObj); err != nil {
+			return err
+		}
+	}
+	return rest.convertStorageError(rest.store.Delete(ctx, key, storeObj), obj)
 }
 
-func (rest *RestAPI[T, PT, ST]) Watch(ctx context.Context) (Channel, error) {
-	channel, err := rest.store.Watch(ctx)
+func (rest *RestAPI[T, PT, ST]) Watch(ctx context.Context, opts apis.WatchOptions) (Channel, error) {
+	requirements, err := selector.Parse(opts.Selector)
 	if err != nil {
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	if err := rest.authorize(ctx, authorization.Attributes{Verb: authorization.VerbWatch, Key: opts.Key, Selector: opts.Selector}); err != nil {
 		return nil, err
 	}
+	channel, err := rest.store.Watch(ctx, storage.WatchOptions{
+		Key:              opts.Key,
+		Requirements:     requirements,
+		ResourceVersion:  opts.ResourceVersion,
+		BookmarkInterval: opts.BookmarkInterval,
+	})
+	if err != nil {
+		if _, ok := err.(errors.APIStatus); ok {
+			return nil, err
+		}
+		return nil, rest.convertStorageError(err, PT(new(T)))
+	}
 	return NewChannel(channel, rest.scheme, rest.logger, rest.converter), nil
 }
 
 func (rest *RestAPI[T, PT, ST]) Install(container *restful.Container) {
-	handler := NewHandler[T, PT](rest)
+	handler := NewHandler[T, PT](rest, rest.scheme)
 	handler.AddToContainer(container)
 }
@@ -0,0 +1,400 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/admission"
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/authentication"
+	"github.com/sunyakun/gearbox/pkg/authorization"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/storage"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+// memStore is a storage.WatchableStore that keeps the objects in memory
+type memStore struct {
+	objs map[string]foo
+}
+
+func (s *memStore) Get(ctx context.Context, key string) (*foo, error) {
+	obj, ok := s.objs[key]
+	if !ok {
+		return nil, storage.NewNotFoundError("foo", key)
+	}
+	return &obj, nil
+}
+
+func (s *memStore) GetList(ctx context.Context, opts storage.ListOptions) ([]*foo, int64, error) {
+	var objs []*foo
+	for key := range s.objs {
+		obj := s.objs[key]
+		objs = append(objs, &obj)
+	}
+	return objs, int64(len(objs)), nil
+}
+
+func (s *memStore) Create(ctx context.Context, obj *foo) (*foo, error) {
+	if storage.IsDryRun(ctx) {
+		return obj, nil
+	}
+	s.objs[obj.Key] = *obj
+	return obj, nil
+}
+
+func (s *memStore) Update(ctx context.Context, key string, obj *foo) error {
+	stored, ok := s.objs[key]
+	if !ok {
+		return storage.NewNotFoundError("foo", key)
+	}
+	// compare the resourceVersion like the gorm store
+	if obj.ResourceVersion != "" && obj.ResourceVersion != stored.ResourceVersion {
+		return storage.NewConcurrentConclictError()
+	}
+	if storage.IsDryRun(ctx) {
+		return nil
+	}
+	s.objs[key] = *obj
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string, obj *foo) error {
+	stored, ok := s.objs[key]
+	if !ok {
+		return storage.NewNotFoundError("foo", key)
+	}
+	if obj != nil && obj.ResourceVersion != "" && obj.ResourceVersion != stored.ResourceVersion {
+		return storage.NewConcurrentConclictError()
+	}
+	if storage.IsDryRun(ctx) {
+		return nil
+	}
+	delete(s.objs, key)
+	return nil
+}
+
+func (s *memStore) SupportsDryRun() bool {
+	return true
+}
+
+func (s *memStore) ResourceVersion(ctx context.Context) (string, error) {
+	return "", nil
+}
+
+func (s *memStore) Watch(ctx context.Context, opts storage.WatchOptions) (watch.Channel[foo], error) {
+	return nil, nil
+}
+
+type identityConverter struct{}
+
+func (identityConverter) FromStorage(from *foo, to *foo) error {
+	*to = *from
+	return nil
+}
+
+func (identityConverter) ToStorage(from *foo, to *foo) error {
+	*to = *from
+	return nil
+}
+
+// recordAdmission records the attributes of the validations
+type recordAdmission struct {
+	attrs []admission.Attributes
+}
+
+func (a *recordAdmission) Handles(operation admission.Operation) bool { return true }
+
+func (a *recordAdmission) Validate(ctx context.Context, attrs admission.Attributes) error {
+	a.attrs = append(a.attrs, attrs)
+	return nil
+}
+
+func TestRestAPIAdmissionAttributes(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddVersionedTypes(apis.GroupVersion{Group: "apps", Version: "v1"}, &foo{}))
+	admit := &recordAdmission{}
+	api := NewRestAPI[foo, *foo, foo]("foos", &memStore{objs: map[string]foo{}}, scheme, identityConverter{}, logr.Discard(), []admission.Interface{admit})
+	user := &authentication.UserInfo{Name: "alice"}
+	ctx := authentication.WithUser(context.Background(), user)
+
+	_, err := api.Create(ctx, &foo{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"})
+	assert.Nil(t, err)
+	assert.Nil(t, api.Update(storage.WithDryRun(ctx), "foo", &foo{Image: "redis"}))
+	assert.Nil(t, api.Delete(context.Background(), "foo"))
+
+	assert.Len(t, admit.attrs, 3)
+	create, update, del := admit.attrs[0], admit.attrs[1], admit.attrs[2]
+	assert.Nil(t, create.GetOldObject())
+	assert.Equal(t, user, create.GetUserInfo())
+	assert.Equal(t, apis.GroupVersionKind{Group: "apps", Version: "v1", Kind: "foo"}, create.GetKind())
+	assert.Equal(t, "", create.GetSubresource())
+
+	assert.Equal(t, "nginx", update.GetOldObject().(*foo).Image)
+	assert.Equal(t, "redis", update.GetObject().(*foo).Image)
+	assert.True(t, update.IsDryRun())
+
+	assert.Equal(t, "nginx", del.GetOldObject().(*foo).Image)
+	assert.Equal(t, "nginx", del.GetObject().(*foo).Image)
+	assert.Nil(t, del.GetUserInfo())
+}
+
+// imageValidator requires the image of the objects
+type imageValidator struct{}
+
+func (imageValidator) Handles(operation admission.Operation) bool { return true }
+
+func (imageValidator) Validate(ctx context.Context, attrs admission.Attributes) error {
+	obj := attrs.GetObject().(*foo)
+	var errs errors.FieldErrorList
+	if obj.Image == "" {
+		errs = append(errs, errors.FieldRequired(errors.NewFieldPath("image"), ""))
+	}
+	if err := errs.ToStatusError("foo", obj.Key); err != nil {
+		return fmt.Errorf("validate failed: %w", err)
+	}
+	return nil
+}
+
+func TestRestAPIFieldErrors(t *testing.T) {
+	scheme := apis.NewScheme()
+	api := NewRestAPI[foo, *foo, foo]("foos", &memStore{objs: map[string]foo{}}, scheme, identityConverter{}, logr.Discard(), []admission.Interface{imageValidator{}})
+	container := restful.NewContainer()
+	NewHandler[foo, *foo](api, scheme).AddToContainer(container)
+
+	req := httptest.NewRequest(http.MethodPost, "/foos", strings.NewReader(`{"key": "foo"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	var status apis.Status
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, errors.ReasonInvalid, status.Reason)
+	assert.Equal(t, &apis.StatusDetails{
+		Kind: "foo",
+		Key:  "foo",
+		Causes: []apis.StatusCause{
+			{Field: "image", Reason: string(errors.FieldValueRequired), Message: "image: Required value"},
+		},
+	}, status.Details)
+}
+
+func TestHandlerDryRun(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddKnownTypes(&foo{}))
+	store := &memStore{objs: map[string]foo{"foo": {ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"}}}
+	admit := &recordAdmission{}
+	api := NewRestAPI[foo, *foo, foo]("foos", store, scheme, identityConverter{}, logr.Discard(), []admission.Interface{admit})
+	container := restful.NewContainer()
+	NewHandler[foo, *foo](api, scheme).AddToContainer(container)
+	serve := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		container.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(http.MethodPost, "/foos?dryRun=All", `{"key": "bar", "image": "nginx"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"key":"bar"`)
+	w = serve(http.MethodPut, "/foos/foo?dryRun=All", `{"key": "foo", "image": "redis"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(http.MethodDelete, "/foos/foo?dryRun=All", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// nothing is persisted, the admission sees the dry-run
+	assert.Equal(t, map[string]foo{"foo": {ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"}}, store.objs)
+	assert.Len(t, admit.attrs, 3)
+	for _, attrs := range admit.attrs {
+		assert.True(t, attrs.IsDryRun())
+	}
+
+	w = serve(http.MethodPost, "/foos?dryRun=Some", `{"key": "bar", "image": "nginx"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, admit.attrs, 3)
+
+	w = serve(http.MethodPost, "/foos", `{"key": "bar", "image": "nginx"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, admit.attrs[3].IsDryRun())
+	assert.Contains(t, store.objs, "bar")
+}
+
+// plainStore hides the storage.DryRunner of the store
+type plainStore struct {
+	storage.WatchableStore[foo]
+}
+
+func TestRestAPIDryRunUnsupported(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddKnownTypes(&foo{}))
+	store := &memStore{objs: map[string]foo{"foo": {ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"}}}
+	admit := &recordAdmission{}
+	api := NewRestAPI[foo, *foo, foo]("foos", plainStore{store}, scheme, identityConverter{}, logr.Discard(), []admission.Interface{admit})
+	ctx := storage.WithDryRun(context.Background())
+
+	_, err := api.Create(ctx, &foo{ObjectMeta: apis.ObjectMeta{Key: "bar"}})
+	assert.True(t, errors.IsBadRequestError(err))
+	assert.True(t, errors.IsBadRequestError(api.Update(ctx, "foo", &foo{Image: "redis"})))
+	assert.True(t, errors.IsBadRequestError(api.Delete(ctx, "foo")))
+	assert.Len(t, admit.attrs, 0)
+	assert.Len(t, store.objs, 1)
+
+	_, err = api.Create(context.Background(), &foo{ObjectMeta: apis.ObjectMeta{Key: "bar"}})
+	assert.Nil(t, err)
+}
+
+// keyAuthorizer allows the verbs on the keys, the verb of "" key is allowed on all the keys
+type keyAuthorizer struct {
+	allowed map[string][]string
+	attrs   []authorization.Attributes
+}
+
+func (a *keyAuthorizer) Authorize(ctx context.Context, attrs authorization.Attributes) (bool, string, error) {
+	a.attrs = append(a.attrs, attrs)
+	for _, key := range a.allowed[attrs.Verb] {
+		if key == "" || key == attrs.Key {
+			return true, "", nil
+		}
+	}
+	return false, "denied", nil
+}
+
+func TestRestAPIAuthorize(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddVersionedTypes(apis.GroupVersion{Group: "apps", Version: "v1"}, &foo{}))
+	store := &memStore{objs: map[string]foo{"foo": {ObjectMeta: apis.ObjectMeta{Key: "foo"}, Image: "nginx"}}}
+	api := NewRestAPI[foo, *foo, foo]("foos", store, scheme, identityConverter{}, logr.Discard(), nil)
+	authz := &keyAuthorizer{allowed: map[string][]string{
+		authorization.VerbGet:    {"foo", "missing"},
+		authorization.VerbList:   {""},
+		authorization.VerbCreate: {"bar"},
+		authorization.VerbUpdate: {"foo", "missing"},
+		authorization.VerbDelete: {"missing"},
+	}}
+	api.SetAuthorizer(authz)
+	ctx := authentication.WithUser(context.Background(), &authentication.UserInfo{Name: "alice"})
+
+	obj, err := api.Get(ctx, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx", obj.Image)
+	last := authz.attrs[len(authz.attrs)-1]
+	assert.Equal(t, authorization.Attributes{User: &authentication.UserInfo{Name: "alice"}, Verb: "get", Group: "apps", Resource: "foos", Key: "foo", Object: obj}, last)
+
+	_, _, err = api.GetList(ctx, apis.ListOptions{Selector: "image=nginx"})
+	assert.Nil(t, err)
+	assert.Equal(t, "image=nginx", authz.attrs[len(authz.attrs)-1].Selector)
+
+	_, err = api.Watch(ctx, apis.WatchOptions{})
+	assert.True(t, errors.IsForbiddenError(err), err)
+
+	_, err = api.Create(ctx, &foo{ObjectMeta: apis.ObjectMeta{Key: "baz"}})
+	assert.True(t, errors.IsForbiddenError(err), err)
+	assert.NotContains(t, store.objs, "baz")
+	_, err = api.Create(ctx, &foo{ObjectMeta: apis.ObjectMeta{Key: "bar"}})
+	assert.Nil(t, err)
+
+	assert.Nil(t, api.Update(ctx, "foo", &foo{Image: "redis"}))
+	assert.Equal(t, "redis", store.objs["foo"].Image)
+	err = api.Update(ctx, "bar", &foo{Image: "redis"})
+	assert.True(t, errors.IsForbiddenError(err), err)
+
+	err = api.Delete(ctx, "foo")
+	assert.True(t, errors.IsForbiddenError(err), err)
+	assert.Contains(t, store.objs, "foo")
+
+	// the calls without a user are denied, the system user isn't authorized
+	count := len(authz.attrs)
+	err = api.Delete(context.Background(), "foo")
+	assert.True(t, errors.IsUnauthorizedError(err), err)
+	assert.Contains(t, store.objs, "foo")
+	impostor := authentication.WithUser(context.Background(), &authentication.UserInfo{Name: "system:gearbox", Groups: []string{"system:masters"}})
+	err = api.Delete(impostor, "foo")
+	assert.True(t, errors.IsForbiddenError(err), err)
+	assert.Nil(t, api.Delete(authentication.WithSystemUser(context.Background()), "foo"))
+	assert.NotContains(t, store.objs, "foo")
+	assert.Len(t, authz.attrs, count+1)
+}
+
+func TestRestAPIAuthorizeBeforeNotFound(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddKnownTypes(&foo{}))
+	api := NewRestAPI[foo, *foo, foo]("foos", &memStore{objs: map[string]foo{}}, scheme, identityConverter{}, logr.Discard(), nil)
+	api.SetAuthorizer(&keyAuthorizer{allowed: map[string][]string{
+		authorization.VerbGet:    {"missing"},
+		authorization.VerbUpdate: {"missing"},
+		authorization.VerbDelete: {"missing"},
+	}})
+	ctx := authentication.WithUser(context.Background(), &authentication.UserInfo{Name: "alice"})
+
+	// the forbidden users can't tell whether the objects exist
+	_, err := api.Get(ctx, "other")
+	assert.True(t, errors.IsForbiddenError(err), err)
+	err = api.Update(ctx, "other", &foo{})
+	assert.True(t, errors.IsForbiddenError(err), err)
+	err = api.Delete(ctx, "other")
+	assert.True(t, errors.IsForbiddenError(err), err)
+
+	// the allowed users see the objects are missing
+	_, err = api.Get(ctx, "missing")
+	assert.True(t, errors.IsNotFoundError(err), err)
+	err = api.Update(ctx, "missing", &foo{})
+	assert.True(t, errors.IsNotFoundError(err), err)
+	err = api.Delete(ctx, "missing")
+	assert.True(t, errors.IsNotFoundError(err), err)
+}
+
+// racingAdmission updates the stored object while the update is admitted
+type racingAdmission struct {
+	store   *memStore
+	version int
+}
+
+func (a *racingAdmission) Handles(operation admission.Operation) bool {
+	return operation == admission.Update || operation == admission.Delete
+}
+
+func (a *racingAdmission) Validate(ctx context.Context, attrs admission.Attributes) error {
+	a.version++
+	obj := a.store.objs["foo"]
+	obj.Image, obj.ResourceVersion = "racer", fmt.Sprintf("racer-%d", a.version)
+	a.store.objs["foo"] = obj
+	return nil
+}
+
+func TestRestAPIResourceVersion(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddKnownTypes(&foo{}))
+	store := &memStore{objs: map[string]foo{"foo": {ObjectMeta: apis.ObjectMeta{Key: "foo", ResourceVersion: "1"}, Image: "nginx"}}}
+	admit := &recordAdmission{}
+	api := NewRestAPI[foo, *foo, foo]("foos", store, scheme, identityConverter{}, logr.Discard(), []admission.Interface{admit})
+
+	// the object without the resourceVersion is updated on the version admitted
+	assert.Nil(t, api.Update(context.Background(), "foo", &foo{Image: "redis"}))
+	assert.Equal(t, "1", admit.attrs[0].GetObject().(*foo).ResourceVersion)
+	assert.Equal(t, "redis", store.objs["foo"].Image)
+
+	// the outdated resourceVersion is a conflict
+	err := api.Update(context.Background(), "foo", &foo{ObjectMeta: apis.ObjectMeta{ResourceVersion: "0"}, Image: "mysql"})
+	assert.True(t, errors.IsConflictError(err), err)
+	assert.Equal(t, "redis", store.objs["foo"].Image)
+	assert.Len(t, admit.attrs, 1)
+
+	// the object changed after the read isn't written over
+	api = NewRestAPI[foo, *foo, foo]("foos", store, scheme, identityConverter{}, logr.Discard(), []admission.Interface{&racingAdmission{store: store}})
+	err = api.Update(context.Background(), "foo", &foo{Image: "mysql"})
+	assert.True(t, errors.IsConflictError(err), err)
+	assert.Equal(t, "racer", store.objs["foo"].Image)
+	err = api.Delete(context.Background(), "foo")
+	assert.True(t, errors.IsConflictError(err), err)
+	assert.Contains(t, store.objs, "foo")
+}
@@ -0,0 +1,260 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/emicklei/go-restful/v3"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+var _ Resource[*apis.ObjectMeta] = &VersionedAPI[apis.ObjectMeta, *apis.ObjectMeta, apis.ObjectMeta, *apis.ObjectMeta]{}
+
+// VersionedAPI serves a version of the resource backed by the storage version, e.g. the
+// RestAPI. The objects are converted between the versions by the conversion functions
+// registered in the scheme, the group and version are the registration of T by
+// apis.Scheme.AddVersionedTypes.
+type VersionedAPI[T any, PT interface {
+	apis.Object
+	*T
+}, S any, PS interface {
+	apis.Object
+	*S
+}] struct {
+	storage Resource[PS]
+	scheme  *apis.Scheme
+	group   string
+	version string
+}
+
+func NewVersionedAPI[T any, PT interface {
+	apis.Object
+	*T
+}, S any, PS interface {
+	apis.Object
+	*S
+}](storage Resource[PS], scheme *apis.Scheme) (*VersionedAPI[T, PT, S, PS], error) {
+	gvk, err := scheme.ObjectGroupVersionKind(PT(new(T)))
+	if err != nil {
+		return nil, err
+	}
+	if gvk.Version == "" || gvk.Group != storage.Group() {
+		return nil, fmt.Errorf("%q isn't a version of the group %q", gvk, storage.Group())
+	}
+	if gvk.Version == storage.Version() {
+		return nil, fmt.Errorf("the version %q is served by the storage version", gvk.Version)
+	}
+	return &VersionedAPI[T, PT, S, PS]{
+		storage: storage,
+		scheme:  scheme,
+		group:   gvk.Group,
+		version: gvk.Version,
+	}, nil
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Name() string {
+	return api.storage.Name()
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Group() string {
+	return api.group
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Version() string {
+	return api.version
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) StorageVersion() string {
+	return api.storage.Version()
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) ShortNames() []string {
+	if namer, ok := api.storage.(ShortNamer); ok {
+		return namer.ShortNames()
+	}
+	return nil
+}
+
+// toStorage converts the object to the storage version, the failure is a bad request unless
+// the conversion returns a status, e.g. NewInvalid
+func (api *VersionedAPI[T, PT, S, PS]) toStorage(obj PT) (PS, error) {
+	out := PS(new(S))
+	if err := api.scheme.Convert(obj, out); err != nil {
+		if _, ok := errors.FromError(err); ok {
+			return nil, err
+		}
+		return nil, errors.NewBadRequest(err.Error())
+	}
+	return out, nil
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) fromStorage(obj PS) (PT, error) {
+	out := PT(new(T))
+	if err := api.scheme.Convert(obj, out); err != nil {
+		return nil, errors.NewInternalError(err)
+	}
+	return out, nil
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Get(ctx context.Context, key string) (PT, error) {
+	obj, err := api.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return api.fromStorage(obj)
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) GetList(ctx context.Context, opts apis.ListOptions) ([]PT, int64, error) {
+	objs, count, err := api.storage.GetList(ctx, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	outs := make([]PT, 0, len(objs))
+	for _, obj := range objs {
+		out, err := api.fromStorage(obj)
+		if err != nil {
+			return nil, 0, err
+		}
+		outs = append(outs, out)
+	}
+	return outs, count, nil
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) List(ctx context.Context, opts apis.ListOptions) (*apis.ObjectList[PT], error) {
+	list, err := api.storage.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	out := &apis.ObjectList[PT]{Count: list.Count, Continue: list.Continue, ResourceVersion: list.ResourceVersion}
+	for _, obj := range list.Items {
+		converted, err := api.fromStorage(obj)
+		if err != nil {
+			return nil, err
+		}
+		out.Items = append(out.Items, converted)
+	}
+	return out, nil
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Create(ctx context.Context, obj PT) (PT, error) {
+	in, err := api.toStorage(obj)
+	if err != nil {
+		return nil, err
+	}
+	created, err := api.storage.Create(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return api.fromStorage(created)
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Update(ctx context.Context, key string, obj PT) error {
+	in, err := api.toStorage(obj)
+	if err != nil {
+		return err
+	}
+	if err := api.storage.Update(ctx, key, in); err != nil {
+		return err
+	}
+	// the updated object is written back like the storage version
+	updated, err := api.fromStorage(in)
+	if err != nil {
+		return err
+	}
+	*obj = *updated
+	return nil
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Delete(ctx context.Context, key string) error {
+	return api.storage.Delete(ctx, key)
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Watch(ctx context.Context, opts apis.WatchOptions) (Channel, error) {
+	ch, err := api.storage.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &versionedChannel[T, PT, S, PS]{api: api, ch: ch, ctx: ctx, cancel: cancel}, nil
+}
+
+func (api *VersionedAPI[T, PT, S, PS]) Install(container *restful.Container) {
+	NewHandler[T, PT](api, api.scheme).AddToContainer(container)
+}
+
+// versionedChannel converts the objects of the events of the storage version
+type versionedChannel[T any, PT interface {
+	apis.Object
+	*T
+}, S any, PS interface {
+	apis.Object
+	*S
+}] struct {
+	api    *VersionedAPI[T, PT, S, PS]
+	ch     Channel
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	once     sync.Once
+	resultCh chan Event
+	err      error
+}
+
+func (c *versionedChannel[T, PT, S, PS]) Stop() {
+	c.cancel()
+	c.ch.Stop()
+}
+
+func (c *versionedChannel[T, PT, S, PS]) ResultChan() (<-chan Event, error) {
+	c.once.Do(func() {
+		var in <-chan Event
+		in, c.err = c.ch.ResultChan()
+		if c.err != nil {
+			return
+		}
+		c.resultCh = make(chan Event)
+		go c.run(in)
+	})
+	return c.resultCh, c.err
+}
+
+func (c *versionedChannel[T, PT, S, PS]) run(in <-chan Event) {
+	defer close(c.resultCh)
+	for {
+		select {
+		case evt, ok := <-in:
+			if !ok {
+				return
+			}
+			select {
+			case c.resultCh <- c.convert(evt):
+			case <-c.ctx.Done():
+				return
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// convert converts the objects of the event, the failure is delivered as the 500 error event
+func (c *versionedChannel[T, PT, S, PS]) convert(evt Event) Event {
+	var err error
+	if obj, ok := evt.Obj.(PS); ok {
+		if evt.Obj, err = c.api.fromStorage(obj); err != nil {
+			status := err.(errors.StatusError).Status()
+			return Event{Type: watch.EventTypeError, ResourceVersion: evt.ResourceVersion, Err: &status}
+		}
+	}
+	if oldObj, ok := evt.OldObj.(PS); ok {
+		if evt.OldObj, err = c.api.fromStorage(oldObj); err != nil {
+			status := err.(errors.StatusError).Status()
+			return Event{Type: watch.EventTypeError, ResourceVersion: evt.ResourceVersion, Err: &status}
+		}
+	}
+	return evt
+}
@@ -0,0 +1,83 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+// fooV2 is the v2 of foo, the images are split
+type fooV2 struct {
+	apis.ObjectMeta
+	Images []string `json:"images"`
+}
+
+func TestVersionedAPI(t *testing.T) {
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddKnownTypeWithName(apis.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Foo"}, &foo{}))
+	assert.Nil(t, scheme.AddKnownTypeWithName(apis.GroupVersionKind{Group: "apps", Version: "v2", Kind: "Foo"}, &fooV2{}))
+	assert.Nil(t, apis.AddConversion(scheme, func(in *foo, out *fooV2) error {
+		out.ObjectMeta = in.ObjectMeta
+		out.Images = strings.Split(in.Image, ",")
+		return nil
+	}))
+	assert.Nil(t, apis.AddConversion(scheme, func(in *fooV2, out *foo) error {
+		out.ObjectMeta = in.ObjectMeta
+		out.Image = strings.Join(in.Images, ",")
+		return nil
+	}))
+
+	events := make(chan Event, 1)
+	storage := &fakeResource{group: "apps", objs: map[string]*foo{}, watch: func(opts apis.WatchOptions) (Channel, error) {
+		return &fakeChannel{ch: events}, nil
+	}}
+	v2, err := NewVersionedAPI[fooV2, *fooV2, foo, *foo](storage, scheme)
+	assert.Nil(t, err)
+	assert.Equal(t, "v1", v2.StorageVersion())
+	_, err = NewVersionedAPI[foo, *foo, foo, *foo](storage, scheme)
+	assert.NotNil(t, err)
+
+	container := restful.NewContainer()
+	NewHandler[foo, *foo](storage, scheme).AddToContainer(container)
+	v2.Install(container)
+
+	// the v2 object is stored as v1
+	body, _ := json.Marshal(fooV2{ObjectMeta: apis.ObjectMeta{Key: "foo"}, Images: []string{"nginx", "redis"}})
+	req := httptest.NewRequest(http.MethodPost, "/apps/v2/foos", bytes.NewReader(body))
+	req.Header.Set("Content-Type", apis.MIMEJSON)
+	w := httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "nginx,redis", storage.objs["foo"].Image)
+	var out fooV2
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &out))
+	assert.Equal(t, []string{"nginx", "redis"}, out.Images)
+	assert.Equal(t, "apps/v2", out.APIVersion)
+	assert.Equal(t, "Foo", out.Kind)
+
+	req = httptest.NewRequest(http.MethodGet, "/apps/v1/foos/foo", nil)
+	w = httptest.NewRecorder()
+	container.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"Image":"nginx,redis"`)
+
+	// the events are converted
+	ch, err := v2.Watch(context.Background(), apis.WatchOptions{})
+	assert.Nil(t, err)
+	defer ch.Stop()
+	resultCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+	events <- Event{Type: watch.EventTypeCreated, Obj: &foo{ObjectMeta: apis.ObjectMeta{Key: "bar"}, Image: "nginx"}}
+	evt := <-resultCh
+	assert.Equal(t, []string{"nginx"}, evt.Obj.(*fooV2).Images)
+}
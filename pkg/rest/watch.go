@@ -2,12 +2,17 @@ package rest
 
 import (
 	"context"
+	"sync"
 
 	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
 	"github.com/sunyakun/gearbox/pkg/watch"
 	"github.com/go-logr/logr"
 )
 
+// Channel is the watch of the api objects, it follows the termination semantics of
+// watch.Channel: the result channel is closed after the storage channel is closed,
+// Stop stops the storage channel.
 type Channel interface {
 	Stop()
 	ResultChan() (<-chan Event, error)
@@ -15,7 +20,23 @@ type Channel interface {
 
 type Event struct {
 	Type watch.EventType
-	Obj  apis.Object
+	// ResourceVersion is the position of the event in the event stream
+	ResourceVersion string
+	// Obj is the current object, for the Deleted event it's the final state before the delete
+	Obj apis.Object
+	// OldObj is the object before the update, it's set only if Type is watch.EventTypeUpdated
+	OldObj apis.Object
+	// Err is set only if Type is watch.EventTypeError
+	Err *apis.Status
+}
+
+// WatchEvent is the event streamed by the watch endpoint
+type WatchEvent[T any] struct {
+	Type            watch.EventType `json:"type"`
+	ResourceVersion string          `json:"resourceVersion,omitempty"`
+	Object          T               `json:"object,omitempty"`
+	OldObject       T               `json:"oldObject,omitempty"`
+	Status          *apis.Status    `json:"status,omitempty"`
 }
 
 type channel[T any, PT interface {
@@ -28,6 +49,9 @@ type channel[T any, PT interface {
 	logger    logr.Logger
 	converter Converter[PT, ST]
 	scheme    *apis.Scheme
+	once      sync.Once
+	ch        chan Event
+	err       error
 }
 
 func NewChannel[T any, PT interface {
@@ -46,39 +70,86 @@ func NewChannel[T any, PT interface {
 }
 
 func (c *channel[T, PT, ST]) Stop() {
+	c.cancel()
 	c.channel.Stop()
 }
 
-func (c *channel[T, PT, ST]) ResultChan() (<-chan Event, error) {
-	ch := make(chan Event)
-	resultCh, err := c.channel.ResultChan()
-	if err != nil {
-		return nil, err
+// Metrics returns the metrics of the underlying storage channel, it's empty if
+// the storage channel doesn't provide them.
+func (c *channel[T, PT, ST]) Metrics() watch.Metrics {
+	if p, ok := c.channel.(watch.MetricsProvider); ok {
+		return p.Metrics()
 	}
-	go func() {
-		for {
-			select {
-			case evt := <-resultCh:
-				origObj, ok := interface{}(evt.Obj).(*ST)
-				if !ok {
-					c.logger.Error(nil, "receive an unexpected object from the storage channel", "event", evt)
-					continue
-				}
-				var obj = PT(new(T))
-				if err := c.converter.FromStorage(origObj, obj); err != nil {
-					c.logger.Error(err, "convert storage object to api object failed", "storageObject", origObj)
-					continue
-				}
-				kind, err := c.scheme.ObjectKind(obj)
-				if err != nil {
-					c.logger.Error(err, "failed get object kind", "object", obj)
-				}
-				obj.SetKind(kind)
-				ch <- Event{Type: evt.Type, Obj: obj}
-			case <-c.ctx.Done():
+	return watch.Metrics{}
+}
+
+func (c *channel[T, PT, ST]) ResultChan() (<-chan Event, error) {
+	c.once.Do(func() {
+		var resultCh <-chan watch.Event[ST]
+		resultCh, c.err = c.channel.ResultChan()
+		if c.err != nil {
+			return
+		}
+		c.ch = make(chan Event)
+		go c.run(resultCh)
+	})
+	return c.ch, c.err
+}
+
+func (c *channel[T, PT, ST]) run(resultCh <-chan watch.Event[ST]) {
+	defer close(c.ch)
+	for {
+		select {
+		case evt, ok := <-resultCh:
+			if !ok {
 				return
 			}
+			if !c.send(c.convert(evt)) {
+				return
+			}
+		case <-c.ctx.Done():
+			return
 		}
-	}()
-	return ch, nil
+	}
+}
+
+func (c *channel[T, PT, ST]) send(evt Event) bool {
+	select {
+	case c.ch <- evt:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+func (c *channel[T, PT, ST]) convert(evt watch.Event[ST]) Event {
+	switch evt.Type {
+	case watch.EventTypeError, watch.EventTypeBookmark:
+		return Event{Type: evt.Type, ResourceVersion: evt.ResourceVersion, Err: evt.Err}
+	}
+	obj, err := c.convertObject(evt.Obj)
+	if err != nil {
+		status := errors.NewInternalError(err).Status()
+		return Event{Type: watch.EventTypeError, ResourceVersion: evt.ResourceVersion, Err: &status}
+	}
+	result := Event{Type: evt.Type, ResourceVersion: evt.ResourceVersion, Obj: obj}
+	if evt.OldObj != nil {
+		if result.OldObj, err = c.convertObject(evt.OldObj); err != nil {
+			status := errors.NewInternalError(err).Status()
+			return Event{Type: watch.EventTypeError, ResourceVersion: evt.ResourceVersion, Err: &status}
+		}
+	}
+	return result
+}
+
+func (c *channel[T, PT, ST]) convertObject(origObj *ST) (PT, error) {
+	var obj = PT(new(T))
+	if err := c.converter.FromStorage(origObj, obj); err != nil {
+		c.logger.Error(err, "convert storage object to api object failed", "storageObject", origObj)
+		return nil, err
+	}
+	if err := c.scheme.SetTypeMeta(obj); err != nil {
+		c.logger.Error(err, "failed get object kind", "object", obj)
+	}
+	return obj, nil
 }
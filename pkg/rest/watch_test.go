@@ -0,0 +1,64 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+type fakeStorageChannel struct {
+	ch chan watch.Event[fooModel]
+}
+
+func (c *fakeStorageChannel) Stop() {}
+
+func (c *fakeStorageChannel) ResultChan() (<-chan watch.Event[fooModel], error) {
+	return c.ch, nil
+}
+
+func TestChannelTermination(t *testing.T) {
+	converter, err := NewAutoConverter[*foo, fooModel](fooMetaOptions...)
+	assert.Nil(t, err)
+	scheme := apis.NewScheme()
+	assert.Nil(t, scheme.AddKnownTypes(&foo{}))
+
+	storageCh := &fakeStorageChannel{ch: make(chan watch.Event[fooModel], 3)}
+	status := errors.NewServiceUnavailable("disconnected").Status()
+	storageCh.ch <- watch.Event[fooModel]{Type: watch.EventTypeCreated, Obj: &fooModel{Name: "foo", Spec: []byte("{")}}
+	storageCh.ch <- watch.Event[fooModel]{Type: watch.EventTypeCreated, Obj: &fooModel{Name: "bar", Spec: []byte("{}")}}
+	storageCh.ch <- watch.Event[fooModel]{Type: watch.EventTypeError, Err: &status}
+	close(storageCh.ch)
+
+	ch := NewChannel[foo, *foo, fooModel](storageCh, scheme, logr.Discard(), converter)
+	resultCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+
+	var events []Event
+	timeout := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case evt, ok := <-resultCh:
+			if !ok {
+				done = true
+				continue
+			}
+			events = append(events, evt)
+		case <-timeout:
+			t.Fatal("timeout waiting for the channel to be closed")
+		}
+	}
+
+	assert.Len(t, events, 3)
+	// the object can't be converted
+	assert.Equal(t, watch.EventTypeError, events[0].Type)
+	assert.Equal(t, http.StatusInternalServerError, events[0].Err.Code)
+	assert.Equal(t, "bar", events[1].Obj.GetKey())
+	assert.Equal(t, &status, events[2].Err)
+}
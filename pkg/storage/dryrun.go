@@ -0,0 +1,23 @@
+package storage
+
+import "context"
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context marks the writes as dry-run. The store runs all
+// the checks of a write, but persists nothing and publishes no watch event.
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+// IsDryRun returns true if the context is created by WithDryRun
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// DryRunner is implemented by the stores support the dry-run writes. The writes of a context
+// created by WithDryRun are rejected unless the store implements it and returns true.
+type DryRunner interface {
+	SupportsDryRun() bool
+}
@@ -11,6 +11,7 @@ const (
 	ReasonNotFound           = "NotFound"
 	ReasonAlreadyExist       = "AlreadyExist"
 	ReasonConcurrentConflict = "ConfurrentConflict"
+	ReasonInvalidSelector    = "InvalidSelector"
 )
 
 type StatusError struct {
@@ -83,3 +84,24 @@ func IsConcurrentConclictError(err error) bool {
 	}
 	return false
 }
+
+func NewInvalidSelectorError(err error) StatusError {
+	return StatusError{
+		ErrStatus: apis.Status{
+			ObjectMeta: apis.ObjectMeta{Kind: "Status"},
+			Status:     apis.StatusFailure,
+			Code:       http.StatusBadRequest,
+			Reason:     ReasonInvalidSelector,
+			Message:    fmt.Sprintf("invalid selector: %s", err),
+		},
+	}
+}
+
+func IsInvalidSelectorError(err error) bool {
+	if e, ok := err.(StatusError); !ok {
+		return false
+	} else if e.ErrStatus.Reason == ReasonInvalidSelector {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package gorm
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/sunyakun/gearbox/pkg/storage/selector"
+	"github.com/sunyakun/gearbox/pkg/util"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// compareFunc compares the field value with the parsed requirement value, it returns
+// an integer comparing the two values like strings.Compare.
+type compareFunc func(field reflect.Value, value any) int
+
+type requirementMatcher struct {
+	index   []int
+	op      selector.Operator
+	values  []any
+	compare compareFunc
+}
+
+// Matcher evaluates the selector requirements against the gorm models in memory, it follows
+// the semantics of the SQL conditions generated by the Selector.
+type Matcher[GormModelT any] struct {
+	requirements []requirementMatcher
+}
+
+func NewMatcher[GormModelT any](requirements []selector.Requirement, parseToTime func(string) (time.Time, error)) (*Matcher[GormModelT], error) {
+	rt, err := util.ReflectDefinedStruct[GormModelT]()
+	if err != nil {
+		return nil, err
+	}
+	m := &Matcher[GormModelT]{}
+	for _, requirement := range requirements {
+		f, ok := util.GetFieldByGormColumnTag(rt, requirement.Key())
+		if !ok {
+			return nil, NewFieldNotExistError(requirement.Key())
+		}
+		rm, err := newRequirementMatcher(requirement, f, parseToTime)
+		if err != nil {
+			return nil, err
+		}
+		m.requirements = append(m.requirements, rm)
+	}
+	return m, nil
+}
+
+func newRequirementMatcher(requirement selector.Requirement, f reflect.StructField, parseToTime func(string) (time.Time, error)) (requirementMatcher, error) {
+	rm := requirementMatcher{index: f.Index, op: requirement.Operator()}
+	ft := f.Type
+	if ft.Kind() == reflect.Pointer {
+		ft = ft.Elem()
+	}
+
+	var (
+		parse     func(string) (any, error)
+		orderable = true
+	)
+	switch {
+	case ft == timeType:
+		if parseToTime == nil {
+			return rm, fmt.Errorf("don't known how to parse the time of '%s'", requirement.Key())
+		}
+		parse = func(s string) (any, error) { return parseToTime(s) }
+		rm.compare = func(field reflect.Value, value any) int {
+			return field.Interface().(time.Time).Compare(value.(time.Time))
+		}
+	case ft.Kind() == reflect.String:
+		parse = func(s string) (any, error) { return s, nil }
+		rm.compare = func(field reflect.Value, value any) int {
+			return compare(field.String(), value.(string))
+		}
+	case ft.Kind() >= reflect.Int && ft.Kind() <= reflect.Int64:
+		parse = func(s string) (any, error) { return strconv.ParseInt(s, 10, 64) }
+		rm.compare = func(field reflect.Value, value any) int {
+			return compare(field.Int(), value.(int64))
+		}
+	case ft.Kind() >= reflect.Uint && ft.Kind() <= reflect.Uint64:
+		parse = func(s string) (any, error) { return strconv.ParseUint(s, 10, 64) }
+		rm.compare = func(field reflect.Value, value any) int {
+			return compare(field.Uint(), value.(uint64))
+		}
+	case ft.Kind() == reflect.Float32 || ft.Kind() == reflect.Float64:
+		parse = func(s string) (any, error) { return strconv.ParseFloat(s, 64) }
+		rm.compare = func(field reflect.Value, value any) int {
+			return compare(field.Float(), value.(float64))
+		}
+	case ft.Kind() == reflect.Bool:
+		parse = func(s string) (any, error) { return strconv.ParseBool(s) }
+		rm.compare = func(field reflect.Value, value any) int {
+			if field.Bool() == value.(bool) {
+				return 0
+			}
+			return 1
+		}
+		orderable = false
+	default:
+		return rm, fmt.Errorf("don't known how to apply the selector '%s' to the watch", requirement.String())
+	}
+
+	switch rm.op {
+	case selector.Equals, selector.DoubleEquals, selector.NotEquals, selector.In, selector.NotIn:
+	case selector.GreaterThan, selector.LessThan:
+		if !orderable {
+			return rm, fmt.Errorf("the watch don't support operator '%s' for '%s'", rm.op, requirement.Key())
+		}
+	default:
+		return rm, fmt.Errorf("the watch don't support operator '%s' for '%s'", rm.op, requirement.Key())
+	}
+
+	for _, s := range requirement.Values().List() {
+		v, err := parse(s)
+		if err != nil {
+			return rm, err
+		}
+		rm.values = append(rm.values, v)
+	}
+	if len(rm.values) == 0 {
+		return rm, fmt.Errorf("the value can't be empty for operator '%s'", rm.op)
+	}
+	return rm, nil
+}
+
+func compare[T string | int64 | uint64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (rm requirementMatcher) matches(obj reflect.Value) bool {
+	field := obj.FieldByIndex(rm.index)
+	if field.Kind() == reflect.Pointer {
+		// NULL matches nothing in SQL
+		if field.IsNil() {
+			return false
+		}
+		field = field.Elem()
+	}
+	in := false
+	for _, value := range rm.values {
+		if rm.compare(field, value) == 0 {
+			in = true
+			break
+		}
+	}
+	switch rm.op {
+	case selector.Equals, selector.DoubleEquals, selector.In:
+		return in
+	case selector.NotEquals, selector.NotIn:
+		return !in
+	case selector.GreaterThan:
+		return rm.compare(field, rm.values[0]) > 0
+	case selector.LessThan:
+		return rm.compare(field, rm.values[0]) < 0
+	}
+	return false
+}
+
+// Matches returns true if the object satisfies all the requirements
+func (m *Matcher[GormModelT]) Matches(obj *GormModelT) bool {
+	rv := reflect.ValueOf(obj).Elem()
+	for _, rm := range m.requirements {
+		if !rm.matches(rv) {
+			return false
+		}
+	}
+	return true
+}
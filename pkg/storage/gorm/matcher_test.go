@@ -0,0 +1,56 @@
+package gorm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/storage/selector"
+)
+
+type matcherModel struct {
+	Name     string     `gorm:"column:name"`
+	Replicas int32      `gorm:"column:replicas"`
+	Enabled  bool       `gorm:"column:enabled"`
+	Started  *time.Time `gorm:"column:started"`
+}
+
+func TestMatcher(t *testing.T) {
+	started := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	obj := &matcherModel{Name: "foo", Replicas: 3, Enabled: true, Started: &started}
+	parseToTime := func(s string) (time.Time, error) { return time.Parse("2006-01-02", s) }
+
+	for expr, expected := range map[string]bool{
+		"name=foo":                           true,
+		"name!=foo":                          false,
+		"name in (bar,foo)":                  true,
+		"name notin (bar,foo)":               false,
+		"replicas>2,replicas<4":              true,
+		"replicas>3":                         false,
+		"enabled=true":                       true,
+		"started=2023-05-01":                 true,
+		"started in (2023-05-02)":            false,
+		"name=foo,enabled=false":             false,
+		"name in (foo),replicas notin (1,2)": true,
+	} {
+		requirements, err := selector.Parse(expr)
+		assert.Nil(t, err, expr)
+		m, err := NewMatcher[matcherModel](requirements, parseToTime)
+		assert.Nil(t, err, expr)
+		assert.Equal(t, expected, m.Matches(obj), expr)
+	}
+
+	// NULL matches nothing
+	requirements, _ := selector.Parse("started!=2023-05-01")
+	m, err := NewMatcher[matcherModel](requirements, parseToTime)
+	assert.Nil(t, err)
+	assert.False(t, m.Matches(&matcherModel{}))
+
+	for _, expr := range []string{"missing=1", "replicas=x", "enabled>1", "name"} {
+		requirements, err := selector.Parse(expr)
+		assert.Nil(t, err, expr)
+		_, err = NewMatcher[matcherModel](requirements, parseToTime)
+		assert.NotNil(t, err, expr)
+	}
+}
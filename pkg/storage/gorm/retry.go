@@ -0,0 +1,100 @@
+package gorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"github.com/sunyakun/gearbox/pkg/storage"
+)
+
+const (
+	defaultInitialBackoff = 10 * time.Millisecond
+	defaultMaxBackoff     = time.Second
+)
+
+// RetryPolicy describes how the store retries a transaction failed with a transient error.
+// <MaxAttempts> is the total number of attempts, zero or one disables the retry.
+// <InitialBackoff> and <MaxBackoff> bound the exponential backoff between two attempts,
+// default to 10ms and 1s.
+// <Jitter> is the ratio in [0, 1] of the random noise added to each backoff.
+// <Retryable> classify the errors, DefaultRetryable is used if it is nil. It must return true
+// only for the errors that leave nothing committed, see DefaultRetryable. Whatever it returns,
+// the storage errors (e.g. NotFound, AlreadyExist, ConcurrentConflict) are never retried.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Jitter         float64
+	Retryable      func(error) bool
+}
+
+// DefaultRetryable returns true only for the errors known to leave nothing committed: the
+// MySQL deadlock (1213) and lock wait timeout (1205) roll the transaction back, and the drivers
+// return driver.ErrBadConn only if the statement wasn't sent. The other broken connection
+// errors, e.g. ECONNRESET and EPIPE, may arrive after the COMMIT was sent, retrying them could
+// write twice, e.g. a committed Create would be retried as AlreadyExist.
+func DefaultRetryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+	}
+	return errors.Is(err, driver.ErrBadConn)
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	var statusErr storage.StatusError
+	if errors.As(err, &statusErr) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return DefaultRetryable(err)
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	initial, max := p.InitialBackoff, p.MaxBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+	d := initial
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if p.Jitter > 0 {
+		d += time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// Do calls fn until it succeeds, returns a non-retryable error or the attempts are exhausted.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
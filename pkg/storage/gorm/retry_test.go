@@ -0,0 +1,73 @@
+package gorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/storage"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Jitter: 0.5}
+
+	for _, c := range []struct {
+		err      error
+		attempts int
+	}{
+		{err: &mysql.MySQLError{Number: 1213}, attempts: 3},
+		{err: fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1205}), attempts: 3},
+		{err: driver.ErrBadConn, attempts: 3},
+		// the COMMIT may have been sent before the connection broke
+		{err: syscall.ECONNRESET, attempts: 1},
+		{err: syscall.EPIPE, attempts: 1},
+		{err: io.ErrUnexpectedEOF, attempts: 1},
+		{err: mysql.ErrInvalidConn, attempts: 1},
+		{err: &mysql.MySQLError{Number: 1062}, attempts: 1},
+		{err: storage.NewNotFoundError("Foo", "foo"), attempts: 1},
+		{err: storage.NewConcurrentConclictError(), attempts: 1},
+		{err: nil, attempts: 1},
+	} {
+		var attempts int
+		err := policy.Do(context.Background(), func() error {
+			attempts++
+			return c.err
+		})
+		assert.Equal(t, c.err, err)
+		assert.Equal(t, c.attempts, attempts, "%v", c.err)
+	}
+
+	var attempts int
+	err := policy.Do(context.Background(), func() error {
+		attempts++
+		if attempts < 2 {
+			return driver.ErrBadConn
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+
+	custom := RetryPolicy{MaxAttempts: 3, Retryable: func(error) bool { return true }}
+	attempts = 0
+	_ = custom.Do(context.Background(), func() error {
+		attempts++
+		return storage.NewAlreadyExistError("Foo", "foo")
+	})
+	assert.Equal(t, 1, attempts)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy := RetryPolicy{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, policy.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, policy.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, policy.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, policy.backoff(4))
+}
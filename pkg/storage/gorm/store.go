@@ -12,12 +12,19 @@ import (
 	"gorm.io/gen"
 	"gorm.io/gorm"
 
+	"github.com/sunyakun/gearbox/pkg/apis"
 	"github.com/sunyakun/gearbox/pkg/storage"
 	"github.com/sunyakun/gearbox/pkg/util"
 	"github.com/sunyakun/gearbox/pkg/watch"
 )
 
-var _ storage.WatchableStore[any] = &store[any, any]{}
+var (
+	_ storage.WatchableStore[any] = &store[any, any]{}
+	_ storage.DryRunner           = &store[any, any]{}
+)
+
+// errDryRun is returned in the transaction of a dry-run write to roll it back
+var errDryRun = errors.New("dry-run")
 
 // Config used to construct store.
 // <keyFieldName> should be the unique key used to select the object from the underlying SQL database.
@@ -25,11 +32,34 @@ var _ storage.WatchableStore[any] = &store[any, any]{}
 // If this field is empty, concurrent update and delete operations will be unsafe.
 // <FieldGetter> can be obtained from the gorm/gen generated code.
 // <ParseToTime> is used to convert the string-formatted time to time.Time{}.
+// <Retry> is applied to the whole transaction of Create, Update and Delete.
+// <PubSub> is the watermill PubSub used to publish the watch events, it is owned by the caller
+// and can be shared by stores. If it is nil, every store creates its own in-process PubSub.
+// <Topic> is the topic of the watch events, see watch.Config for the default.
+// <WatchBufferSize> and <WatchOverflowPolicy> control how the events are buffered for each watch,
+// see watch.Config for the defaults.
+// <Scheme> and <WatchContentType> select the codec of the watch event payloads from the codecs
+// registered in the Scheme, see watch.Config for the defaults.
+// <WatchHistorySize> is the number of the latest events kept to resume the watches, see watch.Config.
 type Config struct {
-	KeyColumnName      string
-	RevisionColumnName string
-	FieldGetter        FieldGetter
-	ParseToTime        func(string) (time.Time, error)
+	KeyColumnName       string
+	RevisionColumnName  string
+	FieldGetter         FieldGetter
+	ParseToTime         func(string) (time.Time, error)
+	Retry               RetryPolicy
+	PubSub              watch.PubSub
+	Topic               string
+	WatchBufferSize     int
+	WatchOverflowPolicy watch.OverflowPolicy
+	Scheme              *apis.Scheme
+	WatchContentType    string
+	WatchHistorySize    int
+}
+
+// connPoolReplacer is implemented by the gorm/gen generated DO, it's used to
+// bind the DO to a transaction.
+type connPoolReplacer interface {
+	ReplaceConnPool(pool gorm.ConnPool)
 }
 
 type store[GormModelT, GenDoT any] struct {
@@ -43,7 +73,9 @@ type store[GormModelT, GenDoT any] struct {
 	rvFieldOffset  uintptr
 	pubwatcher     watch.EventPubWatcher[GormModelT]
 	selector       *Selector
+	parseToTime    func(string) (time.Time, error)
 	fieldGetter    FieldGetter
+	retry          RetryPolicy
 	onUpdate       []func(oldObj *GormModelT, newObj *GormModelT)
 	onCreate       []func(*GormModelT)
 }
@@ -57,7 +89,15 @@ func New[GormModelT, GenDoT any](db *gorm.DB, daoGetter func(context.Context) Ge
 		return nil, err
 	}
 
-	pubwatcher, err := watch.NewPubSub[GormModelT]()
+	pubwatcher, err := watch.NewPubSub[GormModelT](watch.Config{
+		PubSub:         cfg.PubSub,
+		Topic:          cfg.Topic,
+		BufferSize:     cfg.WatchBufferSize,
+		OverflowPolicy: cfg.WatchOverflowPolicy,
+		Scheme:         cfg.Scheme,
+		ContentType:    cfg.WatchContentType,
+		HistorySize:    cfg.WatchHistorySize,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -79,8 +119,10 @@ func New[GormModelT, GenDoT any](db *gorm.DB, daoGetter func(context.Context) Ge
 		rvFieldName:    cfg.RevisionColumnName,
 		pubwatcher:     pubwatcher,
 		selector:       NewSelector(cfg.FieldGetter, cfg.ParseToTime),
+		parseToTime:    cfg.ParseToTime,
 		keyFieldOffset: keyField.Offset,
 		fieldGetter:    cfg.FieldGetter,
+		retry:          cfg.Retry,
 	}
 
 	if cfg.RevisionColumnName != "" {
@@ -97,17 +139,63 @@ func New[GormModelT, GenDoT any](db *gorm.DB, daoGetter func(context.Context) Ge
 	return s, nil
 }
 
+// AddOnUpdateHandler adds the handler called before an object is updated in the write
+// transaction, the dry-run writes call it too.
 func (s *store[GormModelT, GenDoT]) AddOnUpdateHandler(handler func(*GormModelT, *GormModelT)) {
 	s.onUpdate = append(s.onUpdate, handler)
 }
 
+// AddOnCreateHandler adds the handler called before an object is created in the write
+// transaction, the dry-run writes call it too.
 func (s *store[GormModelT, GenDoT]) AddOnCreateHandler(handler func(*GormModelT)) {
 	s.onCreate = append(s.onCreate, handler)
 }
 
+// newDao create a Dao, the operations of the Dao will be executed in the transaction if tx is not nil.
+// It fails if the DO can't be bound to tx, otherwise the dry-run and the retried writes would
+// run outside the transaction.
+func (s *store[GormModelT, GenDoT]) newDao(ctx context.Context, tx *gorm.DB) (*Dao[GormModelT, GenDoT], error) {
+	genDo := s.genDaoGetter(ctx)
+	if tx != nil {
+		replacer, ok := interface{}(genDo).(connPoolReplacer)
+		if !ok {
+			return nil, NewNotImplementError("connPoolReplacer")
+		}
+		replacer.ReplaceConnPool(tx.Statement.ConnPool)
+	}
+	return NewDao[GormModelT](genDo, s.fieldGetter)
+}
+
+// SupportsDryRun returns true, the dry-run writes run in the transactions rolled back
+func (s *store[GormModelT, GenDoT]) SupportsDryRun() bool {
+	return true
+}
+
+// transaction run fn in a transaction, the whole transaction is retried on transient errors.
+// The transaction is rolled back if fn returns errDryRun.
+func (s *store[GormModelT, GenDoT]) transaction(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	return s.retry.Do(ctx, func() error {
+		err := s.db.WithContext(ctx).Transaction(fn)
+		if errors.Is(err, errDryRun) {
+			return nil
+		}
+		return err
+	})
+}
+
+// publish publishes the event of a committed write, it's called after the transaction so that
+// the retried attempts and the dry-run writes aren't published. The write stays committed even
+// if the publish fails.
+func (s *store[GormModelT, GenDoT]) publish(ctx context.Context, eventType watch.EventType, obj, oldObj *GormModelT) error {
+	if storage.IsDryRun(ctx) {
+		return nil
+	}
+	return s.pubwatcher.Publish(ctx, eventType, obj, oldObj)
+}
+
 func (s *store[GormModelT, GenDoT]) Get(ctx context.Context, key string) (out *GormModelT, err error) {
 	err = s.db.Transaction(func(tx *gorm.DB) error {
-		dao, err := NewDao[GormModelT](s.genDaoGetter(ctx), s.fieldGetter)
+		dao, err := s.newDao(ctx, tx)
 		if err != nil {
 			return err
 		}
@@ -130,7 +218,7 @@ func (s *store[GormModelT, GenDoT]) GetList(ctx context.Context, opts storage.Li
 	if err != nil {
 		return nil, 0, err
 	}
-	dao, err := NewDao[GormModelT](s.genDaoGetter(ctx), s.fieldGetter)
+	dao, err := s.newDao(ctx, nil)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -138,14 +226,14 @@ func (s *store[GormModelT, GenDoT]) GetList(ctx context.Context, opts storage.Li
 }
 
 func (s *store[GormModelT, GenDoT]) Create(ctx context.Context, obj *GormModelT) (out *GormModelT, err error) {
-	for _, handler := range s.onCreate {
-		handler(obj)
-	}
-	err = s.db.Transaction(func(tx *gorm.DB) error {
-		dao, err := NewDao[GormModelT](s.genDaoGetter(ctx), s.fieldGetter)
+	err = s.transaction(ctx, func(tx *gorm.DB) error {
+		dao, err := s.newDao(ctx, tx)
 		if err != nil {
 			return err
 		}
+		for _, handler := range s.onCreate {
+			handler(obj)
+		}
 
 		if s.rvFieldName != "" {
 			util.SetStringField(obj, s.rvFieldOffset, "1")
@@ -159,9 +247,15 @@ func (s *store[GormModelT, GenDoT]) Create(ctx context.Context, obj *GormModelT)
 			return err
 		}
 		out = obj
-		return s.pubwatcher.Publish(ctx, watch.EventTypeCreated, out)
+		if storage.IsDryRun(ctx) {
+			return errDryRun
+		}
+		return nil
 	})
-	return
+	if err != nil {
+		return nil, err
+	}
+	return out, s.publish(ctx, watch.EventTypeCreated, out, nil)
 }
 
 func (s *store[GormModelT, GenDoT]) modify(ctx context.Context, dao *Dao[GormModelT, GenDoT], key string, obj *GormModelT, opFn func(dao *Dao[GormModelT, GenDoT], obj *GormModelT) (gen.ResultInfo, error)) (err error) {
@@ -208,13 +302,22 @@ func (s *store[GormModelT, GenDoT]) modify(ctx context.Context, dao *Dao[GormMod
 }
 
 func (s *store[GormModelT, GenDoT]) Update(ctx context.Context, key string, obj *GormModelT) (err error) {
-	err = s.db.Transaction(func(tx *gorm.DB) error {
+	var rvInReq string
+	if s.rvFieldName != "" {
+		rvInReq = util.GetStringField(obj, s.rvFieldOffset)
+	}
+	var oldObj *GormModelT
+	err = s.transaction(ctx, func(tx *gorm.DB) error {
 		var resourceVersion = "0"
-		dao, err := NewDao[GormModelT](s.genDaoGetter(ctx), s.fieldGetter)
+		if s.rvFieldName != "" {
+			// the revision may be increased by a failed attempt
+			util.SetStringField(obj, s.rvFieldOffset, rvInReq)
+		}
+		dao, err := s.newDao(ctx, tx)
 		if err != nil {
 			return err
 		}
-		oldObj, err := dao.WithEqual(s.keyFieldName, key).First()
+		oldObj, err = s.first(dao, key)
 		if err != nil {
 			return err
 		}
@@ -252,21 +355,40 @@ func (s *store[GormModelT, GenDoT]) Update(ctx context.Context, key string, obj
 			return err
 		}
 
-		return s.pubwatcher.Publish(ctx, watch.EventTypeUpdated, obj)
+		if storage.IsDryRun(ctx) {
+			return errDryRun
+		}
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+	return s.publish(ctx, watch.EventTypeUpdated, obj, oldObj)
+}
 
-	return
+// first returns the object specified by key in the transaction of <dao>
+func (s *store[GormModelT, GenDoT]) first(dao *Dao[GormModelT, GenDoT], key string) (*GormModelT, error) {
+	obj, err := dao.WithEqual(s.keyFieldName, key).First()
+	if err != nil && errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, storage.NewNotFoundError(s.typeName, key)
+	}
+	return obj, err
 }
 
 // Delete remove the object specified by key. If the key don't exists, it will
-// return NotFound error
+// return NotFound error. The Deleted event carries the final state of the object.
 func (s *store[GormModelT, GenDoT]) Delete(ctx context.Context, key string, obj *GormModelT) (err error) {
 	if obj == nil {
 		obj = new(GormModelT)
 	}
 	util.SetStringField(obj, s.keyFieldOffset, key)
-	err = s.db.Transaction(func(tx *gorm.DB) error {
-		dao, err := NewDao[GormModelT](s.genDaoGetter(ctx), s.fieldGetter)
+	var finalObj *GormModelT
+	err = s.transaction(ctx, func(tx *gorm.DB) error {
+		dao, err := s.newDao(ctx, tx)
+		if err != nil {
+			return err
+		}
+		finalObj, err = s.first(dao, key)
 		if err != nil {
 			return err
 		}
@@ -276,11 +398,43 @@ func (s *store[GormModelT, GenDoT]) Delete(ctx context.Context, key string, obj
 		}); err != nil {
 			return err
 		}
-		return s.pubwatcher.Publish(ctx, watch.EventTypeDeleted, obj)
+		if storage.IsDryRun(ctx) {
+			return errDryRun
+		}
+		return nil
 	})
-	return
+	if err != nil {
+		return err
+	}
+	return s.publish(ctx, watch.EventTypeDeleted, finalObj, nil)
+}
+
+func (s *store[GormModelT, GenDoT]) Watch(ctx context.Context, opts storage.WatchOptions) (watch.Channel[GormModelT], error) {
+	watchOpts := watch.Options[GormModelT]{
+		ResourceVersion:  opts.ResourceVersion,
+		BookmarkInterval: opts.BookmarkInterval,
+	}
+	if opts.Key != "" || len(opts.Requirements) != 0 {
+		matcher, err := NewMatcher[GormModelT](opts.Requirements, s.parseToTime)
+		if err != nil {
+			return nil, storage.NewInvalidSelectorError(err)
+		}
+		watchOpts.Filter = func(obj *GormModelT) bool {
+			if opts.Key != "" && util.GetStringField(obj, s.keyFieldOffset) != opts.Key {
+				return false
+			}
+			return matcher.Matches(obj)
+		}
+	}
+	return s.pubwatcher.Watch(ctx, watchOpts)
+}
+
+// Close stops publishing the watch events of the store and ends its outstanding watches, the
+// other stores sharing the PubSub are not affected
+func (s *store[GormModelT, GenDoT]) Close() error {
+	return s.pubwatcher.Close()
 }
 
-func (s *store[GormModelT, GenDoT]) Watch(ctx context.Context) (watch.Channel[GormModelT], error) {
-	return s.pubwatcher.Watch(ctx)
+func (s *store[GormModelT, GenDoT]) ResourceVersion(ctx context.Context) (string, error) {
+	return s.pubwatcher.ResourceVersion(), nil
 }
@@ -0,0 +1,334 @@
+package gorm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gen"
+	"gorm.io/gen/field"
+	"gorm.io/gorm"
+
+	"github.com/sunyakun/gearbox/pkg/storage"
+	"github.com/sunyakun/gearbox/pkg/watch"
+)
+
+type fooModel struct {
+	ID    uint   `gorm:"column:id;primaryKey"`
+	Key   string `gorm:"column:key;uniqueIndex"`
+	Rv    string `gorm:"column:rv"`
+	Image string `gorm:"column:image"`
+}
+
+// fooDo is the DO generated by gorm/gen for fooModel, only the methods used by the store are kept
+type fooDo struct{ gen.DO }
+
+func (f fooDo) withDO(do gen.Dao) *fooDo {
+	f.DO = *do.(*gen.DO)
+	return &f
+}
+
+func (f fooDo) Where(conds ...gen.Condition) *fooDo { return f.withDO(f.DO.Where(conds...)) }
+
+func (f fooDo) Select(conds ...field.Expr) *fooDo { return f.withDO(f.DO.Select(conds...)) }
+
+func (f fooDo) Returning(value interface{}, columns ...string) *fooDo {
+	return f.withDO(f.DO.Returning(value, columns...))
+}
+
+func (f fooDo) Offset(offset int) *fooDo { return f.withDO(f.DO.Offset(offset)) }
+
+func (f fooDo) Limit(limit int) *fooDo { return f.withDO(f.DO.Limit(limit)) }
+
+func (f fooDo) Create(values ...*fooModel) error {
+	if len(values) == 0 {
+		return nil
+	}
+	return f.DO.Create(values)
+}
+
+func (f fooDo) First() (*fooModel, error) {
+	result, err := f.DO.First()
+	if err != nil {
+		return nil, err
+	}
+	return result.(*fooModel), nil
+}
+
+func (f fooDo) Find() ([]*fooModel, error) {
+	result, err := f.DO.Find()
+	return result.([]*fooModel), err
+}
+
+func (f fooDo) FindByPage(offset int, limit int) (result []*fooModel, count int64, err error) {
+	count, err = f.Count()
+	if err != nil {
+		return
+	}
+	result, err = f.Offset(offset).Limit(limit).Find()
+	return
+}
+
+func (f fooDo) Delete(models ...*fooModel) (result gen.ResultInfo, err error) {
+	return f.DO.Delete(models)
+}
+
+type fooFields map[string]field.OrderExpr
+
+func (f fooFields) GetFieldByName(name string) (field.OrderExpr, bool) {
+	expr, ok := f[name]
+	return expr, ok
+}
+
+var errCommit = errors.New("commit failed")
+
+// faultyDriver is the sqlite driver that fails the next <commitFailures> commits after rolling them back
+type faultyDriver struct {
+	sqlite3.SQLiteDriver
+	commitFailures atomic.Int32
+}
+
+type faultyConn struct {
+	driver.Conn
+	drv *faultyDriver
+}
+
+type faultyTx struct {
+	driver.Tx
+	drv *faultyDriver
+}
+
+func (d *faultyDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyConn{Conn: conn, drv: d}, nil
+}
+
+func (c *faultyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	tx, err := c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyTx{Tx: tx, drv: c.drv}, nil
+}
+
+func (tx *faultyTx) Commit() error {
+	if tx.drv.commitFailures.Add(-1) >= 0 {
+		_ = tx.Tx.Rollback()
+		return errCommit
+	}
+	return tx.Tx.Commit()
+}
+
+var driverSeq atomic.Int32
+
+// newTestStore creates the store of fooModel backed by a sqlite database
+func newTestStore(t *testing.T, cfg Config) (*store[fooModel, *fooDo], *faultyDriver) {
+	drv := &faultyDriver{}
+	name := fmt.Sprintf("sqlite3-faulty-%d", driverSeq.Add(1))
+	sql.Register(name, drv)
+	db, err := gorm.Open(sqlite.Dialector{DriverName: name, DSN: filepath.Join(t.TempDir(), "test.db")}, &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&fooModel{}))
+
+	fields := fooFields{
+		"id":    field.NewUint("", "id"),
+		"key":   field.NewString("", "key"),
+		"rv":    field.NewString("", "rv"),
+		"image": field.NewString("", "image"),
+	}
+	cfg.KeyColumnName = "key"
+	if cfg.FieldGetter == nil {
+		cfg.FieldGetter = fields
+	}
+	s, err := New[fooModel](db, func(ctx context.Context) *fooDo {
+		do := &fooDo{}
+		do.UseDB(db.WithContext(ctx))
+		do.UseModel(&fooModel{})
+		return do
+	}, cfg)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	return s, drv
+}
+
+func TestStoreRetry(t *testing.T) {
+	s, drv := newTestStore(t, Config{
+		RevisionColumnName: "rv",
+		Retry: RetryPolicy{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+			Retryable:      func(err error) bool { return errors.Is(err, errCommit) },
+		},
+	})
+	ctx := context.Background()
+	ch, err := s.Watch(ctx, storage.WatchOptions{})
+	assert.Nil(t, err)
+	defer ch.Stop()
+	events, err := ch.ResultChan()
+	assert.Nil(t, err)
+
+	// every write fails to commit once and is retried
+	drv.commitFailures.Store(1)
+	_, err = s.Create(ctx, &fooModel{Key: "foo", Image: "nginx"})
+	assert.Nil(t, err)
+	drv.commitFailures.Store(1)
+	assert.Nil(t, s.Update(ctx, "foo", &fooModel{Key: "foo", Rv: "1", Image: "redis"}))
+	obj, err := s.Get(ctx, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "redis", obj.Image)
+	assert.Equal(t, "2", obj.Rv)
+	drv.commitFailures.Store(1)
+	assert.Nil(t, s.Delete(ctx, "foo", nil))
+
+	// the events are published once, after the commit
+	for _, eventType := range []watch.EventType{watch.EventTypeCreated, watch.EventTypeUpdated, watch.EventTypeDeleted} {
+		select {
+		case evt := <-events:
+			assert.Equal(t, eventType, evt.Type)
+			assert.Equal(t, "foo", evt.Obj.Key)
+		case <-time.After(time.Second):
+			t.Fatalf("the %s event isn't published", eventType)
+		}
+	}
+	select {
+	case evt := <-events:
+		t.Fatalf("unexpected %s event", evt.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the attempts are exhausted
+	drv.commitFailures.Store(3)
+	_, err = s.Create(ctx, &fooModel{Key: "bar"})
+	assert.ErrorIs(t, err, errCommit)
+	_, err = s.Get(ctx, "bar")
+	assert.True(t, storage.IsNotFoundError(err))
+}
+
+func TestStoreDryRun(t *testing.T) {
+	s, _ := newTestStore(t, Config{RevisionColumnName: "rv"})
+	var created, updated int
+	s.AddOnCreateHandler(func(*fooModel) { created++ })
+	s.AddOnUpdateHandler(func(*fooModel, *fooModel) { updated++ })
+	ctx := context.Background()
+	ch, err := s.Watch(ctx, storage.WatchOptions{})
+	assert.Nil(t, err)
+	defer ch.Stop()
+	events, err := ch.ResultChan()
+	assert.Nil(t, err)
+	_, err = s.Create(ctx, &fooModel{Key: "foo", Image: "nginx"})
+	assert.Nil(t, err)
+	select {
+	case evt := <-events:
+		assert.Equal(t, watch.EventTypeCreated, evt.Type)
+	case <-time.After(time.Second):
+		t.Fatal("the Created event isn't published")
+	}
+
+	dryRun := storage.WithDryRun(ctx)
+	out, err := s.Create(dryRun, &fooModel{Key: "bar", Image: "nginx"})
+	assert.Nil(t, err)
+	assert.Equal(t, "1", out.Rv)
+	assert.Nil(t, s.Update(dryRun, "foo", &fooModel{Key: "foo", Rv: "1", Image: "redis"}))
+	assert.Nil(t, s.Delete(dryRun, "foo", nil))
+
+	// the checks run in the rolled back transaction
+	_, err = s.Create(dryRun, &fooModel{Key: "foo"})
+	assert.NotNil(t, err)
+	err = s.Update(dryRun, "foo", &fooModel{Key: "foo", Rv: "2", Image: "redis"})
+	assert.True(t, storage.IsConcurrentConclictError(err))
+	assert.True(t, storage.IsNotFoundError(s.Delete(dryRun, "bar", nil)))
+
+	_, err = s.Get(ctx, "bar")
+	assert.True(t, storage.IsNotFoundError(err))
+	obj, err := s.Get(ctx, "foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx", obj.Image)
+	assert.Equal(t, "1", obj.Rv)
+	// the handlers run in the rolled back transactions too
+	assert.Equal(t, 3, created)
+	assert.Equal(t, 2, updated)
+	select {
+	case evt := <-events:
+		t.Fatalf("unexpected %s event", evt.Type)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStoreDeletePrecondition(t *testing.T) {
+	s, _ := newTestStore(t, Config{RevisionColumnName: "rv"})
+	ctx := context.Background()
+	_, err := s.Create(ctx, &fooModel{Key: "foo", Image: "nginx"})
+	assert.Nil(t, err)
+
+	// the object is deleted only if it's still the version of the precondition
+	err = s.Delete(ctx, "foo", &fooModel{Rv: "0"})
+	assert.True(t, storage.IsConcurrentConclictError(err), err)
+	_, err = s.Get(ctx, "foo")
+	assert.Nil(t, err)
+	assert.Nil(t, s.Delete(ctx, "foo", &fooModel{Rv: "1"}))
+	_, err = s.Get(ctx, "foo")
+	assert.True(t, storage.IsNotFoundError(err))
+}
+
+// unboundDo is a DO that can't be bound to a transaction, its operations run on the db
+type unboundDo struct{ do *fooDo }
+
+func (u unboundDo) Where(conds ...gen.Condition) unboundDo { return unboundDo{u.do.Where(conds...)} }
+
+func (u unboundDo) Returning(value interface{}, columns ...string) unboundDo {
+	return unboundDo{u.do.Returning(value, columns...)}
+}
+
+func (u unboundDo) Select(conds ...field.Expr) unboundDo { return unboundDo{u.do.Select(conds...)} }
+
+func (u unboundDo) TableName() string { return u.do.TableName() }
+
+func (u unboundDo) Create(values ...*fooModel) error { return u.do.Create(values...) }
+
+func (u unboundDo) First() (*fooModel, error) { return u.do.First() }
+
+func (u unboundDo) Find() ([]*fooModel, error) { return u.do.Find() }
+
+func (u unboundDo) FindByPage(offset int, limit int) ([]*fooModel, int64, error) {
+	return u.do.FindByPage(offset, limit)
+}
+
+func (u unboundDo) Updates(obj interface{}) (gen.ResultInfo, error) { return u.do.Updates(obj) }
+
+func (u unboundDo) Delete(models ...*fooModel) (gen.ResultInfo, error) { return u.do.Delete(models...) }
+
+func TestStoreUnboundDao(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.AutoMigrate(&fooModel{}))
+	s, err := New[fooModel](db, func(ctx context.Context) unboundDo {
+		do := &fooDo{}
+		do.UseDB(db.WithContext(ctx))
+		do.UseModel(&fooModel{})
+		return unboundDo{do}
+	}, Config{
+		KeyColumnName: "key",
+		FieldGetter:   fooFields{},
+	})
+	assert.Nil(t, err)
+	defer s.Close()
+
+	// the dry-run write would be committed if it ran outside the transaction
+	_, err = s.Create(storage.WithDryRun(context.Background()), &fooModel{Key: "foo"})
+	assert.ErrorContains(t, err, "connPoolReplacer")
+	var count int64
+	assert.Nil(t, db.Model(&fooModel{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"time"
 
 	"github.com/sunyakun/gearbox/pkg/storage/selector"
 	"github.com/sunyakun/gearbox/pkg/watch"
@@ -13,6 +14,16 @@ type ListOptions struct {
 	Requirements []selector.Requirement
 }
 
+// WatchOptions selects the objects of a watch, the <Requirements> follow the semantics of
+// ListOptions and <Key> selects a single object if it's not empty.
+// <ResourceVersion> and <BookmarkInterval> are passed to watch.Options.
+type WatchOptions struct {
+	Key              string
+	Requirements     []selector.Requirement
+	ResourceVersion  string
+	BookmarkInterval time.Duration
+}
+
 type Store[T any] interface {
 	Get(ctx context.Context, key string) (*T, error)
 
@@ -28,6 +39,11 @@ type Store[T any] interface {
 }
 
 type WatchableStore[T any] interface {
-	watch.Watcher[T]
+	// Watch streams the events of the objects selected by opts, the selection is evaluated
+	// before the events are delivered to the channel.
+	Watch(ctx context.Context, opts WatchOptions) (watch.Channel[T], error)
+	// ResourceVersion returns the position of the latest event of the watch stream, the watch
+	// from it misses no write made after the call.
+	ResourceVersion(ctx context.Context) (string, error)
 	Store[T]
 }
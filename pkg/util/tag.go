@@ -0,0 +1,17 @@
+package util
+
+import "strings"
+
+// ParseGearboxTag parses the `gearbox:"column=xxx"` and `gearbox:"-"` struct tag, it's shared by
+// the AutoConverter and gearbox-gen so that both map the fields the same way
+func ParseGearboxTag(tag string) (column string, skip bool) {
+	if tag == "-" {
+		return "", true
+	}
+	for _, item := range strings.Split(tag, ",") {
+		if name, value, ok := strings.Cut(item, "="); ok && strings.TrimSpace(name) == "column" {
+			column = strings.TrimSpace(value)
+		}
+	}
+	return column, false
+}
@@ -2,41 +2,177 @@ package watch
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
 )
 
+func errorEvent[T any](err errors.StatusError) Event[T] {
+	status := err.Status()
+	return Event[T]{Type: EventTypeError, Err: &status}
+}
+
 type channel[T any] struct {
-	ctx    context.Context
-	cancel context.CancelFunc
-	msgCh  <-chan *message.Message
+	ctx      context.Context
+	cancel   context.CancelFunc
+	history  *history
+	inbox    *inbox
+	scheme   *apis.Scheme
+	filter   func(*T) bool
+	bookmark time.Duration
+	// evtCh is unbuffered, the events are buffered by the inbox
+	evtCh chan Event[T]
+	once  sync.Once
+	// lastSeq is the sequence of the last record processed, including the filtered ones
+	lastSeq uint64
+}
+
+func newChannel[T any](ctx context.Context, h *history, in *inbox, startSeq uint64, cfg Config, opts Options[T]) *channel[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	return &channel[T]{
+		ctx:      ctx,
+		cancel:   cancel,
+		history:  h,
+		inbox:    in,
+		scheme:   cfg.Scheme,
+		filter:   opts.Filter,
+		bookmark: opts.BookmarkInterval,
+		evtCh:    make(chan Event[T]),
+		lastSeq:  startSeq,
+	}
 }
 
 func (ch *channel[T]) Stop() {
 	ch.cancel()
+	ch.history.unsubscribe(ch.inbox)
+}
+
+func (ch *channel[T]) Metrics() Metrics {
+	depth, received, dropped := ch.inbox.stats()
+	return Metrics{
+		QueueDepth:    depth,
+		QueueCapacity: ch.inbox.capacity,
+		Received:      received,
+		Dropped:       dropped,
+	}
+}
+
+// push delivers the event to the consumer, the overflow policy is applied by the inbox.
+// It returns false if the watch is stopped.
+func (ch *channel[T]) push(evt Event[T]) bool {
+	select {
+	case ch.evtCh <- evt:
+		return true
+	case <-ch.ctx.Done():
+		return false
+	}
+}
+
+func (ch *channel[T]) decode(msg *message.Message, data *payload[T]) error {
+	contentType := msg.Metadata[contentTypeKey]
+	if contentType == "" {
+		contentType = apis.MIMEJSON
+	}
+	codec, ok := ch.scheme.Codec(contentType)
+	if !ok {
+		return fmt.Errorf("unsupported content type %q", contentType)
+	}
+	return codec.Unmarshal(msg.Payload, data)
+}
+
+// filterEvent applies the filter to the event, it returns false if the event should be skipped.
+func (ch *channel[T]) filterEvent(evt Event[T]) (Event[T], bool) {
+	if ch.filter == nil {
+		return evt, true
+	}
+	matched := ch.filter(evt.Obj)
+	if evt.Type != EventTypeUpdated || evt.OldObj == nil {
+		return evt, matched
+	}
+	oldMatched := ch.filter(evt.OldObj)
+	switch {
+	case matched && !oldMatched:
+		return Event[T]{Type: EventTypeCreated, Obj: evt.Obj}, true
+	case !matched && oldMatched:
+		return Event[T]{Type: EventTypeDeleted, Obj: evt.Obj}, true
+	}
+	return evt, matched
+}
+
+// terminate sends the last event of the watch, it waits for the room of the buffer
+// unless the watch is stopped.
+func (ch *channel[T]) terminate(evt Event[T]) {
+	select {
+	case ch.evtCh <- evt:
+	case <-ch.ctx.Done():
+	}
 }
 
 func (ch *channel[T]) ResultChan() (<-chan Event[T], error) {
-	var evtCh = make(chan Event[T])
-	go func() {
-		for {
-			select {
-			case msg := <-ch.msgCh:
-				if msg == nil {
-					return
-				}
-				var obj T
-				if err := json.Unmarshal(msg.Payload, &obj); err != nil {
-					evtCh <- Event[T]{Type: EventTypeError}
-				}
-				evtCh <- Event[T]{Type: EventType(msg.Metadata["Type"]), Obj: &obj}
-				msg.Ack()
-			case <-ch.ctx.Done():
-				close(evtCh)
+	ch.once.Do(func() {
+		go ch.run()
+	})
+	return ch.evtCh, nil
+}
+
+func (ch *channel[T]) run() {
+	defer close(ch.evtCh)
+	defer ch.cancel()
+	defer ch.history.unsubscribe(ch.inbox)
+
+	var tick <-chan time.Time
+	if ch.bookmark > 0 {
+		ticker := time.NewTicker(ch.bookmark)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for ch.ctx.Err() == nil {
+		rec, ok, end := ch.inbox.pop()
+		if end != nil {
+			ch.terminate(errorEvent[T](*end))
+			return
+		}
+		if ok {
+			ch.lastSeq = rec.seq
+			evt, ok := ch.decodeRecord(rec)
+			if ok && !ch.push(evt) {
+				return
+			}
+			continue
+		}
+		select {
+		case <-ch.inbox.notify:
+		case <-tick:
+			if !ch.push(Event[T]{Type: EventTypeBookmark, ResourceVersion: ch.history.version(ch.lastSeq)}) {
 				return
 			}
+		case <-ch.ctx.Done():
+			return
 		}
-	}()
-	return evtCh, nil
+	}
+}
+
+// decodeRecord decodes and filters the record, it returns false if the event should be skipped.
+func (ch *channel[T]) decodeRecord(rec record) (Event[T], bool) {
+	msg := rec.msg
+	version := ch.history.version(rec.seq)
+	var data payload[T]
+	err := ch.decode(msg, &data)
+	if err == nil && data.Object == nil {
+		err = fmt.Errorf("the object is missing")
+	}
+	if err != nil {
+		err = fmt.Errorf("decode the %s event failed: %w", msg.Metadata["Type"], err)
+		evt := errorEvent[T](errors.NewInternalError(err))
+		evt.ResourceVersion = version
+		return evt, true
+	}
+	evt, ok := ch.filterEvent(Event[T]{Type: EventType(msg.Metadata["Type"]), Obj: data.Object, OldObj: data.OldObject})
+	evt.ResourceVersion = version
+	return evt, ok
 }
@@ -0,0 +1,217 @@
+package watch
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
+
+type foo struct {
+	Name string
+}
+
+func newTestWatch(t *testing.T, cfg Config, opts Options[foo]) (*gochannel.GoChannel, EventPubWatcher[foo], Channel[foo], <-chan Event[foo]) {
+	// block the Publish until the event is received by the watch to keep the order of the events
+	ps := gochannel.NewGoChannel(gochannel.Config{BlockPublishUntilSubscriberAck: true}, watermill.NopLogger{})
+	cfg.PubSub = ps
+	p, err := NewPubSub[foo](cfg)
+	assert.Nil(t, err)
+	ch, err := p.Watch(context.Background(), opts)
+	assert.Nil(t, err)
+	evtCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+	return ps, p, ch, evtCh
+}
+
+func receive(t *testing.T, evtCh <-chan Event[foo]) (Event[foo], bool) {
+	select {
+	case evt, ok := <-evtCh:
+		return evt, ok
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the event")
+		return Event[foo]{}, false
+	}
+}
+
+func TestChannelDecodeFailure(t *testing.T) {
+	ps, p, ch, evtCh := newTestWatch(t, Config{}, Options[foo]{})
+	defer ch.Stop()
+
+	msg := message.NewMessage(watermill.NewShortUUID(), []byte("{"))
+	msg.Metadata["Type"] = string(EventTypeCreated)
+	assert.Nil(t, ps.Publish(genTopicName[foo](), msg))
+	assert.Nil(t, p.Publish(context.Background(), EventTypeCreated, &foo{Name: "foo"}, nil))
+
+	evt, ok := receive(t, evtCh)
+	assert.True(t, ok)
+	assert.Equal(t, EventTypeError, evt.Type)
+	assert.Nil(t, evt.Obj)
+	assert.Equal(t, http.StatusInternalServerError, evt.Err.Code)
+
+	// the watch continues after a decoding failure
+	evt, ok = receive(t, evtCh)
+	assert.True(t, ok)
+	evt.ResourceVersion = ""
+	assert.Equal(t, Event[foo]{Type: EventTypeCreated, Obj: &foo{Name: "foo"}}, evt)
+}
+
+func TestChannelOldObject(t *testing.T) {
+	_, p, ch, evtCh := newTestWatch(t, Config{}, Options[foo]{})
+	defer ch.Stop()
+
+	assert.Nil(t, p.Publish(context.Background(), EventTypeUpdated, &foo{Name: "new"}, &foo{Name: "old"}))
+	evt, ok := receive(t, evtCh)
+	assert.True(t, ok)
+	evt.ResourceVersion = ""
+	assert.Equal(t, Event[foo]{Type: EventTypeUpdated, Obj: &foo{Name: "new"}, OldObj: &foo{Name: "old"}}, evt)
+}
+
+func TestChannelCodec(t *testing.T) {
+	ps := gochannel.NewGoChannel(gochannel.Config{BlockPublishUntilSubscriberAck: true}, watermill.NopLogger{})
+	scheme := apis.NewScheme()
+	scheme.AddCodecs(apis.CBORCodec)
+	jsonPub, err := NewPubSub[foo](Config{PubSub: ps})
+	assert.Nil(t, err)
+	cborPub, err := NewPubSub[foo](Config{PubSub: ps, Scheme: scheme, ContentType: apis.MIMECBOR})
+	assert.Nil(t, err)
+	ch, err := cborPub.Watch(context.Background(), Options[foo]{})
+	assert.Nil(t, err)
+	defer ch.Stop()
+	evtCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+
+	// the JSON payloads are decodable by the watches of any codec
+	assert.Nil(t, cborPub.Publish(context.Background(), EventTypeCreated, &foo{Name: "cbor"}, nil))
+	assert.Nil(t, jsonPub.Publish(context.Background(), EventTypeCreated, &foo{Name: "json"}, nil))
+	for _, name := range []string{"cbor", "json"} {
+		evt, ok := receive(t, evtCh)
+		assert.True(t, ok)
+		evt.ResourceVersion = ""
+		assert.Equal(t, Event[foo]{Type: EventTypeCreated, Obj: &foo{Name: name}}, evt)
+	}
+
+	// the content type must be registered in the scheme
+	_, err = NewPubSub[foo](Config{PubSub: ps, ContentType: apis.MIMECBOR})
+	assert.NotNil(t, err)
+}
+
+func TestChannelFilter(t *testing.T) {
+	_, p, ch, evtCh := newTestWatch(t, Config{}, Options[foo]{Filter: func(obj *foo) bool {
+		return strings.HasPrefix(obj.Name, "a")
+	}})
+	defer ch.Stop()
+
+	for _, evt := range []Event[foo]{
+		{Type: EventTypeCreated, Obj: &foo{Name: "b"}},
+		{Type: EventTypeCreated, Obj: &foo{Name: "a"}},
+		{Type: EventTypeUpdated, Obj: &foo{Name: "a1"}, OldObj: &foo{Name: "a"}},
+		{Type: EventTypeUpdated, Obj: &foo{Name: "b1"}, OldObj: &foo{Name: "a1"}},
+		{Type: EventTypeUpdated, Obj: &foo{Name: "b2"}, OldObj: &foo{Name: "b1"}},
+		{Type: EventTypeUpdated, Obj: &foo{Name: "a2"}, OldObj: &foo{Name: "b2"}},
+		{Type: EventTypeDeleted, Obj: &foo{Name: "b3"}},
+		{Type: EventTypeDeleted, Obj: &foo{Name: "a2"}},
+	} {
+		assert.Nil(t, p.Publish(context.Background(), evt.Type, evt.Obj, evt.OldObj))
+	}
+
+	for _, expected := range []Event[foo]{
+		{Type: EventTypeCreated, Obj: &foo{Name: "a"}},
+		{Type: EventTypeUpdated, Obj: &foo{Name: "a1"}, OldObj: &foo{Name: "a"}},
+		// moves out of the selection
+		{Type: EventTypeDeleted, Obj: &foo{Name: "b1"}},
+		// moves into the selection
+		{Type: EventTypeCreated, Obj: &foo{Name: "a2"}},
+		{Type: EventTypeDeleted, Obj: &foo{Name: "a2"}},
+	} {
+		evt, ok := receive(t, evtCh)
+		assert.True(t, ok)
+		evt.ResourceVersion = ""
+		assert.Equal(t, expected, evt)
+	}
+}
+
+func TestChannelBackendDisconnect(t *testing.T) {
+	ps, _, ch, evtCh := newTestWatch(t, Config{}, Options[foo]{})
+	defer ch.Stop()
+
+	assert.Nil(t, ps.Close())
+
+	evt, ok := receive(t, evtCh)
+	assert.True(t, ok)
+	assert.Equal(t, EventTypeError, evt.Type)
+	assert.Equal(t, http.StatusServiceUnavailable, evt.Err.Code)
+
+	_, ok = receive(t, evtCh)
+	assert.False(t, ok)
+}
+
+func TestChannelStop(t *testing.T) {
+	_, p, ch, evtCh := newTestWatch(t, Config{}, Options[foo]{})
+
+	ch.Stop()
+	_, ok := receive(t, evtCh)
+	assert.False(t, ok)
+
+	// stopping a watch doesn't affect the publisher
+	assert.Nil(t, p.Publish(context.Background(), EventTypeCreated, &foo{Name: "foo"}, nil))
+}
+
+func TestChannelResume(t *testing.T) {
+	_, p, ch, evtCh := newTestWatch(t, Config{HistorySize: 2}, Options[foo]{})
+	defer ch.Stop()
+
+	var versions []string
+	for _, name := range []string{"a", "b", "c"} {
+		assert.Nil(t, p.Publish(context.Background(), EventTypeCreated, &foo{Name: name}, nil))
+		evt, ok := receive(t, evtCh)
+		assert.True(t, ok)
+		versions = append(versions, evt.ResourceVersion)
+	}
+	// the version of the stream is the version of the latest event
+	assert.Equal(t, versions[2], p.ResourceVersion())
+
+	// resume after "b"
+	resumed, err := p.Watch(context.Background(), Options[foo]{ResourceVersion: versions[1]})
+	assert.Nil(t, err)
+	defer resumed.Stop()
+	resumedCh, err := resumed.ResultChan()
+	assert.Nil(t, err)
+	evt, ok := receive(t, resumedCh)
+	assert.True(t, ok)
+	assert.Equal(t, "c", evt.Obj.Name)
+	assert.Equal(t, versions[2], evt.ResourceVersion)
+
+	// "a" is out of the history, the watch can't resume before it
+	_, err = p.Watch(context.Background(), Options[foo]{ResourceVersion: strings.TrimSuffix(versions[0], "1") + "0"})
+	assert.True(t, errors.IsExpiredError(err))
+	// the version of another history
+	_, err = p.Watch(context.Background(), Options[foo]{ResourceVersion: "unknown.1"})
+	assert.True(t, errors.IsExpiredError(err))
+	_, err = p.Watch(context.Background(), Options[foo]{ResourceVersion: "1"})
+	assert.True(t, errors.IsBadRequestError(err))
+}
+
+func TestChannelBookmark(t *testing.T) {
+	_, p, ch, evtCh := newTestWatch(t, Config{}, Options[foo]{
+		Filter:           func(obj *foo) bool { return false },
+		BookmarkInterval: 10 * time.Millisecond,
+	})
+	defer ch.Stop()
+
+	assert.Nil(t, p.Publish(context.Background(), EventTypeCreated, &foo{Name: "a"}, nil))
+	assert.Eventually(t, func() bool {
+		evt, ok := receive(t, evtCh)
+		// the Bookmark carries the version of the filtered event
+		return ok && evt.Type == EventTypeBookmark && strings.HasSuffix(evt.ResourceVersion, ".1")
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,292 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/sunyakun/gearbox/pkg/errors"
+)
+
+// record is a message received from the PubSub, <seq> is assigned by the history in the
+// receiving order.
+type record struct {
+	seq uint64
+	msg *message.Message
+}
+
+// inbox is the buffer of the records of a single watch, the overflow policy is applied when
+// it's full. The recorder waits for the OverflowBlock watches only.
+type inbox struct {
+	mu       sync.Mutex
+	space    *sync.Cond
+	records  []record
+	capacity int
+	policy   OverflowPolicy
+	// closed is set if the backend is disconnected, stopped is set if the watch is stopped
+	closed     bool
+	stopped    bool
+	overflowed bool
+	received   uint64
+	dropped    uint64
+	notify     chan struct{}
+}
+
+// newInbox creates the inbox with the replayed records, they are kept even if they exceed
+// the capacity, the later records wait or overflow until the inbox is drained.
+func newInbox(records []record, capacity int, policy OverflowPolicy) *inbox {
+	in := &inbox{
+		records:  records,
+		capacity: capacity,
+		policy:   policy,
+		received: uint64(len(records)),
+		notify:   make(chan struct{}, 1),
+	}
+	in.space = sync.NewCond(&in.mu)
+	return in
+}
+
+// push appends the record by the overflow policy, it waits for the room of the inbox if the
+// policy is OverflowBlock.
+func (in *inbox) push(rec record) {
+	in.mu.Lock()
+	defer in.wakeup()
+	defer in.mu.Unlock()
+	if in.policy == OverflowBlock {
+		for len(in.records) >= in.capacity && !in.closed && !in.stopped {
+			in.space.Wait()
+		}
+	}
+	if in.closed || in.stopped || in.overflowed {
+		return
+	}
+	in.received++
+	if len(in.records) < in.capacity {
+		in.records = append(in.records, rec)
+		return
+	}
+	switch in.policy {
+	case OverflowDropOldest:
+		in.records[0] = record{}
+		in.records = append(in.records[1:], rec)
+		in.dropped++
+	case OverflowTerminate:
+		// discard the buffered records, the consumer has to relist anyway
+		in.dropped += uint64(len(in.records)) + 1
+		in.records = nil
+		in.overflowed = true
+	}
+}
+
+func (in *inbox) close() {
+	in.mu.Lock()
+	in.closed = true
+	in.space.Broadcast()
+	in.mu.Unlock()
+	in.wakeup()
+}
+
+// stop releases the recorder waiting for the room of the inbox
+func (in *inbox) stop() {
+	in.mu.Lock()
+	in.stopped = true
+	in.space.Broadcast()
+	in.mu.Unlock()
+}
+
+func (in *inbox) wakeup() {
+	select {
+	case in.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next record. <end> is the reason the watch ends once the inbox is drained,
+// it's set if the backend is disconnected or the inbox is overflowed by OverflowTerminate.
+func (in *inbox) pop() (rec record, ok bool, end *errors.StatusError) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.records) == 0 {
+		switch {
+		case in.overflowed:
+			err := errors.NewExpired("the watch is terminated because the consumer is too slow, relist and watch again")
+			return record{}, false, &err
+		case in.closed:
+			err := errors.NewServiceUnavailable("the watch backend is disconnected")
+			return record{}, false, &err
+		}
+		return record{}, false, nil
+	}
+	rec = in.records[0]
+	in.records[0] = record{}
+	in.records = in.records[1:]
+	in.space.Signal()
+	return rec, true, nil
+}
+
+// stats returns the number of the buffered, received and dropped records
+func (in *inbox) stats() (depth int, received, dropped uint64) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return len(in.records), in.received, in.dropped
+}
+
+// history receives all the messages of the topic through a single subscription, it numbers
+// the messages, keeps the latest of them for the watches to resume and fans them out to
+// the inboxes of the watches.
+type history struct {
+	epoch  string
+	cancel context.CancelFunc
+
+	mu           sync.Mutex
+	seq          uint64
+	ring         []record
+	head         int
+	size         int
+	inboxes      map[*inbox]struct{}
+	disconnected bool
+}
+
+func newHistory(pubsub PubSub, topic string, size int) (*history, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	msgCh, err := pubsub.Subscribe(ctx, topic)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if size < 0 {
+		size = 0
+	}
+	h := &history{
+		epoch:   watermill.NewShortUUID(),
+		cancel:  cancel,
+		ring:    make([]record, size),
+		inboxes: map[*inbox]struct{}{},
+	}
+	go h.run(msgCh)
+	return h, nil
+}
+
+func (h *history) run(msgCh <-chan *message.Message) {
+	for msg := range msgCh {
+		msg.Ack()
+		h.mu.Lock()
+		h.seq++
+		rec := record{seq: h.seq, msg: msg}
+		if len(h.ring) != 0 {
+			h.ring[(h.head+h.size)%len(h.ring)] = rec
+			if h.size == len(h.ring) {
+				h.head = (h.head + 1) % len(h.ring)
+			} else {
+				h.size++
+			}
+		}
+		inboxes := make([]*inbox, 0, len(h.inboxes))
+		for in := range h.inboxes {
+			inboxes = append(inboxes, in)
+		}
+		h.mu.Unlock()
+		// push out of the lock, so that an OverflowBlock watch waiting for its consumer doesn't
+		// block the subscribe, unsubscribe and close
+		for _, in := range inboxes {
+			in.push(rec)
+		}
+	}
+
+	h.disconnect()
+}
+
+// disconnect closes the inboxes of the watches, the later subscriptions fail
+func (h *history) disconnect() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disconnected = true
+	for in := range h.inboxes {
+		in.close()
+	}
+	h.inboxes = map[*inbox]struct{}{}
+}
+
+// version formats the sequence as the resource version of the event stream
+func (h *history) version(seq uint64) string {
+	return fmt.Sprintf("%s.%d", h.epoch, seq)
+}
+
+// current returns the resource version of the latest record
+func (h *history) current() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.version(h.seq)
+}
+
+// parseVersion returns the sequence of the resource version, the version of the other
+// histories, e.g. returned by another replica or before a restart, is expired.
+func (h *history) parseVersion(version string) (uint64, error) {
+	idx := strings.LastIndex(version, ".")
+	if idx < 0 {
+		return 0, errors.NewBadRequest(fmt.Sprintf("invalid resource version %q", version))
+	}
+	seq, err := strconv.ParseUint(version[idx+1:], 10, 64)
+	if err != nil {
+		return 0, errors.NewBadRequest(fmt.Sprintf("invalid resource version %q", version))
+	}
+	if version[:idx] != h.epoch {
+		return 0, errors.NewExpired(fmt.Sprintf("the resource version %q is expired, relist and watch again", version))
+	}
+	return seq, nil
+}
+
+// subscribe registers an inbox of <capacity>, the records after <version> are replayed if it's
+// not empty. It returns the sequence the inbox starts after.
+func (h *history) subscribe(version string, capacity int, policy OverflowPolicy) (*inbox, uint64, error) {
+	var from uint64
+	if version != "" {
+		var err error
+		if from, err = h.parseVersion(version); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.disconnected {
+		return nil, 0, errors.NewServiceUnavailable("the watch backend is disconnected")
+	}
+	if version == "" {
+		from = h.seq
+	}
+	// the records in (oldest-1, seq] are resumable
+	oldest := h.seq - uint64(h.size) + 1
+	if from > h.seq || from+1 < oldest {
+		return nil, 0, errors.NewExpired(fmt.Sprintf("the resource version %q is expired, relist and watch again", version))
+	}
+	var records []record
+	for i := 0; i < h.size; i++ {
+		rec := h.ring[(h.head+i)%len(h.ring)]
+		if rec.seq > from {
+			records = append(records, rec)
+		}
+	}
+	in := newInbox(records, capacity, policy)
+	h.inboxes[in] = struct{}{}
+	return in, from, nil
+}
+
+func (h *history) unsubscribe(in *inbox) {
+	in.stop()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.inboxes, in)
+}
+
+// close cancels the subscription and ends the watches, it doesn't wait for the PubSub to close
+// the subscription as the PubSub may be shared and stay open.
+func (h *history) close() {
+	h.cancel()
+	h.disconnect()
+}
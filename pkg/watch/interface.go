@@ -2,8 +2,11 @@ package watch
 
 import (
 	"context"
+	"time"
 
 	"github.com/ThreeDotsLabs/watermill/message"
+
+	"github.com/sunyakun/gearbox/pkg/apis"
 )
 
 type EventType string
@@ -14,27 +17,71 @@ var (
 	EventTypeDeleted EventType = "Deleted"
 	EventTypeGeneric EventType = "Generic"
 	EventTypeError   EventType = "Error"
+	// EventTypeBookmark carries only the ResourceVersion, the watch can be resumed from it
+	EventTypeBookmark EventType = "Bookmark"
+)
+
+// OverflowPolicy decides what a watch does when its consumer is too slow and the buffer is full
+type OverflowPolicy string
+
+const (
+	// OverflowBlock blocks the delivery until the consumer catches up. The watches of a
+	// watcher share a single subscription of the PubSub, so a slow consumer stalls all the
+	// watches of the watcher and the publishers waiting for the subscription.
+	OverflowBlock OverflowPolicy = "Block"
+	// OverflowDropOldest drops the oldest buffered event to make room for the new one.
+	OverflowDropOldest OverflowPolicy = "DropOldest"
+	// OverflowTerminate discards the buffered events, sends an Error event and closes the
+	// watch, the consumer is expected to relist and watch again.
+	OverflowTerminate OverflowPolicy = "Terminate"
 )
 
+const DefaultBufferSize = 100
+
 type PubSub interface {
 	message.Publisher
 	message.Subscriber
 }
 
 type EventPublisher[T any] interface {
-	Publish(ctx context.Context, eventType EventType, obj *T) error
+	// Publish publishes the event of <obj>, <oldObj> is the object before the update and
+	// is nil for the other event types.
+	Publish(ctx context.Context, eventType EventType, obj *T, oldObj *T) error
 	Close() error
 }
 
+// Options of a single watch.
+// <Filter> returns true if the object is selected by the watch. The update that moves an object
+// into the selection is delivered as a Created event and the one that moves an object out of
+// the selection is delivered as a Deleted event, all the events are delivered if it's nil.
+// <ResourceVersion> resumes the watch after the event of the version, the watch starts from
+// now if it's empty. It fails with the 410 Expired error if the event is not in the history.
+// <BookmarkInterval> is the interval of the Bookmark events, no Bookmark is sent if it's zero.
+type Options[T any] struct {
+	Filter           func(obj *T) bool
+	ResourceVersion  string
+	BookmarkInterval time.Duration
+}
+
 type Watcher[T any] interface {
-	Watch(context.Context) (Channel[T], error)
+	Watch(context.Context, Options[T]) (Channel[T], error)
 }
 
 type EventPubWatcher[T any] interface {
 	EventPublisher[T]
 	Watcher[T]
+	// ResourceVersion returns the position of the latest event in the event stream, the watch
+	// resumed from it receives the events published after the call.
+	ResourceVersion() string
 }
 
+// Channel is a single watch. The result channel is owned and closed by the Channel:
+//   - Stop, or the cancel of the context passed to Watch, closes it without an Error event.
+//   - the backend disconnect sends an Error event with code 503 then closes it.
+//   - the OverflowTerminate policy sends an Error event with code 410 then closes it.
+//
+// An undecodable event is reported by an Error event with code 500 and the watch continues.
+// ResultChan always returns the same channel.
 type Channel[T any] interface {
 	Stop()
 	ResultChan() (<-chan Event[T], error)
@@ -42,5 +89,31 @@ type Channel[T any] interface {
 
 type Event[T any] struct {
 	Type EventType
-	Obj  *T
+	// ResourceVersion is the position of the event in the event stream, it's different from
+	// the resource version of the object.
+	ResourceVersion string
+	// Obj is the current object, for the Deleted event it's the final state before the delete
+	Obj *T
+	// OldObj is the object before the update, it's set only if Type is EventTypeUpdated
+	OldObj *T
+	// Err is set only if Type is EventTypeError
+	Err *apis.Status
+}
+
+// Metrics of a single watch
+type Metrics struct {
+	// QueueDepth is the number of events buffered but not yet consumed, the event being
+	// delivered to the consumer is not counted
+	QueueDepth int
+	// QueueCapacity is the size of the buffer
+	QueueCapacity int
+	// Received is the number of events delivered to the watch, the bookmarks are not counted
+	Received uint64
+	// Dropped is the number of events dropped by the overflow policy
+	Dropped uint64
+}
+
+// MetricsProvider is implemented by the channels that expose the per-watch metrics
+type MetricsProvider interface {
+	Metrics() Metrics
 }
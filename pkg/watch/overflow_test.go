@@ -0,0 +1,199 @@
+package watch
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflowPolicyUnknown(t *testing.T) {
+	_, err := NewPubSub[foo](Config{OverflowPolicy: "Unknown"})
+	assert.NotNil(t, err)
+}
+
+func TestOverflowPolicyDefaultSlowConsumer(t *testing.T) {
+	// the default policy doesn't let the idle watch stall the others and the publisher
+	p, idle := newIdleWatch(t, Config{BufferSize: 2})
+	defer p.Close()
+	defer idle.Stop()
+	ch, err := p.Watch(context.Background(), Options[foo]{})
+	assert.Nil(t, err)
+	defer ch.Stop()
+	evtCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		assert.Nil(t, p.Publish(context.Background(), EventTypeCreated, &foo{Name: name}, nil))
+		evt, ok := receive(t, evtCh)
+		assert.True(t, ok)
+		assert.Equal(t, name, evt.Obj.Name)
+	}
+
+	idleCh, err := idle.ResultChan()
+	assert.Nil(t, err)
+	evt, ok := receive(t, idleCh)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusGone, evt.Err.Code)
+}
+
+// newIdleWatch creates a watch without consuming it, its events are kept in the inbox
+func newIdleWatch(t *testing.T, cfg Config) (EventPubWatcher[foo], Channel[foo]) {
+	cfg.PubSub = gochannel.NewGoChannel(gochannel.Config{BlockPublishUntilSubscriberAck: true}, watermill.NopLogger{})
+	p, err := NewPubSub[foo](cfg)
+	assert.Nil(t, err)
+	ch, err := p.Watch(context.Background(), Options[foo]{})
+	assert.Nil(t, err)
+	return p, ch
+}
+
+func TestChannelOverflowBlock(t *testing.T) {
+	p, ch := newIdleWatch(t, Config{BufferSize: 1, OverflowPolicy: OverflowBlock})
+	defer ch.Stop()
+
+	published := make(chan error)
+	go func() {
+		for _, name := range []string{"a", "b", "c"} {
+			if err := p.Publish(context.Background(), EventTypeCreated, &foo{Name: name}, nil); err != nil {
+				published <- err
+				return
+			}
+		}
+		close(published)
+	}()
+
+	// the publisher waits for the consumer
+	metrics := ch.(MetricsProvider)
+	assert.Eventually(t, func() bool { return metrics.Metrics().QueueDepth == 1 }, time.Second, time.Millisecond)
+	select {
+	case <-published:
+		t.Fatal("the publisher isn't blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// every event is delivered in order once the consumer catches up
+	evtCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+	for _, name := range []string{"a", "b", "c"} {
+		evt, ok := receive(t, evtCh)
+		assert.True(t, ok)
+		assert.Equal(t, name, evt.Obj.Name)
+	}
+	assert.Nil(t, <-published)
+	assert.Equal(t, Metrics{QueueDepth: 0, QueueCapacity: 1, Received: 3, Dropped: 0}, metrics.Metrics())
+}
+
+func TestChannelOverflowTerminate(t *testing.T) {
+	p, ch := newIdleWatch(t, Config{BufferSize: 1, OverflowPolicy: OverflowTerminate})
+	defer ch.Stop()
+
+	for _, name := range []string{"a", "b"} {
+		assert.Nil(t, p.Publish(context.Background(), EventTypeCreated, &foo{Name: name}, nil))
+	}
+	metrics := ch.(MetricsProvider)
+	assert.Eventually(t, func() bool { return metrics.Metrics().Dropped == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, 0, metrics.Metrics().QueueDepth)
+
+	evtCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+	evt, ok := receive(t, evtCh)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusGone, evt.Err.Code)
+	_, ok = receive(t, evtCh)
+	assert.False(t, ok)
+	assert.Equal(t, uint64(2), metrics.Metrics().Dropped)
+}
+
+func TestChannelOverflowDropOldest(t *testing.T) {
+	p, ch := newIdleWatch(t, Config{BufferSize: 2, OverflowPolicy: OverflowDropOldest})
+	defer ch.Stop()
+
+	for _, name := range []string{"a", "b", "c", "d"} {
+		assert.Nil(t, p.Publish(context.Background(), EventTypeCreated, &foo{Name: name}, nil))
+	}
+	metrics := ch.(MetricsProvider)
+	assert.Eventually(t, func() bool { return metrics.Metrics().Dropped == 2 }, time.Second, time.Millisecond)
+	// the backlog of the inbox is counted
+	assert.Equal(t, Metrics{QueueDepth: 2, QueueCapacity: 2, Received: 4, Dropped: 2}, metrics.Metrics())
+
+	evtCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+	for _, name := range []string{"c", "d"} {
+		evt, ok := receive(t, evtCh)
+		assert.True(t, ok)
+		assert.Equal(t, name, evt.Obj.Name)
+	}
+	assert.Equal(t, Metrics{QueueDepth: 0, QueueCapacity: 2, Received: 4, Dropped: 2}, metrics.Metrics())
+}
+
+func TestChannelOverflowBlockStop(t *testing.T) {
+	p, ch := newIdleWatch(t, Config{BufferSize: 1, OverflowPolicy: OverflowBlock})
+	defer p.Close()
+	other, err := p.Watch(context.Background(), Options[foo]{})
+	assert.Nil(t, err)
+	defer other.Stop()
+	otherCh, err := other.ResultChan()
+	assert.Nil(t, err)
+
+	// the recorder waits for the idle watch until it's stopped
+	published := make(chan error)
+	go func() {
+		for _, name := range []string{"a", "b", "c"} {
+			if err := p.Publish(context.Background(), EventTypeCreated, &foo{Name: name}, nil); err != nil {
+				published <- err
+				return
+			}
+		}
+		close(published)
+	}()
+	evt, ok := receive(t, otherCh)
+	assert.True(t, ok)
+	assert.Equal(t, "a", evt.Obj.Name)
+	select {
+	case <-published:
+		t.Fatal("the publisher isn't blocked")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	ch.Stop()
+	for _, name := range []string{"b", "c"} {
+		evt, ok := receive(t, otherCh)
+		assert.True(t, ok)
+		assert.Equal(t, name, evt.Obj.Name)
+	}
+	assert.Nil(t, <-published)
+}
+
+func TestChannelOverflowBlockClose(t *testing.T) {
+	p, ch := newIdleWatch(t, Config{BufferSize: 1, OverflowPolicy: OverflowBlock})
+	defer ch.Stop()
+
+	published := make(chan error)
+	go func() {
+		for _, name := range []string{"a", "b", "c"} {
+			if err := p.Publish(context.Background(), EventTypeCreated, &foo{Name: name}, nil); err != nil {
+				published <- err
+				return
+			}
+		}
+		close(published)
+	}()
+	metrics := ch.(MetricsProvider)
+	assert.Eventually(t, func() bool { return metrics.Metrics().QueueDepth == 1 }, time.Second, time.Millisecond)
+
+	// Close releases the publisher waiting for the watch and ends the watch
+	assert.Nil(t, p.Close())
+	<-published
+	evtCh, err := ch.ResultChan()
+	assert.Nil(t, err)
+	evt, ok := receive(t, evtCh)
+	assert.True(t, ok)
+	assert.Equal(t, "a", evt.Obj.Name)
+	evt, ok = receive(t, evtCh)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, evt.Err.Code)
+}
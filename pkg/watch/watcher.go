@@ -2,66 +2,177 @@ package watch
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"sync"
 
 	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
-)
 
-var (
-	once            sync.Once
-	watermillPubSub PubSub
+	"github.com/sunyakun/gearbox/pkg/apis"
 )
 
+var ErrClosed = errors.New("the watcher is closed")
+
+// Config used to construct the EventPubWatcher.
+// <PubSub> is the watermill PubSub the events go through, e.g. watermill-sql for the multi-replica
+// deployments. It is owned by the caller and never closed by the EventPubWatcher, so it can be shared
+// by the EventPubWatchers of the different stores. If it is nil, an in-process gochannel PubSub is
+// created, it is owned by the EventPubWatcher and closed by Close.
+// <Topic> defaults to "<package path>:<type name>" of T, with the "/" replaced by "_".
+// <BufferSize> is the number of events buffered per watch, defaults to DefaultBufferSize.
+// <OverflowPolicy> decides what to do when the buffer of a watch is full, defaults to OverflowTerminate
+// so that a slow consumer doesn't stall the other watches and the publishers, see OverflowBlock.
+// <Scheme> resolves the codecs of the event payloads, the payloads are decoded by the codec of their
+// content type, defaults to a Scheme has the JSON codec only.
+// <ContentType> is the content type the payloads are encoded in, it must be registered in the Scheme,
+// defaults to JSON. The JSON payloads are always decodable so that the publishers can switch the
+// content type one by one.
+// <HistorySize> is the number of the latest events kept for the watches to resume, defaults to
+// DefaultHistorySize. The history is in-process, a negative value disables the resume.
+type Config struct {
+	PubSub         PubSub
+	Topic          string
+	BufferSize     int
+	OverflowPolicy OverflowPolicy
+	Scheme         *apis.Scheme
+	ContentType    string
+	HistorySize    int
+}
+
+const DefaultHistorySize = 1000
+
+// contentTypeKey is the message metadata of the payload content type
+const contentTypeKey = "ContentType"
+
 type pubwatcher[T any] struct {
-	topic  string
-	pubsub PubSub
+	cfg        Config
+	codec      apis.Codec
+	topic      string
+	pubsub     PubSub
+	ownsPubSub bool
+	history    *history
+
+	mu     sync.RWMutex
+	closed bool
 }
 
-func NewPubSub[T any]() (EventPubWatcher[T], error) {
-	once.Do(func() {
-		watermillPubSub = gochannel.NewGoChannel(gochannel.Config{}, &watermill.NopLogger{})
-	})
-	p := &pubwatcher[T]{pubsub: watermillPubSub}
-	p.topic = genTopicName[T]()
+func NewPubSub[T any](cfg Config) (EventPubWatcher[T], error) {
+	switch cfg.OverflowPolicy {
+	case "":
+		cfg.OverflowPolicy = OverflowTerminate
+	case OverflowBlock, OverflowDropOldest, OverflowTerminate:
+	default:
+		return nil, fmt.Errorf("unknown overflow policy %q", cfg.OverflowPolicy)
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultBufferSize
+	}
+	if cfg.Scheme == nil {
+		cfg.Scheme = apis.NewScheme()
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = apis.MIMEJSON
+	}
+	codec, ok := cfg.Scheme.Codec(cfg.ContentType)
+	if !ok {
+		return nil, fmt.Errorf("the content type %q isn't registered in the scheme", cfg.ContentType)
+	}
+	p := &pubwatcher[T]{cfg: cfg, codec: codec, pubsub: cfg.PubSub, topic: cfg.Topic}
+	if p.topic == "" {
+		p.topic = genTopicName[T]()
+	}
 	if p.topic == "" {
 		return nil, fmt.Errorf("the generic type T must have a name")
 	}
+	if p.pubsub == nil {
+		p.pubsub = gochannel.NewGoChannel(gochannel.Config{}, &watermill.NopLogger{})
+		p.ownsPubSub = true
+	}
+	historySize := cfg.HistorySize
+	if historySize == 0 {
+		historySize = DefaultHistorySize
+	}
+	h, err := newHistory(p.pubsub, p.topic, historySize)
+	if err != nil {
+		if p.ownsPubSub {
+			_ = p.pubsub.Close()
+		}
+		return nil, err
+	}
+	p.history = h
 	return p, nil
 }
 
+// Close stops the watcher and ends the outstanding watches with the 503 Error event, the PubSub
+// is closed only if it is created by the watcher
 func (p *pubwatcher[T]) Close() error {
-	return p.pubsub.Close()
+	// close the history first, a Publish holding the lock may wait for an OverflowBlock watch
+	p.history.close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.ownsPubSub {
+		return p.pubsub.Close()
+	}
+	return nil
 }
 
-func (p *pubwatcher[T]) Publish(ctx context.Context, eventType EventType, obj *T) error {
-	payload, err := json.Marshal(obj)
+// payload is the message payload of an event
+type payload[T any] struct {
+	Object    *T `json:"object"`
+	OldObject *T `json:"oldObject,omitempty"`
+}
+
+func (p *pubwatcher[T]) Publish(ctx context.Context, eventType EventType, obj *T, oldObj *T) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	data, err := p.codec.Marshal(payload[T]{Object: obj, OldObject: oldObj})
 	if err != nil {
 		return err
 	}
-	msg := message.NewMessage(watermill.NewShortUUID(), payload)
+	msg := message.NewMessage(watermill.NewShortUUID(), data)
+	msg.Metadata[contentTypeKey] = p.codec.ContentType()
 	msg.Metadata["Type"] = string(eventType)
 	return p.pubsub.Publish(p.topic, msg)
 }
 
-func (p *pubwatcher[T]) Watch(ctx context.Context) (Channel[T], error) {
-	msgCh, err := p.pubsub.Subscribe(ctx, p.topic)
+func (p *pubwatcher[T]) Watch(ctx context.Context, opts Options[T]) (Channel[T], error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, ErrClosed
+	}
+	in, startSeq, err := p.history.subscribe(opts.ResourceVersion, p.cfg.BufferSize, p.cfg.OverflowPolicy)
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithCancel(ctx)
-	return &channel[T]{msgCh: msgCh, ctx: ctx, cancel: cancel}, nil
+	return newChannel[T](ctx, p.history, in, startSeq, p.cfg, opts), nil
 }
 
+func (p *pubwatcher[T]) ResourceVersion() string {
+	return p.history.current()
+}
+
+// invalidTopicChars are the characters not allowed in the topics of watermill-sql
+var invalidTopicChars = regexp.MustCompile(`[^A-Za-z0-9\-\$\:\.\_]`)
+
+// genTopicName returns "<package path>:<type name>" of T, the characters not allowed by
+// watermill-sql, e.g. "/" of the package path, are replaced by "_".
 func genTopicName[T any]() string {
 	var t T
 	rt := reflect.TypeOf(t)
 	if rt.PkgPath() == "" || rt.Name() == "" {
 		return ""
 	}
-	return fmt.Sprintf("%s:%s", rt.PkgPath(), rt.Name())
+	return invalidTopicChars.ReplaceAllString(fmt.Sprintf("%s:%s", rt.PkgPath(), rt.Name()), "_")
 }
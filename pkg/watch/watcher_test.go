@@ -0,0 +1,111 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/stretchr/testify/assert"
+)
+
+// detachedPubSub keeps the subscriptions open after their contexts are canceled
+type detachedPubSub struct {
+	*gochannel.GoChannel
+}
+
+func (ps detachedPubSub) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	return ps.GoChannel.Subscribe(context.Background(), topic)
+}
+
+func TestWatcherSharedPubSub(t *testing.T) {
+	for name, ps := range map[string]PubSub{
+		"gochannel": gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{}),
+		"detached":  detachedPubSub{gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer ps.Close()
+			ctx := context.Background()
+			closed, err := NewPubSub[foo](Config{PubSub: ps, Topic: "closed"})
+			assert.Nil(t, err)
+			shared, err := NewPubSub[foo](Config{PubSub: ps, Topic: "shared"})
+			assert.Nil(t, err)
+			defer shared.Close()
+
+			ch, err := closed.Watch(ctx, Options[foo]{})
+			assert.Nil(t, err)
+			evtCh, err := ch.ResultChan()
+			assert.Nil(t, err)
+			sharedCh, err := shared.Watch(ctx, Options[foo]{})
+			assert.Nil(t, err)
+			defer sharedCh.Stop()
+			sharedEvtCh, err := sharedCh.ResultChan()
+			assert.Nil(t, err)
+
+			// Close ends the outstanding watches even if the PubSub keeps the subscription
+			assert.Nil(t, closed.Close())
+			evt, ok := receive(t, evtCh)
+			assert.True(t, ok)
+			assert.Equal(t, http.StatusServiceUnavailable, evt.Err.Code)
+			_, ok = receive(t, evtCh)
+			assert.False(t, ok)
+			_, err = closed.Watch(ctx, Options[foo]{})
+			assert.ErrorIs(t, err, ErrClosed)
+			assert.ErrorIs(t, closed.Publish(ctx, EventTypeCreated, &foo{Name: "foo"}, nil), ErrClosed)
+
+			// the shared PubSub isn't closed
+			assert.Nil(t, shared.Publish(ctx, EventTypeCreated, &foo{Name: "foo"}, nil))
+			evt, ok = receive(t, sharedEvtCh)
+			assert.True(t, ok)
+			assert.Equal(t, "foo", evt.Obj.Name)
+		})
+	}
+}
+
+// strictPubSub validates the topics like watermill-sql
+type strictPubSub struct {
+	*gochannel.GoChannel
+}
+
+var validTopic = regexp.MustCompile(`^[A-Za-z0-9\-\$\:\.\_]+$`)
+
+func (ps strictPubSub) Publish(topic string, msgs ...*message.Message) error {
+	if !validTopic.MatchString(topic) {
+		return fmt.Errorf("invalid topic %q", topic)
+	}
+	return ps.GoChannel.Publish(topic, msgs...)
+}
+
+func (ps strictPubSub) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	if !validTopic.MatchString(topic) {
+		return nil, fmt.Errorf("invalid topic %q", topic)
+	}
+	return ps.GoChannel.Subscribe(ctx, topic)
+}
+
+func TestWatcherTopicName(t *testing.T) {
+	assert.Equal(t, "github.com_sunyakun_gearbox_pkg_watch:foo", genTopicName[foo]())
+
+	ps := strictPubSub{gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})}
+	defer ps.Close()
+	p, err := NewPubSub[foo](Config{PubSub: ps})
+	assert.Nil(t, err)
+	defer p.Close()
+	assert.Nil(t, p.Publish(context.Background(), EventTypeCreated, &foo{Name: "foo"}, nil))
+}
+
+func TestWatcherOwnedPubSub(t *testing.T) {
+	p, err := NewPubSub[foo](Config{})
+	assert.Nil(t, err)
+	ps := p.(*pubwatcher[foo]).pubsub
+	assert.Nil(t, p.Close())
+	assert.Nil(t, p.Close())
+
+	// the owned PubSub is closed
+	err = ps.Publish("topic", message.NewMessage(watermill.NewShortUUID(), nil))
+	assert.NotNil(t, err)
+}